@@ -0,0 +1,202 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const offsetsKey = attribute.Key("messaging.kafka.offsets")
+
+// commitTracker tracks the offsets marked in a consumer group session, the
+// offsets committed of them with ConsumerGroupSession.Commit and the offsets
+// marked at the ticks of sarama's auto-commit interval.
+//
+// Auto-commits of sarama cannot be observed, so offsets marked at a tick are
+// the offsets sarama commits around it unless the commit fails, not offsets
+// known to be committed.
+type commitTracker struct {
+	marked     map[topicPartition]int64
+	committed  map[topicPartition]int64
+	tickMarked map[topicPartition]int64
+	stop       chan struct{}
+}
+
+// markOffset records offset as marked for partition of topic.
+func (h *consumerGroupHandler) markOffset(topic string, partition int32, offset int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.commits.marked != nil {
+		h.commits.marked[topicPartition{topic: topic, partition: partition}] = offset
+	}
+}
+
+// startCommitTicks starts recording the marked offsets of session in the
+// auto-commit interval, until stopCommitTicks is called.
+func (h *consumerGroupHandler) startCommitTicks(session sarama.ConsumerGroupSession) {
+	stop := make(chan struct{})
+	h.mtx.Lock()
+	h.commits = commitTracker{
+		marked:     make(map[topicPartition]int64),
+		committed:  make(map[topicPartition]int64),
+		tickMarked: make(map[topicPartition]int64),
+		stop:       stop,
+	}
+	h.mtx.Unlock()
+
+	if h.cfg.AutoCommitInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(h.cfg.AutoCommitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.snapshotMarked(session.Context(), "auto_commit.tick", func(c commitTracker) map[topicPartition]int64 {
+					return c.tickMarked
+				})
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopCommitTicks stops the ticker started by startCommitTicks.
+func (h *consumerGroupHandler) stopCommitTicks() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.commits.stop != nil {
+		close(h.commits.stop)
+	}
+	h.commits = commitTracker{}
+}
+
+// commit records the marked offsets as committed by an explicit commit.
+func (h *consumerGroupHandler) commit(ctx context.Context) {
+	h.snapshotMarked(ctx, "commit", func(c commitTracker) map[topicPartition]int64 {
+		return c.committed
+	})
+}
+
+// snapshotMarked copies the marked offsets into the map returned by into and
+// adds an event with name per topic with the offsets changed since the last
+// snapshot to the span of ctx.
+func (h *consumerGroupHandler) snapshotMarked(ctx context.Context, name string, into func(commitTracker) map[topicPartition]int64) {
+	h.mtx.Lock()
+	committed := make(map[string][]topicPartition)
+	offsets := make(map[topicPartition]int64)
+	snapshot := into(h.commits)
+	for tp, offset := range h.commits.marked {
+		if snapshot[tp] == offset {
+			continue
+		}
+		snapshot[tp] = offset
+		committed[tp.topic] = append(committed[tp.topic], tp)
+		offsets[tp] = offset
+	}
+	h.mtx.Unlock()
+
+	span := trace.SpanFromContext(ctx)
+	topics := make([]string, 0, len(committed))
+	for topic := range committed {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		tps := committed[topic]
+		sort.Slice(tps, func(i, j int) bool { return tps[i].partition < tps[j].partition })
+		ps := make([]int64, len(tps))
+		offs := make([]int64, len(tps))
+		for i, tp := range tps {
+			ps[i], offs[i] = int64(tp.partition), offsets[tp]
+		}
+		span.AddEvent(name, trace.WithAttributes(
+			semconv.MessagingDestinationName(topic),
+			partitionsKey.Int64Slice(ps),
+			offsetsKey.Int64Slice(offs),
+		))
+	}
+}
+
+// observeCommittedOffsets reports the offsets committed per partition in the
+// current consumer group session.
+func (h *consumerGroupHandler) observeCommittedOffsets(_ context.Context, o metric.Int64Observer) error {
+	return h.observeOffsets(o, func(c commitTracker) map[topicPartition]int64 { return c.committed })
+}
+
+// observeTickMarkedOffsets reports the offsets marked per partition at the
+// last auto-commit tick in the current consumer group session.
+func (h *consumerGroupHandler) observeTickMarkedOffsets(_ context.Context, o metric.Int64Observer) error {
+	return h.observeOffsets(o, func(c commitTracker) map[topicPartition]int64 { return c.tickMarked })
+}
+
+// observeOffsets reports the offsets per partition in the map returned by
+// from.
+func (h *consumerGroupHandler) observeOffsets(o metric.Int64Observer, from func(commitTracker) map[topicPartition]int64) error {
+	h.mtx.Lock()
+	offsets := from(h.commits)
+	committed := make(map[topicPartition]int64, len(offsets))
+	for tp, offset := range offsets {
+		committed[tp] = offset
+	}
+	h.mtx.Unlock()
+
+	for tp, offset := range committed {
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem("kafka"),
+			h.cfg.destinationMetricAttribute(tp.topic),
+			semconv.MessagingKafkaSourcePartition(int(tp.partition)),
+		}
+		if h.cfg.ConsumerGroupID != "" {
+			attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(h.cfg.ConsumerGroupID))
+		}
+		attrs = append(attrs, h.cfg.topicAttributes(tp.topic)...)
+		attrs = append(attrs, h.cfg.Attributes...)
+		o.Observe(offset, h.cfg.withMetricAttributes(attrs...))
+	}
+	return nil
+}
+
+// MarkMessage invokes ConsumerGroupSession.MarkMessage and records the
+// offset following msg as marked.
+func (s *consumerGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.ConsumerGroupSession.MarkMessage(msg, metadata)
+	s.h.markOffset(msg.Topic, msg.Partition, msg.Offset+1)
+}
+
+// MarkOffset invokes ConsumerGroupSession.MarkOffset and records offset as
+// marked.
+func (s *consumerGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+	s.h.markOffset(topic, partition, offset)
+}
+
+// Commit invokes ConsumerGroupSession.Commit and records the marked offsets
+// as committed.
+func (s *consumerGroupSession) Commit() {
+	s.ConsumerGroupSession.Commit()
+	s.h.commit(s.ctx)
+}
@@ -0,0 +1,99 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestConsumerGroupHandlerAutoCommit(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+
+	ctx, sessionSpan := sr.Start(context.Background(), "session")
+	session := &fakeConsumerGroupSession{ctx: ctx, claims: map[string][]int32{topic: {1}}}
+	claim := &fakeConsumerGroupClaim{topic: topic, partition: 1, messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: 41}
+	close(claim.messages)
+
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			for msg := range claim.Messages() {
+				session.MarkMessage(msg, "")
+			}
+			return nil
+		},
+	}, WithTracerProvider(sr), WithMeterProvider(mr), WithSaramaConfig(saramaConfig))
+
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	require.Eventually(t, func() bool {
+		return len(sessionSpan.(*recordedSpan).Events()) > 0
+	}, time.Second, time.Millisecond)
+	events := sessionSpan.(*recordedSpan).Events()
+	assert.Equal(t, "auto_commit.tick", events[0].name)
+	assert.Contains(t, events[0].attrs, partitionsKey.Int64Slice([]int64{1}))
+	assert.Contains(t, events[0].attrs, offsetsKey.Int64Slice([]int64{42}))
+
+	measurements := mr.Collect("messaging.kafka.consumer.auto_commit.marked_offset")
+	require.Len(t, measurements, 1)
+	assert.Equal(t, float64(42), measurements[0].value)
+	partition, _ := measurements[0].attrs.Value(semconv.MessagingKafkaSourcePartitionKey)
+	assert.Equal(t, attribute.IntValue(1), partition)
+	// Auto-commits cannot be observed, so nothing is reported as committed.
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.committed_offset"))
+
+	require.NoError(t, handler.Cleanup(session))
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.auto_commit.marked_offset"))
+}
+
+func TestConsumerGroupHandlerCommit(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+
+	ctx, sessionSpan := sr.Start(context.Background(), "session")
+	session := &fakeConsumerGroupSession{ctx: ctx}
+	claim := &fakeConsumerGroupClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage)}
+	close(claim.messages)
+
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(session sarama.ConsumerGroupSession, _ sarama.ConsumerGroupClaim) error {
+			session.MarkOffset(topic, 0, 10, "")
+			session.Commit()
+			session.Commit()
+			return nil
+		},
+	}, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	events := sessionSpan.(*recordedSpan).Events()
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0].attrs, offsetsKey.Int64Slice([]int64{10}))
+	assert.Equal(t, float64(10), mr.Collect("messaging.kafka.consumer.committed_offset")[0].value)
+}
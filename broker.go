@@ -0,0 +1,64 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// RegisterBrokerConnections exports the connections of client to the brokers
+// of the cluster as messaging.kafka.broker.connections, an observable up-down
+// counter that is 1 for each broker client is connected to and 0 for the
+// others. The broker's ID and address are recorded as attributes, so
+// connection churn shows as counters flapping between the two.
+//
+// Only brokers known from the cluster metadata are observed, seed brokers
+// client bootstrapped from are not.
+//
+// The returned registration stops the export once unregistered.
+func RegisterBrokerConnections(client sarama.Client, opts ...Option) (metric.Registration, error) {
+	cfg := newConfig(opts...)
+	connections, err := cfg.Meter.Int64ObservableUpDownCounter(
+		"messaging.kafka.broker.connections",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Connections open to brokers."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.Meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, broker := range client.Brokers() {
+			var open int64
+			if connected, _ := broker.Connected(); connected {
+				open = 1
+			}
+			attrs := []attribute.KeyValue{
+				semconv.MessagingSystem("kafka"),
+				brokerIDKey.Int(int(broker.ID())),
+			}
+			attrs = append(attrs, serverAttributes(broker.Addr())...)
+			attrs = append(attrs, cfg.Attributes...)
+			o.ObserveInt64(connections, open, cfg.withMetricAttributes(attrs...))
+		}
+		return nil
+	}, connections)
+}
@@ -0,0 +1,65 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestRegisterBrokerConnections(t *testing.T) {
+	mockBroker := sarama.NewMockBroker(t, 1)
+	defer mockBroker.Close()
+	connected := sarama.NewBroker(mockBroker.Addr())
+	require.NoError(t, connected.Open(sarama.NewConfig()))
+	defer connected.Close()
+	_, err := connected.Connected()
+	require.NoError(t, err)
+
+	mr := newMetricRecorder()
+	client := &fakeClient{brokers: []*sarama.Broker{connected, sarama.NewBroker("kafka-2:9092")}}
+	reg, err := RegisterBrokerConnections(client, WithMeterProvider(mr))
+	require.NoError(t, err)
+
+	host, port, err := net.SplitHostPort(mockBroker.Addr())
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	assert.Equal(t, []measurement{
+		{value: 1, attrs: attribute.NewSet(
+			semconv.MessagingSystem("kafka"),
+			brokerIDKey.Int(-1),
+			serverAddressKey.String(host),
+			serverPortKey.Int(portNum),
+		)},
+		{value: 0, attrs: attribute.NewSet(
+			semconv.MessagingSystem("kafka"),
+			brokerIDKey.Int(-1),
+			serverAddressKey.String("kafka-2"),
+			serverPortKey.Int(9092),
+		)},
+	}, mr.Collect("messaging.kafka.broker.connections"))
+
+	require.NoError(t, reg.Unregister())
+	assert.Empty(t, mr.Collect("messaging.kafka.broker.connections"))
+}
@@ -0,0 +1,167 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	clientOperationKey = attribute.Key("messaging.kafka.client.operation")
+	metadataTopicsKey  = attribute.Key("messaging.kafka.metadata.topics")
+)
+
+type client struct {
+	sarama.Client
+
+	cfg      config
+	duration metric.Float64Histogram
+}
+
+// WrapClient wraps a sarama.Client causing metadata refreshes, coordinator
+// lookups and leader lookups to be traced and their durations to be
+// recorded. Producers, consumers and consumer groups created from the
+// wrapped client look up leaders and refresh metadata through it.
+//
+// Metadata refreshes sarama schedules in the background are made by the
+// wrapped client itself and are not traced.
+func WrapClient(c sarama.Client, opts ...Option) sarama.Client {
+	if wrapped, ok := c.(*client); ok {
+		return wrapped
+	}
+	cfg := newConfig(opts...)
+
+	return &client{
+		Client: c,
+		cfg:    cfg,
+		duration: cfg.durationHistogram(
+			"messaging.kafka.client.operation.duration",
+			"Duration of metadata operations of clients.",
+		),
+	}
+}
+
+// unwrapClient returns the client c wraps, if c is wrapped by WrapClient, so
+// lookups of the instrumentation itself are not traced.
+func unwrapClient(c sarama.Client) sarama.Client {
+	if wrapped, ok := c.(*client); ok {
+		return wrapped.Client
+	}
+	return c
+}
+
+func (c *client) startSpan(operation string, attrs ...attribute.KeyValue) (trace.Span, time.Time) {
+	attrs = append(attrs,
+		semconv.MessagingSystem("kafka"),
+		clientOperationKey.String(operation),
+	)
+	attrs = append(attrs, c.cfg.Attributes...)
+	_, span := c.cfg.Tracer.Start(context.Background(), operation,
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	return span, c.cfg.now()
+}
+
+func (c *client) endSpan(span trace.Span, start time.Time, operation string, err error) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		clientOperationKey.String(operation),
+	}
+	if err != nil {
+		errType := errorTypeKey.String(c.cfg.errorType(err))
+		attrs = append(attrs, errType)
+		span.SetAttributes(errType)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	attrs = append(attrs, c.cfg.Attributes...)
+	c.duration.Record(context.Background(), c.cfg.since(start).Seconds(), c.cfg.withMetricAttributes(attrs...))
+	span.End()
+}
+
+// RefreshMetadata calls sarama.Client.RefreshMetadata and traces the request.
+func (c *client) RefreshMetadata(topics ...string) error {
+	var attrs []attribute.KeyValue
+	if len(topics) > 0 {
+		attrs = append(attrs, metadataTopicsKey.StringSlice(topics))
+	}
+	span, start := c.startSpan("RefreshMetadata", attrs...)
+	err := c.Client.RefreshMetadata(topics...)
+	c.endSpan(span, start, "RefreshMetadata", err)
+	return err
+}
+
+// RefreshBrokers calls sarama.Client.RefreshBrokers and traces the request.
+func (c *client) RefreshBrokers(addrs []string) error {
+	span, start := c.startSpan("RefreshBrokers", bootstrapServersKey.StringSlice(addrs))
+	err := c.Client.RefreshBrokers(addrs)
+	c.endSpan(span, start, "RefreshBrokers", err)
+	return err
+}
+
+// RefreshController calls sarama.Client.RefreshController and traces the
+// request.
+func (c *client) RefreshController() (*sarama.Broker, error) {
+	span, start := c.startSpan("RefreshController")
+	broker, err := c.Client.RefreshController()
+	c.endSpan(span, start, "RefreshController", err)
+	return broker, err
+}
+
+// RefreshCoordinator calls sarama.Client.RefreshCoordinator and traces the
+// request.
+func (c *client) RefreshCoordinator(consumerGroup string) error {
+	span, start := c.startSpan("RefreshCoordinator", semconv.MessagingKafkaConsumerGroup(consumerGroup))
+	err := c.Client.RefreshCoordinator(consumerGroup)
+	c.endSpan(span, start, "RefreshCoordinator", err)
+	return err
+}
+
+// Leader calls sarama.Client.Leader and traces the lookup.
+func (c *client) Leader(topic string, partition int32) (*sarama.Broker, error) {
+	span, start := c.startSpan("Leader",
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaDestinationPartition(int(partition)),
+	)
+	broker, err := c.Client.Leader(topic, partition)
+	if err == nil && broker != nil {
+		span.SetAttributes(serverAttributes(broker.Addr())...)
+	}
+	c.endSpan(span, start, "Leader", err)
+	return broker, err
+}
+
+// LeaderAndEpoch calls sarama.Client.LeaderAndEpoch and traces the lookup.
+func (c *client) LeaderAndEpoch(topic string, partition int32) (*sarama.Broker, int32, error) {
+	span, start := c.startSpan("LeaderAndEpoch",
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaDestinationPartition(int(partition)),
+	)
+	broker, epoch, err := c.Client.LeaderAndEpoch(topic, partition)
+	if err == nil && broker != nil {
+		span.SetAttributes(serverAttributes(broker.Addr())...)
+	}
+	c.endSpan(span, start, "LeaderAndEpoch", err)
+	return broker, epoch, err
+}
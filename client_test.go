@@ -0,0 +1,78 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeMetadataClient struct {
+	fakeClient
+
+	err error
+}
+
+func (c *fakeMetadataClient) RefreshMetadata(...string) error { return c.err }
+
+func TestWrapClient(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	c := WrapClient(&fakeMetadataClient{}, WithTracerProvider(sr), WithMeterProvider(mr),
+		WithTimeSource(steppingClock(time.Second)))
+	assert.Same(t, c, WrapClient(c))
+
+	require.NoError(t, c.RefreshMetadata(topic))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "RefreshMetadata", spans[0].name)
+	assert.Equal(t, trace.SpanKindClient, spans[0].kind)
+	assert.Equal(t, []string{topic}, spans[0].Attributes()[metadataTopicsKey].AsStringSlice())
+	assert.Equal(t, codes.Unset, spans[0].Status())
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		clientOperationKey.String("RefreshMetadata"),
+	)}}, mr.Measurements("messaging.kafka.client.operation.duration"))
+}
+
+func TestWrapClientLeader(t *testing.T) {
+	sr := newSpanRecorder()
+	c := WrapClient(&fakeMetadataClient{}, WithTracerProvider(sr))
+
+	_, err := c.Leader(topic, 1)
+	assert.ErrorIs(t, err, sarama.ErrLeaderNotAvailable)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "Leader", spans[0].name)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	attrs := spans[0].Attributes()
+	assert.Equal(t, topic, attrs[semconv.MessagingDestinationNameKey].AsString())
+	assert.Equal(t, sarama.ErrLeaderNotAvailable.Error(), attrs[errorTypeKey].AsString())
+
+	// Leader lookups of the instrumentation itself are not traced.
+	receiveMessage(t, &sarama.ConsumerMessage{}, WithClient(c))
+	assert.Len(t, sr.Spans(), 1)
+}
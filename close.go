@@ -0,0 +1,127 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errClosedBeforeDone = errors.New("otelsarama: consumer closed before Done was called for message")
+
+// contextCloser is implemented by wrappers that complete their telemetry on
+// Close.
+type contextCloser interface {
+	closeContext(ctx context.Context) error
+}
+
+// Close closes c, a value returned by one of the Wrap functions, until ctx
+// is done, e.g. on application shutdown. Only wrapped async producers and
+// wrapped partition consumers complete the telemetry of messages in flight.
+// Async producers wait for the spans of messages still awaiting
+// acknowledgement and end them with an error status if ctx is done first.
+// Partition consumers drain the messages not read from their messages
+// channel and end the receive spans Done was not called for yet with an
+// error status. Other wrappers are closed with their Close method only.
+//
+// If ctx is done before the Close method of c returns, Close returns the
+// error of ctx and does not wait for it: c keeps closing in the background,
+// and errors it returns are dropped.
+//
+// Observable gauges are unregistered on close, so meter providers must be
+// flushed, e.g. with ForceFlush, for their last values to be exported.
+func Close(ctx context.Context, c io.Closer) error {
+	if cc, ok := c.(contextCloser); ok {
+		return cc.closeContext(ctx)
+	}
+	return closeWithContext(ctx, c.Close)
+}
+
+// closeWithContext invokes close and returns its error, or the error of ctx
+// if ctx is done first. In that case, close keeps running in a goroutine
+// that exits once it returns.
+func closeWithContext(ctx context.Context, close func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// endAbandonedSpan ends span of an operation that did not complete before
+// its wrapper was closed with err.
+func endAbandonedSpan(cfg config, span trace.Span, err error) {
+	span.SetAttributes(errorTypeKey.String(cfg.errorType(err)))
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+// closeContext closes the producer and waits until the spans of all messages
+// in flight ended. If ctx is done first, the remaining spans are ended with
+// its error.
+func (p *asyncProducer) closeContext(ctx context.Context) error {
+	err := closeWithContext(ctx, p.Close)
+	if ctx.Err() == nil {
+		select {
+		case <-p.flushed:
+			return err
+		case <-ctx.Done():
+		}
+	}
+	p.abandon(ctx.Err())
+	return ctx.Err()
+}
+
+// closeContext closes the partition consumer and waits until its dispatcher
+// stopped. Consumed messages the application did not read before are
+// drained from the messages channel, as it usually stopped reading at
+// shutdown. If ctx is done first, or receive spans deferred with
+// WithDeferredReceiveSpanEnd remain open, e.g. of drained messages, these
+// are ended with an error.
+func (pc *partitionConsumer) closeContext(ctx context.Context) error {
+	err := closeWithContext(ctx, pc.Close)
+	if ctx.Err() == nil {
+	drain:
+		for {
+			select {
+			case _, ok := <-pc.dispatcher.messages:
+				if !ok {
+					break drain
+				}
+			case <-ctx.Done():
+				break drain
+			}
+		}
+		select {
+		case <-pc.dispatcher.done:
+		case <-ctx.Done():
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		pc.dispatcher.abandonReceiveSpans(ctxErr)
+		return ctxErr
+	}
+	pc.dispatcher.abandonReceiveSpans(errClosedBeforeDone)
+	return err
+}
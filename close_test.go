@@ -0,0 +1,144 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// stuckAsyncProducer is an AsyncProducer never acknowledging messages.
+type stuckAsyncProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func (p *stuckAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *stuckAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *stuckAsyncProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *stuckAsyncProducer) Close() error                              { return nil }
+
+func TestCloseAsyncProducer(t *testing.T) {
+	sr := newSpanRecorder()
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Return.Successes = true
+	p := &stuckAsyncProducer{
+		input:     make(chan *sarama.ProducerMessage, 1),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+	producer := WrapAsyncProducer(saramaConfig, p, WithTracerProvider(sr))
+
+	producer.Input() <- &sarama.ProducerMessage{Topic: topic, Value: sarama.StringEncoder("foo")}
+	<-p.input
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, Close(ctx, producer), context.DeadlineExceeded)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].Ended())
+	assert.Equal(t, codes.Error, spans[0].Status())
+}
+
+func TestClosePartitionConsumer(t *testing.T) {
+	sr := newSpanRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithDeferredReceiveSpanEnd())
+
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1})
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: 1})
+	Done(<-pc.Messages())
+	<-pc.Messages()
+
+	require.NoError(t, Close(context.Background(), pc))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	assert.True(t, spans[0].Ended())
+	assert.Equal(t, codes.Unset, spans[0].Status())
+	assert.True(t, spans[1].Ended())
+	assert.Equal(t, codes.Error, spans[1].Status())
+}
+
+// blockingCloser is closed once release is closed.
+type blockingCloser struct {
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (c blockingCloser) Close() error {
+	<-c.release
+	close(c.closed)
+	return nil
+}
+
+func TestCloseAbandoned(t *testing.T) {
+	c := blockingCloser{release: make(chan struct{}), closed: make(chan struct{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, Close(ctx, c), context.DeadlineExceeded)
+
+	close(c.release)
+	select {
+	case <-c.closed:
+	case <-time.After(time.Second):
+		t.Fatal("close did not complete in the background")
+	}
+}
+
+func TestClosePartitionConsumerAfterReading(t *testing.T) {
+	sr := newSpanRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithDeferredReceiveSpanEnd())
+
+	for offset := int64(0); offset < 3; offset++ {
+		mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: offset})
+	}
+	// The consume loop exits after the first message, then the consumer is
+	// closed.
+	Done(<-pc.Messages())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, Close(ctx, pc))
+	require.NoError(t, ctx.Err())
+
+	spans := sr.Spans()
+	require.Len(t, spans, 3)
+	assert.Equal(t, codes.Unset, spans[0].Status())
+	for _, span := range spans[1:] {
+		assert.True(t, span.Ended())
+		assert.Equal(t, codes.Error, span.Status())
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	adminOperationKey    = attribute.Key("messaging.kafka.admin.operation")
+	adminResourceNameKey = attribute.Key("messaging.kafka.admin.resource.name")
+	adminValidateOnlyKey = attribute.Key("messaging.kafka.admin.validate_only")
+)
+
+type clusterAdmin struct {
+	sarama.ClusterAdmin
+
+	cfg config
+}
+
+// WrapClusterAdmin wraps a sarama.ClusterAdmin causing operations managing
+// topics, partitions, configs and consumer groups to be traced.
+//
+// As sarama.ClusterAdmin does not accept a context, the spans are the roots
+// of their traces.
+func WrapClusterAdmin(admin sarama.ClusterAdmin, opts ...Option) sarama.ClusterAdmin {
+	if wrapped, ok := admin.(*clusterAdmin); ok {
+		return wrapped
+	}
+	cfg := newConfig(opts...)
+
+	return &clusterAdmin{
+		ClusterAdmin: admin,
+		cfg:          cfg,
+	}
+}
+
+func (a *clusterAdmin) startSpan(operation string, attrs ...attribute.KeyValue) trace.Span {
+	attrs = append(attrs,
+		semconv.MessagingSystem("kafka"),
+		adminOperationKey.String(operation),
+	)
+	attrs = append(attrs, a.cfg.Attributes...)
+	_, span := a.cfg.Tracer.Start(context.Background(), operation,
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	return span
+}
+
+func (a *clusterAdmin) endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(errorTypeKey.String(a.cfg.errorType(err)))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// CreateTopic calls sarama.ClusterAdmin.CreateTopic and traces the request.
+func (a *clusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	span := a.startSpan("CreateTopic", semconv.MessagingDestinationName(topic), adminValidateOnlyKey.Bool(validateOnly))
+	err := a.ClusterAdmin.CreateTopic(topic, detail, validateOnly)
+	a.endSpan(span, err)
+	return err
+}
+
+// ListTopics calls sarama.ClusterAdmin.ListTopics and traces the request.
+func (a *clusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	span := a.startSpan("ListTopics")
+	topics, err := a.ClusterAdmin.ListTopics()
+	a.endSpan(span, err)
+	return topics, err
+}
+
+// DescribeTopics calls sarama.ClusterAdmin.DescribeTopics and traces the
+// request.
+func (a *clusterAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	span := a.startSpan("DescribeTopics", adminResourceNameKey.StringSlice(topics))
+	metadata, err := a.ClusterAdmin.DescribeTopics(topics)
+	a.endSpan(span, err)
+	return metadata, err
+}
+
+// DeleteTopic calls sarama.ClusterAdmin.DeleteTopic and traces the request.
+func (a *clusterAdmin) DeleteTopic(topic string) error {
+	span := a.startSpan("DeleteTopic", semconv.MessagingDestinationName(topic))
+	err := a.ClusterAdmin.DeleteTopic(topic)
+	a.endSpan(span, err)
+	return err
+}
+
+// CreatePartitions calls sarama.ClusterAdmin.CreatePartitions and traces the
+// request.
+func (a *clusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	span := a.startSpan("CreatePartitions", semconv.MessagingDestinationName(topic), adminValidateOnlyKey.Bool(validateOnly))
+	err := a.ClusterAdmin.CreatePartitions(topic, count, assignment, validateOnly)
+	a.endSpan(span, err)
+	return err
+}
+
+// AlterPartitionReassignments calls
+// sarama.ClusterAdmin.AlterPartitionReassignments and traces the request.
+func (a *clusterAdmin) AlterPartitionReassignments(topic string, assignment [][]int32) error {
+	span := a.startSpan("AlterPartitionReassignments", semconv.MessagingDestinationName(topic))
+	err := a.ClusterAdmin.AlterPartitionReassignments(topic, assignment)
+	a.endSpan(span, err)
+	return err
+}
+
+// DeleteRecords calls sarama.ClusterAdmin.DeleteRecords and traces the
+// request.
+func (a *clusterAdmin) DeleteRecords(topic string, partitionOffsets map[int32]int64) error {
+	span := a.startSpan("DeleteRecords", semconv.MessagingDestinationName(topic))
+	err := a.ClusterAdmin.DeleteRecords(topic, partitionOffsets)
+	a.endSpan(span, err)
+	return err
+}
+
+// DescribeConfig calls sarama.ClusterAdmin.DescribeConfig and traces the
+// request.
+func (a *clusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	span := a.startSpan("DescribeConfig", adminResourceNameKey.String(resource.Name))
+	entries, err := a.ClusterAdmin.DescribeConfig(resource)
+	a.endSpan(span, err)
+	return entries, err
+}
+
+// AlterConfig calls sarama.ClusterAdmin.AlterConfig and traces the request.
+func (a *clusterAdmin) AlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]*string, validateOnly bool) error {
+	span := a.startSpan("AlterConfig", adminResourceNameKey.String(name), adminValidateOnlyKey.Bool(validateOnly))
+	err := a.ClusterAdmin.AlterConfig(resourceType, name, entries, validateOnly)
+	a.endSpan(span, err)
+	return err
+}
+
+// IncrementalAlterConfig calls sarama.ClusterAdmin.IncrementalAlterConfig and
+// traces the request.
+func (a *clusterAdmin) IncrementalAlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, validateOnly bool) error {
+	span := a.startSpan("IncrementalAlterConfig", adminResourceNameKey.String(name), adminValidateOnlyKey.Bool(validateOnly))
+	err := a.ClusterAdmin.IncrementalAlterConfig(resourceType, name, entries, validateOnly)
+	a.endSpan(span, err)
+	return err
+}
+
+// ListConsumerGroups calls sarama.ClusterAdmin.ListConsumerGroups and traces
+// the request.
+func (a *clusterAdmin) ListConsumerGroups() (map[string]string, error) {
+	span := a.startSpan("ListConsumerGroups")
+	groups, err := a.ClusterAdmin.ListConsumerGroups()
+	a.endSpan(span, err)
+	return groups, err
+}
+
+// DescribeConsumerGroups calls sarama.ClusterAdmin.DescribeConsumerGroups and
+// traces the request.
+func (a *clusterAdmin) DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error) {
+	span := a.startSpan("DescribeConsumerGroups", adminResourceNameKey.StringSlice(groups))
+	descriptions, err := a.ClusterAdmin.DescribeConsumerGroups(groups)
+	a.endSpan(span, err)
+	return descriptions, err
+}
+
+// ListConsumerGroupOffsets calls sarama.ClusterAdmin.ListConsumerGroupOffsets
+// and traces the request.
+func (a *clusterAdmin) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	span := a.startSpan("ListConsumerGroupOffsets", semconv.MessagingKafkaConsumerGroup(group))
+	resp, err := a.ClusterAdmin.ListConsumerGroupOffsets(group, topicPartitions)
+	a.endSpan(span, err)
+	return resp, err
+}
+
+// DeleteConsumerGroupOffset calls
+// sarama.ClusterAdmin.DeleteConsumerGroupOffset and traces the request.
+func (a *clusterAdmin) DeleteConsumerGroupOffset(group string, topic string, partition int32) error {
+	span := a.startSpan("DeleteConsumerGroupOffset",
+		semconv.MessagingKafkaConsumerGroup(group),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaDestinationPartition(int(partition)),
+	)
+	err := a.ClusterAdmin.DeleteConsumerGroupOffset(group, topic, partition)
+	a.endSpan(span, err)
+	return err
+}
+
+// DeleteConsumerGroup calls sarama.ClusterAdmin.DeleteConsumerGroup and
+// traces the request.
+func (a *clusterAdmin) DeleteConsumerGroup(group string) error {
+	span := a.startSpan("DeleteConsumerGroup", semconv.MessagingKafkaConsumerGroup(group))
+	err := a.ClusterAdmin.DeleteConsumerGroup(group)
+	a.endSpan(span, err)
+	return err
+}
+
+// DescribeCluster calls sarama.ClusterAdmin.DescribeCluster and traces the
+// request.
+func (a *clusterAdmin) DescribeCluster() ([]*sarama.Broker, int32, error) {
+	span := a.startSpan("DescribeCluster")
+	brokers, controllerID, err := a.ClusterAdmin.DescribeCluster()
+	a.endSpan(span, err)
+	return brokers, controllerID, err
+}
@@ -0,0 +1,65 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeClusterAdmin struct {
+	sarama.ClusterAdmin
+
+	err error
+}
+
+func (a *fakeClusterAdmin) CreateTopic(string, *sarama.TopicDetail, bool) error { return a.err }
+func (a *fakeClusterAdmin) DeleteTopic(string) error                            { return a.err }
+
+func TestWrapClusterAdmin(t *testing.T) {
+	sr := newSpanRecorder()
+	admin := WrapClusterAdmin(&fakeClusterAdmin{}, WithTracerProvider(sr))
+
+	require.NoError(t, admin.CreateTopic(topic, &sarama.TopicDetail{NumPartitions: 1}, true))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "CreateTopic", spans[0].name)
+	assert.Equal(t, trace.SpanKindClient, spans[0].kind)
+	attrs := spans[0].Attributes()
+	assert.Equal(t, topic, attrs[semconv.MessagingDestinationNameKey].AsString())
+	assert.True(t, attrs[adminValidateOnlyKey].AsBool())
+	assert.Equal(t, codes.Unset, spans[0].Status())
+}
+
+func TestWrapClusterAdminError(t *testing.T) {
+	sr := newSpanRecorder()
+	admin := WrapClusterAdmin(&fakeClusterAdmin{err: sarama.ErrUnknownTopicOrPartition}, WithTracerProvider(sr))
+
+	assert.ErrorIs(t, admin.DeleteTopic(topic), sarama.ErrUnknownTopicOrPartition)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "DeleteTopic", spans[0].name)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	assert.Equal(t, sarama.ErrUnknownTopicOrPartition.Error(), spans[0].Attributes()[errorTypeKey].AsString())
+}
@@ -0,0 +1,132 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/url"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ContextCodec embeds propagation fields into the key or value of messages,
+// for messages that cannot carry them in record headers, e.g. with Kafka
+// versions before 0.11 or proxies stripping headers.
+type ContextCodec interface {
+	// Encode returns the key and value of a message with fields embedded.
+	Encode(key, value []byte, fields map[string]string) (newKey, newValue []byte)
+	// Decode returns the key and value of a message without the fields
+	// embedded into them, and the fields. It returns no fields if none are
+	// embedded.
+	Decode(key, value []byte) (newKey, newValue []byte, fields map[string]string, err error)
+}
+
+// envelopeMagic starts values wrapped by ValueEnvelopeCodec.
+var envelopeMagic = []byte{0x00, 'o', 't', 'e', 'l'}
+
+var errMalformedEnvelope = errors.New("otelsarama: malformed trace context envelope")
+
+// ValueEnvelopeCodec is a ContextCodec wrapping message values in an
+// envelope: a magic prefix, the length of the URL-encoded fields as 32-bit
+// big-endian integer, the fields and the original value. Keys are left
+// untouched, so partitioning is not affected. Tombstones are not wrapped to
+// preserve their semantics.
+type ValueEnvelopeCodec struct{}
+
+var _ ContextCodec = ValueEnvelopeCodec{}
+
+// Encode wraps value in an envelope carrying fields.
+func (ValueEnvelopeCodec) Encode(key, value []byte, fields map[string]string) ([]byte, []byte) {
+	if value == nil || len(fields) == 0 {
+		return key, value
+	}
+	vals := make(url.Values, len(fields))
+	for k, v := range fields {
+		vals.Set(k, v)
+	}
+	encoded := vals.Encode()
+
+	wrapped := make([]byte, 0, len(envelopeMagic)+4+len(encoded)+len(value))
+	wrapped = append(wrapped, envelopeMagic...)
+	wrapped = binary.BigEndian.AppendUint32(wrapped, uint32(len(encoded)))
+	wrapped = append(wrapped, encoded...)
+	wrapped = append(wrapped, value...)
+	return key, wrapped
+}
+
+// Decode unwraps value from its envelope. Values without envelope are
+// returned unchanged.
+func (ValueEnvelopeCodec) Decode(key, value []byte) ([]byte, []byte, map[string]string, error) {
+	if !bytes.HasPrefix(value, envelopeMagic) {
+		return key, value, nil, nil
+	}
+	rest := value[len(envelopeMagic):]
+	if len(rest) < 4 {
+		return key, value, nil, errMalformedEnvelope
+	}
+	n := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	if uint64(n) > uint64(len(rest)) {
+		return key, value, nil, errMalformedEnvelope
+	}
+	vals, err := url.ParseQuery(string(rest[:n]))
+	if err != nil {
+		return key, value, nil, errMalformedEnvelope
+	}
+	fields := make(map[string]string, len(vals))
+	for k := range vals {
+		fields[k] = vals.Get(k)
+	}
+	return key, rest[n:], fields, nil
+}
+
+// encodeContext embeds the fields set in carrier into the key and value of
+// msg with the codec of WithContextCodec.
+func (cfg config) encodeContext(msg *sarama.ProducerMessage, carrier propagation.MapCarrier) {
+	key, value := encode(msg.Key), encode(msg.Value)
+	newKey, newValue := cfg.ContextCodec.Encode(key, value, carrier)
+	if !bytes.Equal(newKey, key) {
+		msg.Key = sarama.ByteEncoder(newKey)
+	}
+	if !bytes.Equal(newValue, value) {
+		msg.Value = sarama.ByteEncoder(newValue)
+	}
+}
+
+// decodeContext removes the fields embedded into msg with the codec of
+// WithContextCodec and sets them as headers of msg, so they are extracted
+// like propagated headers.
+func (cfg config) decodeContext(msg *sarama.ConsumerMessage) {
+	if cfg.ContextCodec == nil {
+		return
+	}
+	key, value, fields, err := cfg.ContextCodec.Decode(msg.Key, msg.Value)
+	if err != nil {
+		cfg.handleError(err)
+		return
+	}
+	if fields == nil {
+		return
+	}
+	msg.Key, msg.Value = key, value
+	carrier := NewConsumerMessageCarrier(msg, cfg.CarrierOptions...)
+	for k, v := range fields {
+		carrier.Set(k, v)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestValueEnvelopeCodec(t *testing.T) {
+	codec := ValueEnvelopeCodec{}
+	fields := map[string]string{"traceparent": traceparent, "tracestate": "a=b&c"}
+
+	key, value := codec.Encode([]byte("key"), []byte("value"), fields)
+	assert.Equal(t, []byte("key"), key)
+	assert.NotEqual(t, []byte("value"), value)
+
+	key, value, decoded, err := codec.Decode(key, value)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, fields, decoded)
+
+	_, value = codec.Encode(nil, nil, fields)
+	assert.Nil(t, value, "tombstones are not wrapped")
+
+	_, value, decoded, err = codec.Decode(nil, []byte("plain"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain"), value)
+	assert.Nil(t, decoded)
+
+	_, _, _, err = codec.Decode(nil, append(append([]byte{}, envelopeMagic...), 0, 0, 1, 0))
+	assert.ErrorIs(t, err, errMalformedEnvelope)
+}
+
+func TestWithContextCodec(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{
+		WithTracerProvider(sr),
+		WithPropagators(propagation.TraceContext{}),
+		WithContextCodec(ValueEnvelopeCodec{}),
+	}
+	saramaConfig := newSaramaConfig()
+	saramaConfig.Version = sarama.V0_10_2_0
+
+	var produced *sarama.ProducerMessage
+	mockSyncProducer := mocks.NewSyncProducer(t, saramaConfig)
+	mockSyncProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		produced = msg
+		return nil
+	})
+	producer := WrapSyncProducer(saramaConfig, mockSyncProducer, opts...)
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: topic, Value: sarama.StringEncoder("value")})
+	require.NoError(t, err)
+	assert.Empty(t, produced.Headers)
+
+	value, err := produced.Value.Encode()
+	require.NoError(t, err)
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Value: value}, opts...)
+	assert.Equal(t, []byte("value"), received.Value)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, spans[0].sc, spans[1].parent.WithRemote(false))
+}
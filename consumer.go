@@ -15,12 +15,25 @@
 package otelsarama
 
 import (
+	"context"
+	"sync"
+
 	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/metric"
 )
 
 type partitionConsumer struct {
 	sarama.PartitionConsumer
-	dispatcher consumerMessagesDispatcher
+	dispatcher *consumerMessagesDispatcherWrapper
+	errors     <-chan *sarama.ConsumerError
+
+	highWaterMark  metric.Int64ObservableGauge
+	consumedOffset metric.Int64ObservableGauge
+	dispatchDepth  metric.Int64ObservableGauge
+	dispatchCap    metric.Int64ObservableGauge
+	registration   metric.Registration
+	unregister     sync.Once
 }
 
 // Messages returns the read channel for the messages that are returned by
@@ -29,16 +42,118 @@ func (pc *partitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
 	return pc.dispatcher.Messages()
 }
 
+// Errors returns the read channel for the errors that are returned by the
+// broker.
+func (pc *partitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return pc.errors
+}
+
+// AsyncClose stops observing the partition and invokes
+// PartitionConsumer.AsyncClose.
+func (pc *partitionConsumer) AsyncClose() {
+	pc.stopObserving()
+	pc.PartitionConsumer.AsyncClose()
+}
+
+// Close stops observing the partition, invokes PartitionConsumer.AsyncClose
+// and drains the remaining errors, which are returned as
+// sarama.ConsumerErrors like PartitionConsumer.Close does. The errors are
+// drained from the wrapped channel, as the goroutine recording them would
+// otherwise block forwarding an error nobody reads.
+func (pc *partitionConsumer) Close() error {
+	pc.stopObserving()
+	pc.PartitionConsumer.AsyncClose()
+	var errs sarama.ConsumerErrors
+	for err := range pc.errors {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (pc *partitionConsumer) stopObserving() {
+	pc.unregister.Do(func() {
+		if pc.registration == nil {
+			return
+		}
+		if err := pc.registration.Unregister(); err != nil {
+			pc.dispatcher.cfg.handleError(err)
+		}
+	})
+}
+
+// observePartition observes the high water mark offset of the partition, the
+// offset consumed last and the depth of the messages channel once its first
+// message is consumed, which tells its topic and partition.
+func (pc *partitionConsumer) observePartition(_ context.Context, o metric.Observer) error {
+	msg := pc.dispatcher.lastMessage.Load()
+	if msg == nil {
+		return nil
+	}
+	opt := pc.dispatcher.cfg.withMetricAttributes(pc.dispatcher.metricAttributes(msg)...)
+	o.ObserveInt64(pc.highWaterMark, pc.HighWaterMarkOffset(), opt)
+	o.ObserveInt64(pc.consumedOffset, msg.Offset, opt)
+	o.ObserveInt64(pc.dispatchDepth, int64(len(pc.dispatcher.messages)), opt)
+	o.ObserveInt64(pc.dispatchCap, int64(cap(pc.dispatcher.messages)), opt)
+	return nil
+}
+
 // WrapPartitionConsumer wraps a sarama.PartitionConsumer causing each received
-// message to be traced.
+// message to be traced, each returned error to be counted and the high water
+// mark offset of the partition, the offset consumed last and the depth of the
+// messages channel to be observed until the partition consumer is closed.
 func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.PartitionConsumer {
+	if wrapped, ok := pc.(*partitionConsumer); ok {
+		return wrapped
+	}
 	cfg := newConfig(opts...)
+	return wrapPartitionConsumer(pc, cfg, newDispatcherInstruments(cfg), newConsumerErrorsRecorder(cfg))
+}
 
-	dispatcher := newConsumerMessagesDispatcherWrapper(pc, cfg)
+// wrapPartitionConsumer wraps pc with the instruments and errors recorder of
+// the wrapper it is consumed with.
+func wrapPartitionConsumer(pc sarama.PartitionConsumer, cfg config, instruments *dispatcherInstruments, recorder *consumerErrorsRecorder) sarama.PartitionConsumer {
+	dispatcher := newConsumerMessagesDispatcherWrapper(pc, cfg, instruments)
 	go dispatcher.Run()
 	wrapped := &partitionConsumer{
 		PartitionConsumer: pc,
 		dispatcher:        dispatcher,
+		errors:            recorder.wrapPartitionConsumerErrors(pc.Errors()),
+	}
+	highWaterMark, err := cfg.Meter.Int64ObservableGauge(
+		"messaging.kafka.partition.high_water_mark",
+		metric.WithUnit("{offset}"),
+		metric.WithDescription("Offset the next message produced to the partition will get."),
+	)
+	if err != nil {
+		cfg.handleError(err)
+		return wrapped
+	}
+	consumedOffset, err := cfg.Meter.Int64ObservableGauge(consumedOffsetGauge.name, consumedOffsetGauge.options()...)
+	if err != nil {
+		cfg.handleError(err)
+		return wrapped
+	}
+	dispatchDepth, err := cfg.Meter.Int64ObservableGauge(dispatchDepthGauge.name, dispatchDepthGauge.options()...)
+	if err != nil {
+		cfg.handleError(err)
+		return wrapped
+	}
+	dispatchCap, err := cfg.Meter.Int64ObservableGauge(dispatchCapacityGauge.name, dispatchCapacityGauge.options()...)
+	if err != nil {
+		cfg.handleError(err)
+		return wrapped
+	}
+	wrapped.highWaterMark = highWaterMark
+	wrapped.consumedOffset = consumedOffset
+	wrapped.dispatchDepth = dispatchDepth
+	wrapped.dispatchCap = dispatchCap
+	wrapped.registration, err = cfg.Meter.RegisterCallback(wrapped.observePartition,
+		highWaterMark, consumedOffset, dispatchDepth, dispatchCap)
+	if err != nil {
+		cfg.handleError(err)
 	}
 	return wrapped
 }
@@ -46,7 +161,9 @@ func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.P
 type consumer struct {
 	sarama.Consumer
 
-	opts []Option
+	cfg         config
+	instruments *dispatcherInstruments
+	errors      *consumerErrorsRecorder
 }
 
 // ConsumePartition invokes Consumer.ConsumePartition and wraps the resulting
@@ -56,14 +173,20 @@ func (c *consumer) ConsumePartition(topic string, partition int32, offset int64)
 	if err != nil {
 		return nil, err
 	}
-	return WrapPartitionConsumer(pc, c.opts...), nil
+	return wrapPartitionConsumer(pc, c.cfg, c.instruments, c.errors), nil
 }
 
 // WrapConsumer wraps a sarama.Consumer wrapping any PartitionConsumer created
 // via Consumer.ConsumePartition.
 func WrapConsumer(c sarama.Consumer, opts ...Option) sarama.Consumer {
+	if wrapped, ok := c.(*consumer); ok {
+		return wrapped
+	}
+	cfg := newConfig(opts...)
 	return &consumer{
-		Consumer: c,
-		opts:     opts,
+		Consumer:    c,
+		cfg:         cfg,
+		instruments: newDispatcherInstruments(cfg),
+		errors:      newConsumerErrorsRecorder(cfg),
 	}
 }
@@ -15,38 +15,465 @@
 package otelsarama
 
 import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// consumerGroupGenerationIDKey is the attribute key for the generation
+	// ID of a consumer group session.
+	consumerGroupGenerationIDKey = attribute.Key("messaging.kafka.consumer.group.generation_id")
+	// consumerGroupMemberIDKey is the attribute key for the member ID of a
+	// consumer group session.
+	consumerGroupMemberIDKey = attribute.Key("messaging.kafka.consumer.group.member_id")
+	// consumerGroupTopicsKey is the attribute key for the topics a consumer
+	// group consumes.
+	consumerGroupTopicsKey = attribute.Key("messaging.kafka.consumer.group.topics")
+	// consumerGroupAssignedPartitionsKey is the attribute key for the
+	// partitions assigned to a consumer group member in a session, formatted
+	// as "<topic>-<partition>".
+	consumerGroupAssignedPartitionsKey = attribute.Key("messaging.kafka.consumer.group.assigned_partitions")
+	// consumeEndReasonKey is the attribute key for the reason a call to
+	// ConsumerGroup.Consume returned.
+	consumeEndReasonKey = attribute.Key("messaging.kafka.consumer.group.end_reason")
 )
 
 type consumerGroupHandler struct {
 	sarama.ConsumerGroupHandler
 
 	cfg config
+
+	rebalances    metric.Int64Counter
+	claimMessages metric.Int64Histogram
+	claimDuration metric.Float64Histogram
+	// instruments are shared by the dispatchers of all claims.
+	instruments *dispatcherInstruments
+
+	mtx        sync.Mutex
+	generation int32
+	assigned   int64
+	commits    commitTracker
+	// claims are the dispatchers of the claims currently consumed.
+	claims map[topicPartition]*consumerMessagesDispatcherWrapper
+	// gauges are the registrations of the gauges observing the current
+	// session.
+	gauges []metric.Registration
+}
+
+// Setup traces the start of a new consumer group session, which happens after
+// every rebalance. It implements parts of `ConsumerGroupHandler`.
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	attrs := h.groupAttributes(session.GenerationID())
+	_, span := h.cfg.Tracer.Start(session.Context(), h.spanName("setup"),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(consumerGroupMemberIDKey.String(session.MemberID())),
+		trace.WithAttributes(subscriptionAttributes(session.Context(), session.Claims())...),
+	)
+
+	var assigned int64
+	for _, partitions := range session.Claims() {
+		assigned += int64(len(partitions))
+	}
+	gauges := h.registerGauges()
+	h.mtx.Lock()
+	h.generation = session.GenerationID()
+	h.assigned = assigned
+	h.gauges = append(h.gauges, gauges...)
+	h.mtx.Unlock()
+	h.rebalances.Add(session.Context(), 1, h.cfg.withMetricAttributes(attrs...))
+	h.startCommitTicks(session)
+
+	err := h.ConsumerGroupHandler.Setup(session)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
+
+// Cleanup traces the end of a consumer group session. It implements parts of
+// `ConsumerGroupHandler`.
+func (h *consumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	attrs := h.groupAttributes(session.GenerationID())
+	_, span := h.cfg.Tracer.Start(session.Context(), h.spanName("cleanup"),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(consumerGroupMemberIDKey.String(session.MemberID())),
+	)
+
+	err := h.ConsumerGroupHandler.Cleanup(session)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	h.mtx.Lock()
+	h.assigned = 0
+	gauges := h.gauges
+	h.gauges = nil
+	h.mtx.Unlock()
+	h.cfg.unregister(gauges)
+	h.stopCommitTicks()
+
+	span.End()
+	return err
+}
+
+func (h *consumerGroupHandler) spanName(operation string) string {
+	if h.cfg.ConsumerGroupID == "" {
+		return operation
+	}
+	return h.cfg.ConsumerGroupID + " " + operation
+}
+
+func (h *consumerGroupHandler) groupAttributes(generation int32) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		consumerGroupGenerationIDKey.Int64(int64(generation)),
+	}
+	if h.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(h.cfg.ConsumerGroupID))
+	}
+	return append(attrs, h.cfg.Attributes...)
+}
+
+// observeAssignedPartitions reports the number of partitions assigned in the
+// current consumer group session.
+func (h *consumerGroupHandler) observeAssignedPartitions(_ context.Context, o metric.Int64Observer) error {
+	h.mtx.Lock()
+	generation, assigned := h.generation, h.assigned
+	h.mtx.Unlock()
+
+	o.Observe(assigned, h.cfg.withMetricAttributes(h.groupAttributes(generation)...))
+	return nil
 }
 
 // ConsumeClaim wraps the session and claim to add instruments for messages.
-// It implements parts of `ConsumerGroupHandler`.
+// The context of the wrapped session is derived from the context of the
+// session, so it is canceled on rebalance, and carries the member ID and
+// generation ID, which Instrument records on process spans, and the wrapped
+// session, which Instrument marks messages in with WithMarkOnSuccess. With
+// WithClaimSpanMode it also carries the claim span, so spans started from it
+// while consuming the claim are its children. It implements parts of
+// `ConsumerGroupHandler`.
 func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	wrappedSession := &consumerGroupSession{
+		ConsumerGroupSession: session,
+		h:                    h,
+	}
+	wrappedSession.ctx = ContextWithSession(context.WithValue(session.Context(), consumerGroupMemberKey{}, consumerGroupMember{
+		id:         session.MemberID(),
+		generation: session.GenerationID(),
+	}), wrappedSession)
+	session = wrappedSession
+
 	// Wrap claim
-	dispatcher := newConsumerMessagesDispatcherWrapper(claim, h.cfg)
+	dispatcher := newConsumerMessagesDispatcherWrapper(claim, h.cfg, h.instruments)
+	if h.cfg.ClaimSpanMode {
+		dispatcher.claimSpan = h.startClaimSpan(session, claim)
+		wrappedSession.ctx = trace.ContextWithSpan(wrappedSession.ctx, dispatcher.claimSpan)
+	}
+	switch offset := claim.InitialOffset(); offset {
+	case sarama.OffsetOldest, sarama.OffsetNewest:
+		// No offset was committed, the claim starts from the configured
+		// initial offset.
+		direction := "newest"
+		if offset == sarama.OffsetOldest {
+			direction = "oldest"
+		}
+		if h.cfg.enabled() {
+			dispatcher.recordOffsetReset(session.Context(), trace.SpanFromContext(session.Context()), claim.Topic(), claim.Partition(), direction)
+		}
+	default:
+		dispatcher.nextOffset = offset
+	}
 	go dispatcher.Run()
-	claim = &consumerGroupClaim{
+	wrapped := &consumerGroupClaim{
 		ConsumerGroupClaim: claim,
 		dispatcher:         dispatcher,
 	}
+	tp := topicPartition{topic: claim.Topic(), partition: claim.Partition()}
+	h.mtx.Lock()
+	h.claims[tp] = dispatcher
+	h.mtx.Unlock()
+	defer func() {
+		h.mtx.Lock()
+		delete(h.claims, tp)
+		h.mtx.Unlock()
+	}()
 
-	return h.ConsumerGroupHandler.ConsumeClaim(session, claim)
+	start := h.cfg.now()
+	err := h.ConsumerGroupHandler.ConsumeClaim(session, wrapped)
+	if h.cfg.DeferReceiveSpanEnd {
+		go abandonClaim(dispatcher)
+	}
+	h.recordClaim(session.Context(), claim, dispatcher.dispatched.Load(), h.cfg.since(start))
+	if span := dispatcher.claimSpan; span != nil {
+		span.SetAttributes(semconv.MessagingBatchMessageCount(int(dispatcher.claimMessages.Load())))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+	return err
+}
+
+// abandonClaim ends the receive spans deferred with
+// WithDeferredReceiveSpanEnd that remain open once the claim of dispatcher
+// was consumed, i.e. of messages Done was not called for and of messages
+// not read anymore, which are drained. sarama closes the messages channel
+// of claims only after ConsumeClaim returned, so this runs in its own
+// goroutine.
+func abandonClaim(dispatcher *consumerMessagesDispatcherWrapper) {
+	for range dispatcher.messages {
+	}
+	<-dispatcher.done
+	dispatcher.abandonReceiveSpans(errClosedBeforeDone)
+}
+
+// observeClaims returns a callback reporting value per partition currently
+// claimed, once its first message is consumed.
+func (h *consumerGroupHandler) observeClaims(value func(w *consumerMessagesDispatcherWrapper, last *sarama.ConsumerMessage) int64) metric.Int64Callback {
+	return func(_ context.Context, o metric.Int64Observer) error {
+		h.mtx.Lock()
+		dispatchers := make([]*consumerMessagesDispatcherWrapper, 0, len(h.claims))
+		for _, dispatcher := range h.claims {
+			dispatchers = append(dispatchers, dispatcher)
+		}
+		h.mtx.Unlock()
+
+		for _, dispatcher := range dispatchers {
+			msg := dispatcher.lastMessage.Load()
+			if msg == nil {
+				continue
+			}
+			o.Observe(value(dispatcher, msg), h.cfg.withMetricAttributes(dispatcher.metricAttributes(msg)...))
+		}
+		return nil
+	}
+}
+
+// recordClaim records the number of messages handed over from claim and the
+// duration of consuming it.
+func (h *consumerGroupHandler) recordClaim(ctx context.Context, claim sarama.ConsumerGroupClaim, messages int64, elapsed time.Duration) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		h.cfg.destinationMetricAttribute(claim.Topic()),
+		semconv.MessagingKafkaSourcePartition(int(claim.Partition())),
+	}
+	if h.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(h.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, h.cfg.topicAttributes(claim.Topic())...)
+	attrs = append(attrs, h.cfg.Attributes...)
+	opt := h.cfg.withMetricAttributes(attrs...)
+	h.claimMessages.Record(ctx, messages, opt)
+	h.claimDuration.Record(ctx, elapsed.Seconds(), opt)
+}
+
+// startClaimSpan starts the span consuming claim is traced in with
+// WithClaimSpanMode.
+func (h *consumerGroupHandler) startClaimSpan(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) trace.Span {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(claim.Topic()),
+		semconv.MessagingOperationReceive,
+		semconv.MessagingKafkaSourcePartition(int(claim.Partition())),
+		consumerGroupGenerationIDKey.Int64(int64(session.GenerationID())),
+		consumerGroupMemberIDKey.String(session.MemberID()),
+	}
+	attrs = append(attrs, h.cfg.operationAttributes("poll", "receive")...)
+	attrs = append(attrs, h.cfg.destinationTemplateAttributes(claim.Topic())...)
+	attrs = append(attrs, h.cfg.topicAttributes(claim.Topic())...)
+	if h.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(h.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, h.cfg.Attributes...)
+	_, span := h.cfg.Tracer.Start(session.Context(), claim.Topic()+" "+h.cfg.operationName("receive"),
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(h.cfg.consumerSpanKind()),
+	)
+	return span
 }
 
 // WrapConsumerGroupHandler wraps a sarama.ConsumerGroupHandler causing each received
 // message to be traced.
 func WrapConsumerGroupHandler(handler sarama.ConsumerGroupHandler, opts ...Option) sarama.ConsumerGroupHandler {
+	if wrapped, ok := handler.(*consumerGroupHandler); ok {
+		return wrapped
+	}
 	cfg := newConfig(opts...)
 
-	return &consumerGroupHandler{
+	h := &consumerGroupHandler{
 		ConsumerGroupHandler: handler,
 		cfg:                  cfg,
+		claims:               make(map[topicPartition]*consumerMessagesDispatcherWrapper),
+		instruments:          newDispatcherInstruments(cfg),
+	}
+	h.rebalances = cfg.int64Counter(
+		"messaging.kafka.consumer.rebalances",
+		metric.WithUnit("{rebalance}"),
+		metric.WithDescription("Number of partition assignments the consumer group member received."),
+	)
+	h.claimMessages = cfg.int64Histogram(
+		"messaging.kafka.consumer.claim.messages",
+		metric.WithUnit("{message}"),
+		metric.WithDescription("Number of messages consumed per partition claim."),
+	)
+	h.claimDuration = cfg.durationHistogram(
+		"messaging.kafka.consumer.claim.duration",
+		"Duration of consuming partition claims.",
+	)
+	return h
+}
+
+// registerGauges registers the observable gauges of the handler for the
+// current session, until Cleanup unregisters them.
+func (h *consumerGroupHandler) registerGauges() []metric.Registration {
+	cfg := h.cfg
+	return []metric.Registration{
+		cfg.int64ObservableGauge(
+			"messaging.kafka.consumer.assigned_partitions",
+			h.observeAssignedPartitions,
+			metric.WithUnit("{partition}"),
+			metric.WithDescription("Number of partitions currently assigned to the consumer group member."),
+		),
+		cfg.int64ObservableGauge(
+			"messaging.kafka.consumer.committed_offset",
+			h.observeCommittedOffsets,
+			metric.WithUnit("{offset}"),
+			metric.WithDescription("Offset committed last per partition in the current consumer group session."),
+		),
+		cfg.int64ObservableGauge(
+			"messaging.kafka.consumer.auto_commit.marked_offset",
+			h.observeTickMarkedOffsets,
+			metric.WithUnit("{offset}"),
+			metric.WithDescription("Offset marked per partition at the last auto-commit tick in the current consumer group session, which sarama commits unless the commit fails."),
+		),
+		cfg.int64ObservableGauge(consumedOffsetGauge.name,
+			h.observeClaims(func(_ *consumerMessagesDispatcherWrapper, last *sarama.ConsumerMessage) int64 {
+				return last.Offset
+			}),
+			consumedOffsetGauge.options()...,
+		),
+		cfg.int64ObservableGauge(dispatchDepthGauge.name,
+			h.observeClaims(func(w *consumerMessagesDispatcherWrapper, _ *sarama.ConsumerMessage) int64 {
+				return int64(len(w.messages))
+			}),
+			dispatchDepthGauge.options()...,
+		),
+		cfg.int64ObservableGauge(dispatchCapacityGauge.name,
+			h.observeClaims(func(w *consumerMessagesDispatcherWrapper, _ *sarama.ConsumerMessage) int64 {
+				return int64(cap(w.messages))
+			}),
+			dispatchCapacityGauge.options()...,
+		),
+	}
+}
+
+// consumerGroupMemberKey is the context key of the consumerGroupMember a
+// context belongs to.
+type consumerGroupMemberKey struct{}
+
+type consumerGroupMember struct {
+	id         string
+	generation int32
+}
+
+// consumerGroupSessionKey is the context key of the consumer group session
+// messages of a context are consumed in.
+type consumerGroupSessionKey struct{}
+
+// ContextWithSession returns a copy of ctx carrying session, so handlers
+// wrapped by Instrument with WithMarkOnSuccess mark processed messages in
+// it. The session context passed to handlers wrapped by
+// WrapConsumerGroupHandler carries the session already.
+func ContextWithSession(ctx context.Context, session sarama.ConsumerGroupSession) context.Context {
+	return context.WithValue(ctx, consumerGroupSessionKey{}, session)
+}
+
+// consumerGroupMemberAttributes returns the attributes describing the
+// consumer group member ctx belongs to, if any.
+func consumerGroupMemberAttributes(ctx context.Context) []attribute.KeyValue {
+	member, ok := ctx.Value(consumerGroupMemberKey{}).(consumerGroupMember)
+	if !ok {
+		return nil
+	}
+	return []attribute.KeyValue{
+		consumerGroupMemberIDKey.String(member.id),
+		consumerGroupGenerationIDKey.Int64(int64(member.generation)),
+	}
+}
+
+// maxSubscriptionAttributeEntries is the maximum number of topics and
+// partitions recorded in subscription attributes. Further entries are dropped.
+const maxSubscriptionAttributeEntries = 128
+
+// subscribedTopicsKey is the context key for the topics passed to
+// ConsumerGroup.Consume.
+type subscribedTopicsKey struct{}
+
+// subscriptionAttributes returns the attributes for the topics subscribed
+// with ctx, if known, and the partitions assigned in claims.
+func subscriptionAttributes(ctx context.Context, claims map[string][]int32) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if topics, ok := ctx.Value(subscribedTopicsKey{}).([]string); ok {
+		attrs = append(attrs, consumerGroupTopicsKey.StringSlice(truncateEntries(topics)))
 	}
+	return append(attrs, assignedPartitionsAttribute(claims))
+}
+
+// assignedPartitionsAttribute returns the attribute listing the partitions
+// in claims, sorted by topic and partition.
+func assignedPartitionsAttribute(claims map[string][]int32) attribute.KeyValue {
+	topics := make([]string, 0, len(claims))
+	for topic := range claims {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var assigned []string
+	for _, topic := range topics {
+		partitions := append([]int32(nil), claims[topic]...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		for _, partition := range partitions {
+			if len(assigned) == maxSubscriptionAttributeEntries {
+				return consumerGroupAssignedPartitionsKey.StringSlice(assigned)
+			}
+			assigned = append(assigned, topic+"-"+strconv.FormatInt(int64(partition), 10))
+		}
+	}
+	return consumerGroupAssignedPartitionsKey.StringSlice(assigned)
+}
+
+// truncateEntries returns the first maxSubscriptionAttributeEntries entries.
+func truncateEntries(entries []string) []string {
+	if len(entries) > maxSubscriptionAttributeEntries {
+		return entries[:maxSubscriptionAttributeEntries]
+	}
+	return entries
+}
+
+type consumerGroupSession struct {
+	sarama.ConsumerGroupSession
+	h   *consumerGroupHandler
+	ctx context.Context
+}
+
+func (s *consumerGroupSession) Context() context.Context {
+	return s.ctx
 }
 
 type consumerGroupClaim struct {
@@ -57,3 +484,132 @@ type consumerGroupClaim struct {
 func (c *consumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
 	return c.dispatcher.Messages()
 }
+
+type consumerGroup struct {
+	sarama.ConsumerGroup
+	cfg    config
+	errors <-chan error
+	pauses metric.Int64Counter
+
+	registration metric.Registration
+	unregister   sync.Once
+
+	mtx sync.Mutex
+	// ctx is the context of the current Consume call.
+	ctx context.Context
+	// claims are the partitions claimed in the current session, paused are
+	// the partitions paused in it.
+	claims map[string][]int32
+	paused map[topicPartition]struct{}
+}
+
+// Consume invokes ConsumerGroup.Consume, tracking the partitions claimed in
+// the sessions. The call is traced in a span recording the topics, the
+// partitions assigned, the generation and member ID of the session and why
+// the call returned:
+// "rebalance", "closed", "context_canceled" or "error". Its context is the
+// context of the sessions, so spans of the wrapped handler are its children
+// and pause, resume and commit events are added to it.
+func (c *consumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	span := trace.SpanFromContext(ctx)
+	if !c.cfg.TracesDisabled {
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem("kafka"),
+			consumerGroupTopicsKey.StringSlice(truncateEntries(topics)),
+		}
+		name := "consume"
+		if c.cfg.ConsumerGroupID != "" {
+			attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(c.cfg.ConsumerGroupID))
+			name = c.cfg.ConsumerGroupID + " consume"
+		}
+		attrs = append(attrs, c.cfg.Attributes...)
+		ctx, span = c.cfg.Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+		defer span.End()
+	}
+
+	ctx = context.WithValue(ctx, subscribedTopicsKey{}, topics)
+	c.mtx.Lock()
+	c.ctx = ctx
+	c.mtx.Unlock()
+	err := c.ConsumerGroup.Consume(ctx, topics, &claimTrackingHandler{ConsumerGroupHandler: handler, cg: c})
+
+	if c.cfg.TracesDisabled {
+		return err
+	}
+	var reason string
+	switch {
+	case errors.Is(err, sarama.ErrClosedConsumerGroup):
+		reason = "closed"
+	case err != nil:
+		reason = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case ctx.Err() != nil:
+		reason = "context_canceled"
+	default:
+		reason = "rebalance"
+	}
+	span.SetAttributes(consumeEndReasonKey.String(reason))
+	return err
+}
+
+// Errors returns a read channel of errors that occurred during the consumer
+// life-cycle.
+func (c *consumerGroup) Errors() <-chan error {
+	return c.errors
+}
+
+// WrapConsumerGroup wraps a sarama.ConsumerGroup causing each call to Consume
+// to be traced, each error returned by the consumer group and each pause to
+// be counted and the partitions paused in the current session to be
+// observed until the consumer group is closed. Use WrapConsumerGroupHandler
+// to trace consumed messages.
+func WrapConsumerGroup(cg sarama.ConsumerGroup, opts ...Option) sarama.ConsumerGroup {
+	if wrapped, ok := cg.(*consumerGroup); ok {
+		return wrapped
+	}
+	cfg := newConfig(opts...)
+
+	wrapped := &consumerGroup{
+		ConsumerGroup: cg,
+		cfg:           cfg,
+		errors:        newConsumerErrorsRecorder(cfg).wrapConsumerGroupErrors(cg.Errors()),
+		paused:        make(map[topicPartition]struct{}),
+		pauses: cfg.int64Counter(
+			"messaging.kafka.consumer.pauses",
+			metric.WithUnit("{pause}"),
+			metric.WithDescription("Number of times partitions of a topic were paused."),
+		),
+	}
+	wrapped.registration = cfg.int64ObservableGauge(
+		"messaging.kafka.consumer.paused_partitions",
+		wrapped.observePausedPartitions,
+		metric.WithUnit("{partition}"),
+		metric.WithDescription("Number of partitions paused in the current consumer group session."),
+	)
+	return wrapped
+}
+
+// Close stops observing the paused partitions and invokes
+// ConsumerGroup.Close. The remaining errors are drained from the wrapped
+// channel while closing, as the goroutine recording them would otherwise
+// block forwarding an error nobody reads. If ConsumerGroup.Close returns no
+// error, the last drained error is returned.
+func (c *consumerGroup) Close() error {
+	c.unregister.Do(func() {
+		c.cfg.unregister([]metric.Registration{c.registration})
+	})
+	drained := make(chan error, 1)
+	go func() {
+		var last error
+		for err := range c.errors {
+			last = err
+		}
+		drained <- last
+	}()
+	err := c.ConsumerGroup.Close()
+	if last := <-drained; err == nil {
+		err = last
+	}
+	return err
+}
@@ -14,7 +14,510 @@
 
 package otelsarama
 
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
 // TODO: add test for consumer group
 // Currently, sarama does not have a mock consumer group, so it's hard to
 // write a unit test.
 // Related PR: https://github.com/IBM/sarama/pull/1750
+
+type fakeConsumerGroupSession struct {
+	sarama.ConsumerGroupSession
+
+	ctx        context.Context
+	claims     map[string][]int32
+	memberID   string
+	generation int32
+}
+
+func (s *fakeConsumerGroupSession) Context() context.Context                    { return s.ctx }
+func (s *fakeConsumerGroupSession) Claims() map[string][]int32                  { return s.claims }
+func (s *fakeConsumerGroupSession) MemberID() string                            { return s.memberID }
+func (s *fakeConsumerGroupSession) GenerationID() int32                         { return s.generation }
+func (s *fakeConsumerGroupSession) MarkMessage(*sarama.ConsumerMessage, string) {}
+func (s *fakeConsumerGroupSession) MarkOffset(string, int32, int64, string)     {}
+func (s *fakeConsumerGroupSession) Commit()                                     {}
+
+type fakeConsumerGroupHandler struct {
+	setupErr, cleanupErr error
+	consumeClaim         func(sarama.ConsumerGroupSession, sarama.ConsumerGroupClaim) error
+}
+
+func (h fakeConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return h.setupErr }
+func (h fakeConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return h.cleanupErr }
+func (h fakeConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if h.consumeClaim == nil {
+		return nil
+	}
+	return h.consumeClaim(session, claim)
+}
+
+type fakeConsumerGroupClaim struct {
+	sarama.ConsumerGroupClaim
+
+	topic         string
+	partition     int32
+	initialOffset int64
+	messages      chan *sarama.ConsumerMessage
+}
+
+func (c *fakeConsumerGroupClaim) Topic() string                            { return c.topic }
+func (c *fakeConsumerGroupClaim) Partition() int32                         { return c.partition }
+func (c *fakeConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+func (c *fakeConsumerGroupClaim) InitialOffset() int64                     { return c.initialOffset }
+
+func TestConsumerGroupHandlerRebalance(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	session := &fakeConsumerGroupSession{
+		ctx:        context.Background(),
+		claims:     map[string][]int32{topic: {0, 1, 2}, "other": {4}},
+		generation: 7,
+	}
+
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{cleanupErr: errors.New("cleanup")},
+		WithTracerProvider(sr), WithMeterProvider(mr), WithConsumerGroupID("my-group"))
+
+	require.NoError(t, handler.Setup(session))
+
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		consumerGroupGenerationIDKey.Int64(7),
+		semconv.MessagingKafkaConsumerGroup("my-group"),
+	)
+	assert.Equal(t, []measurement{{value: 1, attrs: wantAttrs}}, mr.Measurements("messaging.kafka.consumer.rebalances"))
+	assert.Equal(t, []measurement{{value: 4, attrs: wantAttrs}}, mr.Collect("messaging.kafka.consumer.assigned_partitions"))
+
+	assert.Error(t, handler.Cleanup(session))
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.assigned_partitions"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "my-group setup", spans[0].name)
+	assert.Equal(t, codes.Unset, spans[0].Status())
+	assert.Equal(t, "my-group cleanup", spans[1].name)
+	assert.Equal(t, codes.Error, spans[1].Status())
+	assert.Equal(t, attribute.Int64Value(7), spans[1].Attributes()[consumerGroupGenerationIDKey])
+}
+
+func TestConsumerGroupHandlerMemberAttributes(t *testing.T) {
+	sr := newSpanRecorder()
+	session := &fakeConsumerGroupSession{
+		ctx:        context.Background(),
+		memberID:   "member-1",
+		generation: 3,
+	}
+	claim := &fakeConsumerGroupClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic}
+	close(claim.messages)
+
+	opts := []Option{WithTracerProvider(sr)}
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil }, opts...)
+			for msg := range claim.Messages() {
+				if err := process(session.Context(), msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, opts...)
+
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	var names []string
+	for _, span := range sr.Ended() {
+		names = append(names, span.name)
+		if span.name == "setup" || span.name == "test-topic process" {
+			assert.Equal(t, attribute.StringValue("member-1"), span.Attributes()[consumerGroupMemberIDKey], span.name)
+			assert.Equal(t, attribute.Int64Value(3), span.Attributes()[consumerGroupGenerationIDKey], span.name)
+		}
+	}
+	assert.Contains(t, names, "setup")
+	assert.Contains(t, names, "test-topic process")
+}
+
+func TestConsumerGroupHandlerWithClaimSpanMode(t *testing.T) {
+	sr := newSpanRecorder()
+	session := &fakeConsumerGroupSession{ctx: context.Background(), memberID: "member-1"}
+	claim := &fakeConsumerGroupClaim{topic: topic, partition: 2, messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 2, Offset: 10, Value: []byte("foo")}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 2, Offset: 11, Value: []byte("bar")}
+	close(claim.messages)
+
+	opts := []Option{WithTracerProvider(sr), WithPropagators(propagation.TraceContext{})}
+	var processed []*sarama.ConsumerMessage
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			for msg := range claim.Messages() {
+				processed = append(processed, msg)
+			}
+			return errors.New("claim failed")
+		},
+	}, append(opts, WithClaimSpanMode())...)
+
+	require.Error(t, handler.ConsumeClaim(session, claim))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, topic+" receive", span.name)
+	assert.True(t, span.Ended())
+	assert.Equal(t, codes.Error, span.Status())
+	attrs := span.Attributes()
+	assert.Equal(t, int64(2), attrs[semconv.MessagingBatchMessageCountKey].AsInt64())
+	assert.Equal(t, int64(2), attrs[semconv.MessagingKafkaSourcePartitionKey].AsInt64())
+	assert.Equal(t, "member-1", attrs[consumerGroupMemberIDKey].AsString())
+
+	events := span.Events()
+	require.Len(t, events, 3)
+	assert.Equal(t, "message", events[0].name)
+	assert.Equal(t, "exception", events[2].name)
+	assert.Contains(t, events[0].attrs, semconv.MessagingMessageID("10"))
+	assert.Contains(t, events[1].attrs, semconv.MessagingMessageID("11"))
+
+	require.Len(t, processed, 2)
+	for _, msg := range processed {
+		sc := trace.SpanContextFromContext(ContextFromMessage(context.Background(), msg, opts...))
+		assert.Equal(t, span.sc.SpanID(), sc.SpanID())
+	}
+}
+
+func TestConsumerGroupHandlerSessionContext(t *testing.T) {
+	sr := newSpanRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &fakeConsumerGroupSession{ctx: ctx, memberID: "member-1"}
+	claim := &fakeConsumerGroupClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Value: []byte("foo")}
+	close(claim.messages)
+
+	opts := []Option{WithTracerProvider(sr)}
+	var (
+		sessionCtx     context.Context
+		wrappedSession sarama.ConsumerGroupSession
+	)
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			sessionCtx, wrappedSession = session.Context(), session
+			process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil }, opts...)
+			for msg := range claim.Messages() {
+				if err := process(session.Context(), msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, append(opts, WithClaimSpanMode())...)
+
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	claimSpan, processSpan := spans[0], spans[1]
+	assert.Equal(t, topic+" receive", claimSpan.name)
+	assert.Equal(t, claimSpan.sc, trace.SpanContextFromContext(sessionCtx))
+	assert.Equal(t, topic+" process", processSpan.name)
+	assert.Equal(t, claimSpan.sc, processSpan.parent)
+	assert.Equal(t, wrappedSession, sessionCtx.Value(consumerGroupSessionKey{}))
+
+	require.NoError(t, sessionCtx.Err())
+	cancel()
+	assert.ErrorIs(t, sessionCtx.Err(), context.Canceled)
+}
+
+func TestWrapConsumerGroupConsume(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		expected string
+	}{
+		{name: "rebalance", ctx: context.Background(), expected: "rebalance"},
+		{name: "canceled", ctx: canceled, expected: "context_canceled"},
+		{name: "closed", ctx: context.Background(), err: sarama.ErrClosedConsumerGroup, expected: "closed"},
+		{name: "error", ctx: context.Background(), err: errors.New("setup"), expected: "error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := newSpanRecorder()
+			errs := make(chan error)
+			close(errs)
+			fake := &fakeSessionConsumerGroup{
+				fakeConsumerGroup: fakeConsumerGroup{errors: errs},
+				session:           &fakeConsumerGroupSession{generation: 5, memberID: "member-1"},
+				consume:           func() {},
+			}
+			cg := WrapConsumerGroup(fake, WithTracerProvider(sr), WithConsumerGroupID("my-group"))
+
+			err := cg.Consume(tc.ctx, []string{topic}, fakeConsumerGroupHandler{setupErr: tc.err})
+			assert.ErrorIs(t, err, tc.err)
+
+			spans := sr.Ended()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "my-group consume", spans[0].name)
+			assert.Equal(t, spans[0].sc, trace.SpanContextFromContext(fake.session.ctx))
+			attrs := spans[0].Attributes()
+			assert.Equal(t, []string{topic}, attrs[consumerGroupTopicsKey].AsStringSlice())
+			assert.Equal(t, int64(5), attrs[consumerGroupGenerationIDKey].AsInt64())
+			assert.Equal(t, "member-1", attrs[consumerGroupMemberIDKey].AsString())
+			assert.Equal(t, tc.expected, attrs[consumeEndReasonKey].AsString())
+			if tc.expected == "error" {
+				assert.Equal(t, codes.Error, spans[0].Status())
+			} else {
+				assert.Equal(t, codes.Unset, spans[0].Status())
+			}
+		})
+	}
+}
+
+func TestConsumerGroupSubscriptionAttributes(t *testing.T) {
+	sr := newSpanRecorder()
+	errs := make(chan error)
+	close(errs)
+	fake := &fakeSessionConsumerGroup{
+		fakeConsumerGroup: fakeConsumerGroup{errors: errs},
+		session: &fakeConsumerGroupSession{
+			claims: map[string][]int32{topic: {2, 0}, "other": {1}},
+		},
+		consume: func() {},
+	}
+	cg := WrapConsumerGroup(fake, WithTracerProvider(sr))
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{}, WithTracerProvider(sr))
+
+	require.NoError(t, cg.Consume(context.Background(), []string{topic, "other"}, handler))
+
+	var names []string
+	for _, span := range sr.Ended() {
+		names = append(names, span.name)
+		if span.name == "cleanup" {
+			continue
+		}
+		attrs := span.Attributes()
+		assert.Equal(t, []string{topic, "other"}, attrs[consumerGroupTopicsKey].AsStringSlice(), span.name)
+		assert.Equal(t, []string{"other-1", "test-topic-0", "test-topic-2"}, attrs[consumerGroupAssignedPartitionsKey].AsStringSlice(), span.name)
+	}
+	assert.ElementsMatch(t, []string{"consume", "setup", "cleanup"}, names)
+}
+
+func TestAssignedPartitionsAttributeTruncated(t *testing.T) {
+	partitions := make([]int32, maxSubscriptionAttributeEntries+1)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+
+	assigned := assignedPartitionsAttribute(map[string][]int32{topic: partitions}).Value.AsStringSlice()
+	assert.Len(t, assigned, maxSubscriptionAttributeEntries)
+	assert.Equal(t, "test-topic-0", assigned[0])
+}
+
+func TestConsumerGroupHandlerClaimMetrics(t *testing.T) {
+	mr := newMetricRecorder()
+	session := &fakeConsumerGroupSession{ctx: context.Background()}
+	claim := &fakeConsumerGroupClaim{topic: topic, partition: 2, messages: make(chan *sarama.ConsumerMessage, 3)}
+	for i := 0; i < 3; i++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 2, Offset: int64(i)}
+	}
+	close(claim.messages)
+
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			for range claim.Messages() {
+			}
+			return nil
+		},
+	}, WithMeterProvider(mr), WithTimeSource(steppingClock(time.Second)))
+
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(2),
+	)
+	assert.Equal(t, []measurement{{value: 3, attrs: wantAttrs}}, mr.Measurements("messaging.kafka.consumer.claim.messages"))
+	durations := mr.Measurements("messaging.kafka.consumer.claim.duration")
+	require.Len(t, durations, 1)
+	assert.Equal(t, wantAttrs, durations[0].attrs)
+	assert.Positive(t, durations[0].value)
+}
+
+func TestConsumerGroupHandlerConsumedOffset(t *testing.T) {
+	mr := newMetricRecorder()
+	session := &fakeConsumerGroupSession{ctx: context.Background()}
+	claim := &fakeConsumerGroupClaim{topic: topic, partition: 2, messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 2, Offset: 10}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 2, Offset: 11}
+	close(claim.messages)
+
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(2),
+	)
+	var observed []measurement
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			for range claim.Messages() {
+			}
+			observed = mr.Collect("messaging.kafka.consumer.offset")
+			return nil
+		},
+	}, WithMeterProvider(mr))
+
+	require.NoError(t, handler.Setup(session))
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.offset"))
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	assert.Equal(t, []measurement{{value: 11, attrs: wantAttrs}}, observed)
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.offset"))
+	require.NoError(t, handler.Cleanup(session))
+}
+
+func TestConsumerGaugesAgree(t *testing.T) {
+	mr := newMetricRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithMeterProvider(mr))
+	session := &fakeConsumerGroupSession{ctx: context.Background()}
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{}, WithMeterProvider(mr))
+	require.NoError(t, handler.Setup(session))
+
+	for _, name := range []string{
+		"messaging.kafka.consumer.offset",
+		"messaging.kafka.consumer.dispatch.depth",
+		"messaging.kafka.consumer.dispatch.capacity",
+	} {
+		descriptions := mr.Descriptions(name)
+		require.Len(t, descriptions, 2, name)
+		assert.Equal(t, descriptions[0], descriptions[1], name)
+	}
+	require.NoError(t, handler.Cleanup(session))
+	require.NoError(t, pc.Close())
+}
+
+func TestConsumerGroupHandlerOffsetResets(t *testing.T) {
+	testCases := []struct {
+		name          string
+		initialOffset int64
+		offsets       []int64
+		want          []string
+	}{
+		{name: "committed offset", initialOffset: 5, offsets: []int64{5, 6, 8}},
+		{name: "oldest", initialOffset: sarama.OffsetOldest, offsets: []int64{0, 1}, want: []string{"oldest"}},
+		{name: "newest", initialOffset: sarama.OffsetNewest, offsets: []int64{9}, want: []string{"newest"}},
+		{name: "forward", initialOffset: 5, offsets: []int64{7, 8}, want: []string{"forward"}},
+		{name: "backward", initialOffset: 5, offsets: []int64{5, 6, 2}, want: []string{"backward"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := newSpanRecorder()
+			mr := newMetricRecorder()
+			ctx, sessionSpan := sr.Start(context.Background(), "session")
+			session := &fakeConsumerGroupSession{ctx: ctx}
+			claim := &fakeConsumerGroupClaim{
+				topic:         topic,
+				partition:     1,
+				initialOffset: tc.initialOffset,
+				messages:      make(chan *sarama.ConsumerMessage, len(tc.offsets)),
+			}
+			for _, offset := range tc.offsets {
+				claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: offset}
+			}
+			close(claim.messages)
+
+			handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+				consumeClaim: func(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+					for range claim.Messages() {
+					}
+					return nil
+				},
+			}, WithTracerProvider(sr), WithMeterProvider(mr))
+			require.NoError(t, handler.ConsumeClaim(session, claim))
+
+			var got []string
+			for _, s := range sr.Spans() {
+				for _, e := range s.Events() {
+					if e.name != "offset_reset" {
+						continue
+					}
+					for _, kv := range e.attrs {
+						if kv.Key == offsetResetDirectionKey {
+							got = append(got, kv.Value.AsString())
+						}
+					}
+				}
+			}
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, float64(len(tc.want)), mr.Sum("messaging.kafka.consumer.offset.resets"))
+			if tc.initialOffset < 0 {
+				assert.Len(t, sessionSpan.(*recordedSpan).Events(), 1)
+			}
+		})
+	}
+}
+
+func TestConsumerGroupHandlerAbandonsDeferredReceiveSpans(t *testing.T) {
+	sr := newSpanRecorder()
+	session := &fakeConsumerGroupSession{ctx: context.Background()}
+	claim := &fakeConsumerGroupClaim{topic: topic, partition: 1, messages: make(chan *sarama.ConsumerMessage, 3)}
+	for offset := int64(0); offset < 3; offset++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: offset}
+	}
+
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{
+		consumeClaim: func(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+			Done(<-claim.Messages())
+			// The second message is read, but Done is never called for it.
+			<-claim.Messages()
+			return nil
+		},
+	}, WithTracerProvider(sr), WithDeferredReceiveSpanEnd())
+
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+	// sarama closes the claim once ConsumeClaim returned.
+	close(claim.messages)
+	require.NoError(t, handler.Cleanup(session))
+
+	receiveSpans := func() []*recordedSpan {
+		var spans []*recordedSpan
+		for _, span := range sr.Spans() {
+			if span.name == topic+" receive" {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+	require.Eventually(t, func() bool {
+		spans := receiveSpans()
+		return len(spans) == 3 && spans[1].Ended() && spans[2].Ended()
+	}, time.Second, time.Millisecond)
+	spans := receiveSpans()
+	assert.Equal(t, codes.Unset, spans[0].Status())
+	assert.Equal(t, codes.Error, spans[1].Status())
+	assert.Equal(t, codes.Error, spans[2].Status())
+}
@@ -15,13 +15,23 @@
 package otelsarama
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/IBM/sarama/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -42,6 +52,668 @@ func TestConsumerConsumePartitionWithError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+type fakeClient struct {
+	sarama.Client
+
+	leader  *sarama.Broker
+	brokers []*sarama.Broker
+}
+
+func (c *fakeClient) Brokers() []*sarama.Broker {
+	return c.brokers
+}
+
+func (c *fakeClient) Leader(string, int32) (*sarama.Broker, error) {
+	if c.leader == nil {
+		return nil, sarama.ErrLeaderNotAvailable
+	}
+	return c.leader, nil
+}
+
+// receiveMessage passes msg through a partition consumer wrapped with opts
+// and returns the message handed over to the user.
+func receiveMessage(t *testing.T, msg *sarama.ConsumerMessage, opts ...Option) *sarama.ConsumerMessage {
+	t.Helper()
+
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, opts...)
+
+	mockPartitionConsumer.YieldMessage(msg)
+	received := <-pc.Messages()
+	require.NoError(t, pc.Close())
+	// Wait for the dispatcher to finish recording the message.
+	for range pc.Messages() {
+	}
+	return received
+}
+
+func TestWrapPartitionConsumerWithClient(t *testing.T) {
+	sr := newSpanRecorder()
+	client := &fakeClient{leader: sarama.NewBroker("kafka-3:9093")}
+
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithClient(client))
+
+	// The leader is looked up in the background once the first message is
+	// consumed.
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1})
+	<-pc.Messages()
+	pc.(*partitionConsumer).dispatcher.cfg.leaders.wait()
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: 1})
+	<-pc.Messages()
+	require.NoError(t, pc.Close())
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	assert.NotContains(t, spans[0].Attributes(), serverAddressKey)
+	attrs := spans[1].Attributes()
+	assert.Equal(t, "kafka-3", attrs[serverAddressKey].AsString())
+	assert.Equal(t, int64(9093), attrs[serverPortKey].AsInt64())
+	assert.Equal(t, "kafka-3", attrs[networkPeerAddressKey].AsString())
+}
+
+func TestWrapPartitionConsumerWithUnknownLeader(t *testing.T) {
+	sr := newSpanRecorder()
+
+	receiveMessage(t, &sarama.ConsumerMessage{}, WithTracerProvider(sr), WithClient(&fakeClient{}))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.NotContains(t, spans[0].Attributes(), serverAddressKey)
+}
+
+func TestWrapPartitionConsumerWithSpanStartHook(t *testing.T) {
+	sr := newSpanRecorder()
+	hook := func(msg *sarama.ConsumerMessage) []trace.SpanStartOption {
+		return []trace.SpanStartOption{
+			trace.WithAttributes(attribute.String("tenant.id", string(msg.Key))),
+		}
+	}
+
+	receiveMessage(t, &sarama.ConsumerMessage{Key: []byte("tenant-a")}, WithTracerProvider(sr), WithSpanStartHook(hook))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "tenant-a", spans[0].Attributes()["tenant.id"].AsString())
+}
+
+func TestWrapPartitionConsumerSpanKind(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []Option
+		expected trace.SpanKind
+	}{
+		{
+			name:     "default",
+			expected: trace.SpanKindConsumer,
+		},
+		{
+			name:     "overridden",
+			opts:     []Option{WithReceiveSpanKind(trace.SpanKindInternal)},
+			expected: trace.SpanKindInternal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := newSpanRecorder()
+			receiveMessage(t, &sarama.ConsumerMessage{}, append(tc.opts, WithTracerProvider(sr))...)
+
+			spans := sr.Spans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].kind)
+		})
+	}
+}
+
+func TestWrapPartitionConsumerWithoutTraces(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+
+	msg := receiveMessage(t, &sarama.ConsumerMessage{Value: []byte("foo")},
+		WithTracerProvider(sr), WithMeterProvider(mr), WithPropagators(propagation.TraceContext{}), WithoutTraces())
+
+	assert.Empty(t, sr.Spans())
+	assert.Empty(t, msg.Headers)
+	assert.Equal(t, float64(3), mr.Sum("messaging.kafka.consumed.bytes"))
+}
+
+func TestWrapPartitionConsumerConsumedBytes(t *testing.T) {
+	mr := newMetricRecorder()
+
+	receiveMessage(t, &sarama.ConsumerMessage{Key: []byte("foo"), Value: []byte("barbaz")}, WithMeterProvider(mr))
+
+	assert.Equal(t, []measurement{{value: 9, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+	)}}, mr.Measurements("messaging.kafka.consumed.bytes"))
+}
+
+func TestWrapPartitionConsumerWithCarrierOptions(t *testing.T) {
+	sr := newSpanRecorder()
+	msg := &sarama.ConsumerMessage{Topic: topic, Partition: 1, Headers: []*sarama.RecordHeader{
+		{Key: []byte("myco-traceparent"), Value: []byte(traceparent)},
+	}}
+
+	received := receiveMessage(t, msg,
+		WithTracerProvider(sr),
+		WithPropagators(propagation.TraceContext{}),
+		WithCarrierOptions(WithHeaderPrefix("myco-")),
+	)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", spans[0].parent.TraceID().String())
+	require.Len(t, received.Headers, 1)
+	assert.Equal(t, "myco-traceparent", string(received.Headers[0].Key))
+	assert.NotEqual(t, traceparent, string(received.Headers[0].Value))
+}
+
+func TestContextFromMessage(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithPropagators(propagation.TraceContext{}), WithCarrierOptions(WithHeaderPrefix("myco-"))}
+
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, append(opts, WithTracerProvider(sr))...)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	ctx := ContextFromMessage(context.Background(), received, opts...)
+	sc := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, spans[0].sc.TraceID(), sc.TraceID())
+	assert.Equal(t, spans[0].sc.SpanID(), sc.SpanID())
+}
+
+func TestWrapPartitionConsumerWithOperationName(t *testing.T) {
+	sr := newSpanRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, WithTracerProvider(sr), WithOperationName("poll", "", ""))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, topic+" poll", spans[0].name)
+}
+
+func TestWrapPartitionConsumerReceiveStageEvents(t *testing.T) {
+	sr := newSpanRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, WithTracerProvider(sr), WithReceiveStageEvents())
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	events := spans[0].Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "context.extracted", events[0].name)
+	assert.Equal(t, "delivered.to.channel", events[1].name)
+
+	sr = newSpanRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, WithTracerProvider(sr))
+	spans = sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Empty(t, spans[0].Events())
+
+}
+
+func TestWrapPartitionConsumerReceiveStageEventsDeferred(t *testing.T) {
+	sr := newSpanRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithReceiveStageEvents(), WithDeferredReceiveSpanEnd())
+
+	// Done ends the span as soon as the message is read from the channel.
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1})
+	Done(<-pc.Messages())
+	require.NoError(t, pc.Close())
+	for range pc.Messages() {
+	}
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	events := spans[0].Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "delivered.to.channel", events[1].name)
+}
+
+func TestWrapPartitionConsumerDispatchDepth(t *testing.T) {
+	mr := newMetricRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithMeterProvider(mr), WithDispatchBufferSize(4))
+
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{})
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{})
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+	)
+	assert.Eventually(t, func() bool {
+		depth := mr.Collect("messaging.kafka.consumer.dispatch.depth")
+		return len(depth) == 1 && depth[0].value == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []measurement{{value: 4, attrs: wantAttrs}}, mr.Collect("messaging.kafka.consumer.dispatch.capacity"))
+
+	<-pc.Messages()
+	<-pc.Messages()
+	assert.Equal(t, []measurement{{value: 0, attrs: wantAttrs}}, mr.Collect("messaging.kafka.consumer.dispatch.depth"))
+	require.NoError(t, pc.Close())
+}
+
+func TestWrapPartitionConsumerMaxMessageAge(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name      string
+		timestamp time.Time
+		stale     bool
+	}{
+		{name: "stale", timestamp: now.Add(-2 * time.Minute), stale: true},
+		{name: "fresh", timestamp: now.Add(-30 * time.Second)},
+		{name: "no timestamp"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := newSpanRecorder()
+			mr := newMetricRecorder()
+			receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1, Timestamp: tc.timestamp},
+				WithTracerProvider(sr),
+				WithMeterProvider(mr),
+				WithTimeSource(func() time.Time { return now }),
+				WithMaxMessageAge(time.Minute),
+			)
+
+			spans := sr.Ended()
+			require.Len(t, spans, 1)
+			if tc.stale {
+				assert.Equal(t, attribute.BoolValue(true), spans[0].Attributes()[messageStaleKey])
+				assert.Equal(t, float64(1), mr.Sum("messaging.kafka.message.stale"))
+			} else {
+				assert.NotContains(t, spans[0].Attributes(), messageStaleKey)
+				assert.Empty(t, mr.Measurements("messaging.kafka.message.stale"))
+			}
+		})
+	}
+}
+
+func TestWrapPartitionConsumerReceiveSpanExcludesDelivery(t *testing.T) {
+	sr := newSpanRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithReceiveSpanIncludesDelivery(false))
+
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1})
+	<-pc.Messages()
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].Ended(), "receive span must end before the message is handed over")
+
+	require.NoError(t, pc.Close())
+	for range pc.Messages() {
+	}
+}
+
+func TestWrapPartitionConsumerWithDeferredReceiveSpanEnd(t *testing.T) {
+	sr := newSpanRecorder()
+
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1},
+		WithTracerProvider(sr),
+		WithDeferredReceiveSpanEnd(),
+	)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.False(t, spans[0].Ended())
+	assert.Equal(t, spans[0], trace.SpanFromContext(ContextFromMessage(context.Background(), received)))
+
+	Done(received)
+	assert.True(t, spans[0].Ended())
+	Done(received)
+	assert.Len(t, sr.Ended(), 1)
+}
+
+func TestWrapPartitionConsumerReceiveDuration(t *testing.T) {
+	mr := newMetricRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, WithMeterProvider(mr))
+
+	measurements := mr.Measurements("messaging.client.operation.duration")
+	require.Len(t, measurements, 1)
+	assert.GreaterOrEqual(t, measurements[0].value, float64(0))
+	assert.Equal(t, defaultDurationHistogramBoundaries, mr.Boundaries("messaging.client.operation.duration"))
+
+	mr = newMetricRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1},
+		WithMeterProvider(mr),
+		WithDurationHistogramBoundaries([]float64{0.1, 1}),
+	)
+	assert.Equal(t, []float64{0.1, 1}, mr.Boundaries("messaging.client.operation.duration"))
+
+	mr = newMetricRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1},
+		WithMeterProvider(mr),
+		WithTimeSource(steppingClock(time.Second)),
+	)
+	measurements = mr.Measurements("messaging.client.operation.duration")
+	require.Len(t, measurements, 1)
+	assert.Equal(t, float64(1), measurements[0].value)
+	assert.Equal(t, attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+		messagingOperationNameKey.String("receive"),
+	), measurements[0].attrs)
+	measurements = mr.Measurements("messaging.client.delivery.blocked_time")
+	require.Len(t, measurements, 1)
+	assert.Equal(t, float64(1), measurements[0].value)
+}
+
+func TestWrapPartitionConsumerTombstone(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	opts := []Option{WithTracerProvider(sr), WithMeterProvider(mr)}
+
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1, Key: []byte("foo")}, opts...)
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1, Key: []byte("foo"), Value: []byte("bar")}, opts...)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, attribute.BoolValue(true), spans[0].Attributes()[semconv.MessagingKafkaMessageTombstoneKey])
+	assert.NotContains(t, spans[1].Attributes(), semconv.MessagingKafkaMessageTombstoneKey)
+	assert.Equal(t, float64(1), mr.Sum("messaging.kafka.consumer.tombstones"))
+}
+
+func TestWrapPartitionConsumerWithMessageIDFormatter(t *testing.T) {
+	sr := newSpanRecorder()
+
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1},
+		WithTracerProvider(sr),
+		WithMessageIDFormatter(PartitionOffsetMessageID),
+	)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, fmt.Sprintf("1:%d", received.Offset), spans[0].Attributes()[semconv.MessagingMessageIDKey].AsString())
+}
+
+func TestWrapPartitionConsumerWithLogger(t *testing.T) {
+	sr := newSpanRecorder()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	msg := &sarama.ConsumerMessage{Topic: topic, Partition: 1, Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte("malformed")},
+	}}
+
+	receiveMessage(t, msg,
+		WithTracerProvider(sr),
+		WithPropagators(propagation.TraceContext{}),
+		WithLogger(logger),
+		WithTimeSource(steppingClock(dispatchStallThreshold)),
+	)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]interface{}
+		require.NoError(t, dec.Decode(&record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	assert.Equal(t, "otelsarama: extracting trace context from consumed message failed", records[0]["msg"])
+	assert.Equal(t, "malformed", records[0]["reason"])
+	assert.Equal(t, "otelsarama: dispatching consumed message stalled", records[1]["msg"])
+	for _, record := range records {
+		assert.Equal(t, "WARN", record["level"])
+		assert.Equal(t, topic, record["topic"])
+		assert.Equal(t, spans[0].sc.TraceID().String(), record["trace_id"])
+		assert.Equal(t, spans[0].sc.SpanID().String(), record["span_id"])
+	}
+}
+
+func TestWrapPartitionConsumerExtractFailure(t *testing.T) {
+	mr := newMetricRecorder()
+	msg := &sarama.ConsumerMessage{Topic: topic, Partition: 1, Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte("malformed")},
+	}}
+
+	receiveMessage(t, msg,
+		WithTracerProvider(newSpanRecorder()),
+		WithMeterProvider(mr),
+		WithPropagators(propagation.TraceContext{}),
+	)
+
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingOperationReceive,
+		errorTypeKey.String("malformed"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+	)}}, mr.Measurements("messaging.propagation.extract_failures"))
+}
+
+func TestWrapPartitionConsumerWithSpanSampler(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	opts := []Option{
+		WithTracerProvider(sr),
+		WithMeterProvider(mr),
+		WithPropagators(propagation.TraceContext{}),
+		WithSpanSampler(func(_ string, partition int32) bool { return partition != 1 }),
+	}
+	msg := &sarama.ConsumerMessage{Topic: topic, Partition: 1, Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+	}}
+
+	received := receiveMessage(t, msg, opts...)
+
+	assert.Empty(t, sr.Spans())
+	require.Len(t, received.Headers, 1)
+	assert.Equal(t, traceparent, string(received.Headers[0].Value))
+	assert.Len(t, mr.Measurements("messaging.kafka.consumed.bytes"), 1)
+}
+
+func TestWrapPartitionConsumerWithPriorityHeader(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{
+		WithTracerProvider(sr),
+		WithPropagators(propagation.TraceContext{}),
+		WithSpanSampler(func(string, int32) bool { return false }),
+		WithPriorityHeader("x-trace-priority"),
+	}
+	msg := &sarama.ConsumerMessage{Topic: topic, Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte(strings.TrimSuffix(traceparent, "01") + "00")},
+		{Key: []byte("x-trace-priority"), Value: []byte("1")},
+	}}
+
+	receiveMessage(t, msg, opts...)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].parent.IsSampled())
+	assert.Equal(t, attribute.IntValue(1), spans[0].Attributes()[samplingPriorityKey])
+}
+
+func TestWrapPartitionConsumerWithReceiveSpanRatio(t *testing.T) {
+	for _, tc := range []struct {
+		ratio float64
+		spans int
+	}{
+		{ratio: 0, spans: 0},
+		{ratio: 1, spans: 1},
+	} {
+		t.Run(fmt.Sprint(tc.ratio), func(t *testing.T) {
+			sr := newSpanRecorder()
+			mr := newMetricRecorder()
+
+			receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1},
+				WithTracerProvider(sr),
+				WithMeterProvider(mr),
+				WithReceiveSpanRatio(tc.ratio),
+			)
+
+			assert.Len(t, sr.Spans(), tc.spans)
+			assert.Len(t, mr.Measurements("messaging.kafka.consumed.bytes"), 1)
+		})
+	}
+}
+
+func TestWrapPartitionConsumerWithPreviousMessageLinks(t *testing.T) {
+	sr := newSpanRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithPreviousMessageLinks())
+
+	for i := 0; i < 2; i++ {
+		mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{})
+		<-pc.Messages()
+	}
+	require.NoError(t, pc.Close())
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	assert.Empty(t, spans[0].links)
+	require.Len(t, spans[1].links, 1)
+	assert.Equal(t, spans[0].sc, spans[1].links[0].SpanContext)
+}
+
+func TestWrapPartitionConsumerWithDestinationTemplate(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	opts := []Option{
+		WithTracerProvider(sr),
+		WithMeterProvider(mr),
+		WithDestinationTemplate(func(topic string) string {
+			if strings.HasPrefix(topic, "test-") {
+				return "test-*"
+			}
+			return ""
+		}),
+	}
+
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, opts...)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	attrs := spans[0].Attributes()
+	assert.Equal(t, topic, attrs[semconv.MessagingDestinationNameKey].AsString())
+	assert.Equal(t, "test-*", attrs[semconv.MessagingDestinationTemplateKey].AsString())
+	assert.Equal(t, []measurement{{value: 0, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationTemplate("test-*"),
+		semconv.MessagingKafkaSourcePartition(1),
+	)}}, mr.Measurements("messaging.kafka.consumed.bytes"))
+}
+
+func TestWrapPartitionConsumerWithTopicAttributeMapper(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	opts := []Option{
+		WithTracerProvider(sr),
+		WithMeterProvider(mr),
+		WithTopicAttributeMapper(func(topic string) []attribute.KeyValue {
+			tenant, _, _ := strings.Cut(topic, "-")
+			return []attribute.KeyValue{attribute.String("tenant.id", tenant)}
+		}),
+	}
+
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, opts...)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "test", spans[0].Attributes()["tenant.id"].AsString())
+	assert.Equal(t, []measurement{{value: 0, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+		attribute.String("tenant.id", "test"),
+	)}}, mr.Measurements("messaging.kafka.consumed.bytes"))
+}
+
+func TestWrapPartitionConsumerWithEnabledFunc(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	msg := &sarama.ConsumerMessage{Topic: topic, Partition: 1, Value: []byte("foo")}
+
+	received := receiveMessage(t, msg,
+		WithTracerProvider(sr),
+		WithMeterProvider(mr),
+		WithPropagators(propagation.TraceContext{}),
+		WithEnabledFunc(func() bool { return false }),
+	)
+
+	assert.Empty(t, received.Headers)
+	assert.Empty(t, sr.Spans())
+	assert.Empty(t, mr.Measurements("messaging.kafka.consumed.bytes"))
+}
+
+func TestWithPeerService(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithTracerProvider(sr), WithPeerService("orders-cluster")}
+
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, opts...)
+	require.NoError(t, Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, opts...)(context.Background(), received))
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	_, _, err := WrapSyncProducer(newSaramaConfig(), mockSyncProducer, opts...).SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 3)
+	for _, span := range spans {
+		assert.Equal(t, "orders-cluster", span.Attributes()[semconv.PeerServiceKey].AsString(), span.name)
+	}
+
+	sr = newSpanRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, WithTracerProvider(sr))
+	assert.NotContains(t, sr.Spans()[0].Attributes(), semconv.PeerServiceKey)
+}
+
+func TestWithSemConvOptIn(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithTracerProvider(sr), WithSemConvOptIn()}
+
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, opts...)
+	require.NoError(t, Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, opts...)(context.Background(), received))
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	_, _, err := WrapSyncProducer(newSaramaConfig(), mockSyncProducer, opts...).SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 3)
+	for i, want := range []struct{ name, typ string }{
+		{"poll", "receive"},
+		{"process", "process"},
+		{"send", "publish"},
+	} {
+		attrs := spans[i].Attributes()
+		assert.Equal(t, want.name, attrs[messagingOperationNameKey].AsString(), spans[i].name)
+		assert.Equal(t, want.typ, attrs[messagingOperationTypeKey].AsString(), spans[i].name)
+		assert.Contains(t, attrs, semconv.MessagingOperationKey, spans[i].name)
+	}
+
+	sr = newSpanRecorder()
+	receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, WithTracerProvider(sr))
+	assert.NotContains(t, sr.Spans()[0].Attributes(), messagingOperationTypeKey)
+}
+
 func BenchmarkWrapPartitionConsumer(b *testing.B) {
 	// Mock provider
 	provider := trace.NewNoopTracerProvider()
@@ -84,3 +756,49 @@ func createMockPartitionConsumer(b *testing.B) (*mocks.PartitionConsumer, sarama
 	require.NoError(b, err)
 	return mockPartitionConsumer, partitionConsumer
 }
+
+func TestWrapConsumerAndPartitionConsumer(t *testing.T) {
+	sr := newSpanRecorder()
+	mockConsumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := mockConsumer.ExpectConsumePartition(topic, 1, 0)
+
+	consumer := WrapConsumer(WrapConsumer(mockConsumer, WithTracerProvider(sr)))
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr))
+
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic})
+	<-pc.Messages()
+	require.NoError(t, consumer.Close())
+	for range pc.Messages() {
+	}
+
+	assert.Len(t, sr.Spans(), 1)
+}
+
+func TestWrapPartitionConsumerHighWaterMark(t *testing.T) {
+	mr := newMetricRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithMeterProvider(mr))
+
+	assert.Empty(t, mr.Collect("messaging.kafka.partition.high_water_mark"))
+
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{})
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{})
+	<-pc.Messages()
+	<-pc.Messages()
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+	)
+	assert.Equal(t, []measurement{{value: 2, attrs: wantAttrs}}, mr.Collect("messaging.kafka.partition.high_water_mark"))
+	assert.Equal(t, []measurement{{value: 1, attrs: wantAttrs}}, mr.Collect("messaging.kafka.consumer.offset"))
+
+	require.NoError(t, pc.Close())
+	assert.Empty(t, mr.Collect("messaging.kafka.partition.high_water_mark"))
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.offset"))
+}
@@ -0,0 +1,83 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DeadLetterHeaderPrefix prefixes the headers the span context of the
+	// failed process span of a dead-lettered message is stored in.
+	DeadLetterHeaderPrefix = "dlq-"
+	// DeadLetterRetryAttemptHeader is the header holding the number of
+	// times a dead-lettered message was retried.
+	DeadLetterRetryAttemptHeader = "dlq-retry-attempt"
+)
+
+// dlqRetryAttemptKey is the attribute key for the retry attempt of a
+// dead-lettered message.
+const dlqRetryAttemptKey = attribute.Key("messaging.kafka.dlq.retry_attempt")
+
+// InjectDeadLetterContext prepares msg for publishing to a dead-letter topic
+// after processing a message failed in ctx, e.g. in its process span. The
+// span context of ctx is injected into msg, so msg is published in the
+// original trace, and stored in headers prefixed with
+// DeadLetterHeaderPrefix, so it survives republishing. attempt is stored in
+// the DeadLetterRetryAttemptHeader header. The options must configure the
+// propagators and carrier options msg is produced with.
+func InjectDeadLetterContext(ctx context.Context, msg *sarama.ProducerMessage, attempt int, opts ...Option) {
+	cfg := newConfig(opts...)
+	cfg.Propagators.Inject(ctx, cfg.injectCarrier(NewProducerMessageCarrier(msg, cfg.CarrierOptions...)))
+	cfg.Propagators.Inject(ctx, NewProducerMessageCarrier(msg, WithHeaderPrefix(DeadLetterHeaderPrefix)))
+	NewProducerMessageCarrier(msg).Set(DeadLetterRetryAttemptHeader, strconv.Itoa(attempt))
+}
+
+// InstrumentDeadLetter wraps handler of messages consumed from a dead-letter
+// topic like Instrument. Process spans resume the original trace propagated
+// in messages, are linked to the failed process span stored by
+// InjectDeadLetterContext and record the retry attempt as
+// messaging.kafka.dlq.retry_attempt.
+func InstrumentDeadLetter(handler Handler, opts ...Option) Handler {
+	return Instrument(handler, append(opts, optionFunc(func(cfg *config) {
+		cfg.DeadLetter = true
+	}))...)
+}
+
+// deadLetterStartOptions returns the options linking the process span of a
+// dead-lettered msg to its failed process span and recording its retry
+// attempt.
+func (cfg config) deadLetterStartOptions(msg *sarama.ConsumerMessage) []trace.SpanStartOption {
+	if !cfg.DeadLetter {
+		return nil
+	}
+	var opts []trace.SpanStartOption
+	carrier := NewConsumerMessageCarrier(msg, WithHeaderPrefix(DeadLetterHeaderPrefix))
+	if sc := trace.SpanContextFromContext(cfg.Propagators.Extract(context.Background(), carrier)); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	if v := NewConsumerMessageCarrier(msg).Get(DeadLetterRetryAttemptHeader); v != "" {
+		if attempt, err := strconv.Atoi(v); err == nil {
+			opts = append(opts, trace.WithAttributes(dlqRetryAttemptKey.Int(attempt)))
+		}
+	}
+	return opts
+}
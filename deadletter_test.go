@@ -0,0 +1,70 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestInstrumentDeadLetter(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithTracerProvider(sr), WithPropagators(propagation.TraceContext{})}
+	ctx, failed := sr.Start(context.Background(), "failed process")
+
+	produced := &sarama.ProducerMessage{Topic: "dlq"}
+	InjectDeadLetterContext(ctx, produced, 2, opts...)
+	msg := &sarama.ConsumerMessage{Topic: "dlq"}
+	for i := range produced.Headers {
+		msg.Headers = append(msg.Headers, &produced.Headers[i])
+	}
+
+	process := InstrumentDeadLetter(func(context.Context, *sarama.ConsumerMessage) error { return nil }, opts...)
+	require.NoError(t, process(context.Background(), msg))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, failed.SpanContext().TraceID(), spans[0].parent.TraceID())
+	require.Len(t, spans[0].links, 1)
+	assert.Equal(t, failed.SpanContext().SpanID(), spans[0].links[0].SpanContext.SpanID())
+	assert.Equal(t, int64(2), spans[0].Attributes()[dlqRetryAttemptKey].AsInt64())
+}
+
+func TestInstrumentWithoutDeadLetter(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithTracerProvider(sr), WithPropagators(propagation.TraceContext{})}
+	ctx, _ := sr.Start(context.Background(), "failed process")
+
+	produced := &sarama.ProducerMessage{Topic: "dlq"}
+	InjectDeadLetterContext(ctx, produced, 2, opts...)
+	msg := &sarama.ConsumerMessage{Topic: "dlq"}
+	for i := range produced.Headers {
+		msg.Headers = append(msg.Headers, &produced.Headers[i])
+	}
+
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil }, opts...)
+	require.NoError(t, process(context.Background(), msg))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Empty(t, spans[0].links)
+	assert.NotContains(t, spans[0].Attributes(), dlqRetryAttemptKey)
+}
@@ -17,15 +17,65 @@ package otelsarama
 import (
 	"context"
 	"fmt"
-	"strconv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// dispatchStallThreshold is the time handing a message over may take before
+// the dispatcher logs it as stalled.
+const dispatchStallThreshold = 5 * time.Second
+
+// offsetResetDirectionKey is the attribute key for the direction of an
+// offset reset.
+const offsetResetDirectionKey = attribute.Key("messaging.kafka.offset.reset.direction")
+
+// gaugeDefinition defines an observable gauge created by several wrappers,
+// which must agree on its unit and description.
+type gaugeDefinition struct {
+	name, unit, description string
+}
+
+// options returns the options creating the gauge, followed by opts.
+func (d gaugeDefinition) options(opts ...metric.Int64ObservableGaugeOption) []metric.Int64ObservableGaugeOption {
+	return append([]metric.Int64ObservableGaugeOption{
+		metric.WithUnit(d.unit),
+		metric.WithDescription(d.description),
+	}, opts...)
+}
+
+// The gauges observing dispatchers of partition consumers and consumer group
+// claims.
+var (
+	consumedOffsetGauge = gaugeDefinition{
+		name:        "messaging.kafka.consumer.offset",
+		unit:        "{offset}",
+		description: "Offset of the message consumed last per partition.",
+	}
+	dispatchDepthGauge = gaugeDefinition{
+		name:        "messaging.kafka.consumer.dispatch.depth",
+		unit:        "{message}",
+		description: "Number of consumed messages buffered in the messages channel, waiting to be read.",
+	}
+	dispatchCapacityGauge = gaugeDefinition{
+		name:        "messaging.kafka.consumer.dispatch.capacity",
+		unit:        "{message}",
+		description: "Capacity of the messages channel consumed messages are handed over in.",
+	}
+)
+
+// messageStaleKey is the attribute key marking messages older than the
+// maximum age configured with WithMaxMessageAge.
+const messageStaleKey = attribute.Key("messaging.kafka.message.stale")
+
 type consumerMessagesDispatcher interface {
 	Messages() <-chan *sarama.ConsumerMessage
 }
@@ -33,15 +83,90 @@ type consumerMessagesDispatcher interface {
 type consumerMessagesDispatcherWrapper struct {
 	d        consumerMessagesDispatcher
 	messages chan *sarama.ConsumerMessage
+	// done is closed once Run returned.
+	done chan struct{}
+
+	cfg          config
+	links        *previousMessageLinks
+	receiveLinks receiveLinks
+
+	// claimSpan is the span consumed messages are recorded on as events
+	// with WithClaimSpanMode, claimMessages counts these messages.
+	claimSpan     trace.Span
+	claimMessages atomic.Int64
+
+	// lastMessage is the message consumed last, dispatched counts the
+	// messages handed over.
+	lastMessage atomic.Pointer[sarama.ConsumerMessage]
+	dispatched  atomic.Int64
+
+	// nextOffset is the offset expected next, or negative if it is unknown.
+	// firstOffset is true until the first message was consumed.
+	nextOffset  int64
+	firstOffset bool
 
-	cfg config
+	*dispatcherInstruments
 }
 
-func newConsumerMessagesDispatcherWrapper(d consumerMessagesDispatcher, cfg config) *consumerMessagesDispatcherWrapper {
+// dispatcherInstruments are the instruments of dispatchers. They are created
+// once per wrapper and shared by the dispatchers of its partitions or
+// claims.
+type dispatcherInstruments struct {
+	consumedBytes   metric.Int64Counter
+	tombstones      metric.Int64Counter
+	receiveDuration metric.Float64Histogram
+	blockedTime     metric.Float64Histogram
+	extractFailures metric.Int64Counter
+	offsetResets    metric.Int64Counter
+	staleMessages   metric.Int64Counter
+}
+
+func newDispatcherInstruments(cfg config) *dispatcherInstruments {
+	i := &dispatcherInstruments{}
+	i.consumedBytes = cfg.int64Counter(
+		"messaging.kafka.consumed.bytes",
+		metric.WithUnit("By"),
+		metric.WithDescription("Number of key and value bytes of consumed messages."),
+	)
+	i.tombstones = cfg.int64Counter(
+		"messaging.kafka.consumer.tombstones",
+		metric.WithUnit("{message}"),
+		metric.WithDescription("Number of consumed tombstones, i.e. messages without value."),
+	)
+	i.receiveDuration = cfg.durationHistogram(
+		"messaging.client.operation.duration",
+		"Duration of receive operations, excluding handing messages over.",
+	)
+	i.blockedTime = cfg.durationHistogram(
+		"messaging.client.delivery.blocked_time",
+		"Time spent blocked handing consumed messages over, until they are read from the messages channel.",
+	)
+	i.extractFailures = newExtractFailuresCounter(cfg)
+	i.offsetResets = cfg.int64Counter(
+		"messaging.kafka.consumer.offset.resets",
+		metric.WithUnit("{reset}"),
+		metric.WithDescription("Number of claims starting from the oldest or newest offset and of unexpected jumps of consumed offsets."),
+	)
+	i.staleMessages = cfg.int64Counter(
+		"messaging.kafka.message.stale",
+		metric.WithUnit("{message}"),
+		metric.WithDescription("Number of consumed messages older than the configured maximum message age."),
+	)
+	return i
+}
+
+func newConsumerMessagesDispatcherWrapper(d consumerMessagesDispatcher, cfg config, instruments *dispatcherInstruments) *consumerMessagesDispatcherWrapper {
 	return &consumerMessagesDispatcherWrapper{
 		d:        d,
-		messages: make(chan *sarama.ConsumerMessage),
+		messages: make(chan *sarama.ConsumerMessage, cfg.DispatchBufferSize),
+		done:     make(chan struct{}),
 		cfg:      cfg,
+		links:    newPreviousMessageLinks(cfg),
+
+		nextOffset:  -1,
+		firstOffset: true,
+
+		dispatcherInstruments: instruments,
 	}
 }
 
@@ -55,32 +180,264 @@ func (w *consumerMessagesDispatcherWrapper) Run() {
 	msgs := w.d.Messages()
 
 	for msg := range msgs {
-		// Extract a span context from message to link.
-		carrier := NewConsumerMessageCarrier(msg)
-		parentSpanContext := w.cfg.Propagators.Extract(context.Background(), carrier)
-
-		// Create a span.
-		attrs := []attribute.KeyValue{
-			semconv.MessagingSystem("kafka"),
-			semconv.MessagingDestinationKindTopic,
-			semconv.MessagingDestinationName(msg.Topic),
-			semconv.MessagingOperationReceive,
-			semconv.MessagingMessageID(strconv.FormatInt(msg.Offset, 10)),
-			semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+		w.cfg.decodeContext(msg)
+		w.lastMessage.Store(msg)
+		reset := w.offsetReset(msg)
+		if !w.cfg.enabled() {
+			w.messages <- msg
+			w.dispatched.Add(1)
+			continue
+		}
+
+		start := w.cfg.now()
+		ctx, span := w.startReceiveSpan(msg)
+		w.receiveLinks.record(msg, span)
+		if reset != "" {
+			w.recordOffsetReset(ctx, span, msg.Topic, msg.Partition, reset)
 		}
-		opts := []trace.SpanStartOption{
-			trace.WithAttributes(attrs...),
-			trace.WithSpanKind(trace.SpanKindConsumer),
+
+		attrs := append(w.metricAttributes(msg), w.cfg.MetricAttributeExtractor.extract(msg)...)
+		metricAttrs := w.cfg.withMetricAttributes(attrs...)
+		w.consumedBytes.Add(ctx, int64(len(msg.Key)+len(msg.Value)), metricAttrs)
+		if msg.Value == nil {
+			w.tombstones.Add(ctx, 1, metricAttrs)
+		}
+		if w.stale(msg, start) {
+			span.SetAttributes(messageStaleKey.Bool(true))
+			w.staleMessages.Add(ctx, 1, metricAttrs)
 		}
-		newCtx, span := w.cfg.Tracer.Start(parentSpanContext, fmt.Sprintf("%s receive", msg.Topic), opts...)
 
-		// Inject current span context, so consumers can use it to propagate span.
-		w.cfg.Propagators.Inject(newCtx, carrier)
+		if w.cfg.DeferReceiveSpanEnd {
+			openReceiveSpans.Store(msg, &openReceiveSpan{Span: span, dispatcher: w})
+		} else if w.cfg.ReceiveSpanExcludesDelivery {
+			span.End()
+		}
+
+		handoff := w.cfg.now()
+		w.receiveDuration.Record(ctx, handoff.Sub(start).Seconds(), w.cfg.withMetricAttributes(
+			append(attrs, messagingOperationNameKey.String("receive"))...,
+		))
+
+		// The span may be ended by Done as soon as msg is sent, so the event
+		// is added before.
+		if w.cfg.ReceiveStageEvents && !w.cfg.ReceiveSpanExcludesDelivery {
+			span.AddEvent("delivered.to.channel", trace.WithTimestamp(handoff))
+		}
 
 		// Send messages back to user.
 		w.messages <- msg
+		w.dispatched.Add(1)
+		blocked := w.cfg.since(handoff)
+		w.blockedTime.Record(ctx, blocked.Seconds(), metricAttrs)
+		if blocked >= dispatchStallThreshold {
+			w.cfg.log(ctx, slog.LevelWarn, "otelsarama: dispatching consumed message stalled",
+				slog.String("topic", msg.Topic),
+				slog.Int("partition", int(msg.Partition)),
+				slog.Int64("offset", msg.Offset),
+				slog.Duration("duration", blocked),
+			)
+		}
 
-		span.End()
+		if !w.cfg.DeferReceiveSpanEnd && !w.cfg.ReceiveSpanExcludesDelivery {
+			span.End()
+		}
 	}
+	w.receiveLinks.clear()
 	close(w.messages)
+	close(w.done)
+}
+
+// openReceiveSpans holds the receive spans of messages consumed with
+// WithDeferredReceiveSpanEnd until Done is called for them.
+var openReceiveSpans sync.Map // map[*sarama.ConsumerMessage]*openReceiveSpan
+
+// openReceiveSpan is the receive span of a message Done was not yet called
+// for, and the dispatcher that started it.
+type openReceiveSpan struct {
+	trace.Span
+	dispatcher *consumerMessagesDispatcherWrapper
+}
+
+// abandonReceiveSpans ends the receive spans started by w that Done was not
+// called for with err.
+func (w *consumerMessagesDispatcherWrapper) abandonReceiveSpans(err error) {
+	openReceiveSpans.Range(func(msg, span any) bool {
+		if span.(*openReceiveSpan).dispatcher == w {
+			openReceiveSpans.Delete(msg)
+			endAbandonedSpan(w.cfg, span.(*openReceiveSpan).Span, err)
+		}
+		return true
+	})
+}
+
+// Done ends the receive span of msg if it was consumed with
+// WithDeferredReceiveSpanEnd. It is a no-op for other messages and for
+// messages Done was already called for.
+func Done(msg *sarama.ConsumerMessage) {
+	if span, ok := openReceiveSpans.LoadAndDelete(msg); ok {
+		span.(*openReceiveSpan).End()
+	}
+}
+
+// startReceiveSpan starts the receive span of msg and injects its context
+// into the message. If tracing is disabled or the message is not sampled,
+// the message is left untouched and a non-recording span is returned.
+func (w *consumerMessagesDispatcherWrapper) startReceiveSpan(msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	prioritized := w.cfg.prioritized(msg)
+	if w.cfg.TracesDisabled || !prioritized && (!w.cfg.spanSampled(msg.Topic, msg.Partition) || !w.cfg.receiveSpanSampled()) {
+		ctx := context.Background()
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	carrier := NewConsumerMessageCarrier(msg, w.cfg.CarrierOptions...)
+	if w.claimSpan != nil {
+		return w.recordClaimMessage(msg, carrier)
+	}
+
+	// Extract a span context from message to link.
+	parentSpanContext := w.cfg.Propagators.Extract(context.Background(), carrier)
+	var extracted time.Time
+	if w.cfg.ReceiveStageEvents {
+		extracted = w.cfg.now()
+	}
+	if prioritized {
+		parentSpanContext = forceSampled(parentSpanContext)
+	}
+
+	// Create a span.
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(msg.Topic),
+		semconv.MessagingOperationReceive,
+		semconv.MessagingMessageID(w.cfg.messageID(msg)),
+		semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+	}
+	attrs = append(attrs, w.cfg.operationAttributes("poll", "receive")...)
+	attrs = append(attrs, w.cfg.destinationTemplateAttributes(msg.Topic)...)
+	attrs = append(attrs, w.cfg.topicAttributes(msg.Topic)...)
+	if msg.Value == nil {
+		attrs = append(attrs, semconv.MessagingKafkaMessageTombstone(true))
+	}
+	attrs = append(attrs, w.cfg.Attributes...)
+	if w.cfg.PeerService != "" {
+		attrs = append(attrs, semconv.PeerService(w.cfg.PeerService))
+	}
+	attrs = append(attrs, w.cfg.leaderAttributes(msg.Topic, msg.Partition)...)
+	attrs = append(attrs, headerAttributes(msg.Headers, w.cfg.HeaderAttributes)...)
+	attrs = append(attrs, w.cfg.conversationIDAttributes(msg.Headers)...)
+	attrs = append(attrs, w.cfg.keyAttributes(msg.Key)...)
+	if prioritized {
+		attrs = append(attrs, samplingPriorityKey.Int(1))
+	}
+	if w.cfg.PayloadCapture {
+		attrs = append(attrs, payloadAttributes(msg.Value, w.cfg.PayloadMaxBytes)...)
+	}
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(w.cfg.consumerSpanKind()),
+	}
+	opts = append(opts, w.links.startOptions(msg)...)
+	if w.cfg.SpanStartHook != nil {
+		opts = append(opts, w.cfg.SpanStartHook(msg)...)
+	}
+	newCtx, span := w.cfg.Tracer.Start(parentSpanContext, fmt.Sprintf("%s %s", msg.Topic, w.cfg.operationName("receive")), opts...)
+	w.links.record(msg, span)
+	if w.cfg.ReceiveStageEvents {
+		span.AddEvent("context.extracted", trace.WithTimestamp(extracted))
+	}
+
+	if reason := w.cfg.extractFailure(context.Background(), parentSpanContext, carrier); reason != "" {
+		w.cfg.recordExtractFailure(newCtx, w.extractFailures, semconv.MessagingOperationReceive, msg, reason)
+	}
+
+	// Inject current span context, so consumers can use it to propagate span.
+	w.cfg.Propagators.Inject(newCtx, carrier)
+
+	return newCtx, span
+}
+
+// recordClaimMessage records msg as event on the claim span and injects the
+// context of the claim span into the message. It returns the context of the
+// claim span and a non-recording span.
+func (w *consumerMessagesDispatcherWrapper) recordClaimMessage(msg *sarama.ConsumerMessage, carrier ConsumerMessageCarrier) (context.Context, trace.Span) {
+	w.claimMessages.Add(1)
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingMessageID(w.cfg.messageID(msg)),
+	}
+	if msg.Value == nil {
+		attrs = append(attrs, semconv.MessagingKafkaMessageTombstone(true))
+	}
+	attrs = append(attrs, w.cfg.keyAttributes(msg.Key)...)
+	w.claimSpan.AddEvent("message", trace.WithAttributes(attrs...))
+
+	ctx := trace.ContextWithSpan(context.Background(), w.claimSpan)
+	w.cfg.Propagators.Inject(ctx, carrier)
+	return ctx, trace.SpanFromContext(context.Background())
+}
+
+// stale reports whether msg was older than the maximum message age when it
+// was received at now. Messages without timestamp are never stale.
+func (w *consumerMessagesDispatcherWrapper) stale(msg *sarama.ConsumerMessage, now time.Time) bool {
+	if w.cfg.MaxMessageAge <= 0 || msg.Timestamp.IsZero() {
+		return false
+	}
+	return now.Sub(msg.Timestamp) > w.cfg.MaxMessageAge
+}
+
+// offsetReset returns the direction the offset of msg jumped in, compared to
+// the offset expected next, or an empty string if it did not jump
+// unexpectedly. Offsets jumping backward are always unexpected, offsets
+// jumping forward only for the first message, as later gaps are caused by
+// compaction and transaction markers.
+func (w *consumerMessagesDispatcherWrapper) offsetReset(msg *sarama.ConsumerMessage) string {
+	expected, first := w.nextOffset, w.firstOffset
+	w.nextOffset, w.firstOffset = msg.Offset+1, false
+	switch {
+	case expected < 0:
+		return ""
+	case msg.Offset < expected:
+		return "backward"
+	case msg.Offset > expected && first:
+		return "forward"
+	}
+	return ""
+}
+
+// recordOffsetReset counts an offset reset of partition of topic in
+// direction and adds an event for it to span.
+func (w *consumerMessagesDispatcherWrapper) recordOffsetReset(ctx context.Context, span trace.Span, topic string, partition int32, direction string) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		w.cfg.destinationMetricAttribute(topic),
+		semconv.MessagingKafkaSourcePartition(int(partition)),
+		offsetResetDirectionKey.String(direction),
+	}
+	if w.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(w.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, w.cfg.topicAttributes(topic)...)
+	attrs = append(attrs, w.cfg.Attributes...)
+	w.offsetResets.Add(ctx, 1, w.cfg.withMetricAttributes(attrs...))
+
+	span.AddEvent("offset_reset", trace.WithAttributes(
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(int(partition)),
+		offsetResetDirectionKey.String(direction),
+	))
+}
+
+// metricAttributes returns the attributes of metrics recorded for msg.
+func (w *consumerMessagesDispatcherWrapper) metricAttributes(msg *sarama.ConsumerMessage) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		w.cfg.destinationMetricAttribute(msg.Topic),
+		semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+	}
+	if w.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(w.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, w.cfg.topicAttributes(msg.Topic)...)
+	return append(attrs, w.cfg.Attributes...)
 }
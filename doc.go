@@ -19,5 +19,16 @@
 // Context propagation only works on Kafka versions higher than 0.11.0.0 which supports record headers.
 // (https://archive.apache.org/dist/kafka/0.11.0.0/RELEASE_NOTES.html)
 //
+// Wrapping a value returned by one of the Wrap functions again returns it
+// unchanged, and Instrument does not trace messages a surrounding
+// instrumented handler processes already, so nested layers of the
+// instrumentation do not record messages twice.
+//
+// The environment variables OTEL_INSTRUMENTATION_KAFKA_CAPTURE_HEADERS, a
+// comma-separated list of record headers recorded as messaging.header.<header>
+// span attributes, OTEL_INSTRUMENTATION_KAFKA_TRACES_ENABLED and
+// OTEL_INSTRUMENTATION_KAFKA_METRICS_ENABLED override the Options passed to
+// wrappers, so instrumentation can be adjusted without code changes.
+//
 // Based on: https://github.com/DataDog/dd-trace-go/tree/main/contrib/IBM/sarama.v1
 package otelsarama
@@ -0,0 +1,80 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Environment variables overriding Options, so instrumentation can be
+// configured without code changes.
+const (
+	// envCaptureHeaders is a comma-separated list of record headers recorded
+	// as messaging.header.<header> attributes of receive spans.
+	envCaptureHeaders = "OTEL_INSTRUMENTATION_KAFKA_CAPTURE_HEADERS"
+	// envTracesEnabled disables traces if false.
+	envTracesEnabled = "OTEL_INSTRUMENTATION_KAFKA_TRACES_ENABLED"
+	// envMetricsEnabled disables metrics if false.
+	envMetricsEnabled = "OTEL_INSTRUMENTATION_KAFKA_METRICS_ENABLED"
+)
+
+// applyEnv overrides cfg with the values of the environment variables set.
+// Invalid values are reported to the error handler and ignored.
+func (cfg *config) applyEnv() {
+	if v, ok := os.LookupEnv(envCaptureHeaders); ok {
+		headers := make(map[string]attribute.Key, len(cfg.HeaderAttributes))
+		for header, key := range cfg.HeaderAttributes {
+			headers[header] = key
+		}
+		for _, header := range strings.Split(v, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers[header] = headerAttributeKey(header)
+			}
+		}
+		cfg.HeaderAttributes = headers
+	}
+	if enabled, ok := cfg.envBool(envTracesEnabled); ok {
+		cfg.TracesDisabled = !enabled
+	}
+	if enabled, ok := cfg.envBool(envMetricsEnabled); ok {
+		cfg.MetricsDisabled = !enabled
+	}
+}
+
+// envBool returns the boolean value of the environment variable name, and
+// false if it is unset or invalid.
+func (cfg config) envBool(name string) (bool, bool) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return false, false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		cfg.handleError(fmt.Errorf("otelsarama: invalid value %q of %s: %w", v, name, err))
+		return false, false
+	}
+	return b, true
+}
+
+// headerAttributeKey returns the attribute key of a captured header, with
+// the header lowercased and dashes replaced by underscores.
+func headerAttributeKey(header string) attribute.Key {
+	return attribute.Key("messaging.header." + strings.ReplaceAll(strings.ToLower(header), "-", "_"))
+}
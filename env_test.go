@@ -0,0 +1,51 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestNewConfigEnv(t *testing.T) {
+	t.Setenv(envCaptureHeaders, "X-Request-ID, tenant")
+	t.Setenv(envTracesEnabled, "false")
+	t.Setenv(envMetricsEnabled, "true")
+
+	headers := map[string]attribute.Key{"origin": "app.origin"}
+	cfg := newConfig(WithHeaderAttributes(headers), WithoutMetrics())
+
+	assert.Equal(t, map[string]attribute.Key{
+		"origin":       "app.origin",
+		"X-Request-ID": "messaging.header.x_request_id",
+		"tenant":       "messaging.header.tenant",
+	}, cfg.HeaderAttributes)
+	assert.Len(t, headers, 1, "option map must not be modified")
+	assert.True(t, cfg.TracesDisabled)
+	assert.False(t, cfg.MetricsDisabled)
+}
+
+func TestNewConfigEnvInvalid(t *testing.T) {
+	t.Setenv(envMetricsEnabled, "maybe")
+
+	var errs []error
+	cfg := newConfig(WithoutMetrics(), WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	assert.True(t, cfg.MetricsDisabled)
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,143 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errorTypeKey is the attribute key describing the class of an error.
+const errorTypeKey = attribute.Key("error.type")
+
+// errorType returns a low-cardinality description of err. Kafka protocol
+// errors are described by their sarama.KError, all other errors by their
+// type. Panics of handlers wrapped by Instrument are described as "panic".
+func errorType(err error) string {
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		return "panic"
+	}
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		return kerr.Error()
+	}
+	var cerr *sarama.ConsumerError
+	if errors.As(err, &cerr) && cerr.Err != nil {
+		return errorType(cerr.Err)
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// errorType returns the error.type of err, as classified by the configured
+// ErrorTypeMapper.
+func (cfg config) errorType(err error) string {
+	if cfg.ErrorTypeMapper != nil {
+		if t := cfg.ErrorTypeMapper(err); t != "" {
+			return t
+		}
+	}
+	return errorType(err)
+}
+
+// consumerErrorsRecorder records errors returned by partition consumers and
+// consumer groups.
+type consumerErrorsRecorder struct {
+	cfg    config
+	errors metric.Int64Counter
+}
+
+func newConsumerErrorsRecorder(cfg config) *consumerErrorsRecorder {
+	r := &consumerErrorsRecorder{cfg: cfg}
+	r.errors = cfg.int64Counter(
+		"messaging.client.consumer.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Number of errors reported by consumers."),
+	)
+	return r
+}
+
+// record counts err and, if enabled, emits a receive span describing it.
+func (r *consumerErrorsRecorder) record(err error) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		errorTypeKey.String(r.cfg.errorType(err)),
+	}
+	var cerr *sarama.ConsumerError
+	if errors.As(err, &cerr) {
+		attrs = append(attrs,
+			r.cfg.destinationMetricAttribute(cerr.Topic),
+			semconv.MessagingKafkaSourcePartition(int(cerr.Partition)),
+		)
+		attrs = append(attrs, r.cfg.topicAttributes(cerr.Topic)...)
+	}
+	if r.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(r.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, r.cfg.Attributes...)
+	r.errors.Add(context.Background(), 1, r.cfg.withMetricAttributes(attrs...))
+
+	if !r.cfg.ConsumerErrorSpans {
+		return
+	}
+	name := r.cfg.operationName("receive")
+	if cerr != nil {
+		name = fmt.Sprintf("%s %s", cerr.Topic, name)
+	}
+	_, span := r.cfg.Tracer.Start(context.Background(), name,
+		trace.WithAttributes(append(attrs, semconv.MessagingOperationReceive)...),
+		trace.WithSpanKind(r.cfg.consumerSpanKind()),
+	)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+// wrapPartitionConsumerErrors forwards errs to the returned channel,
+// recording each error on the way.
+func (r *consumerErrorsRecorder) wrapPartitionConsumerErrors(errs <-chan *sarama.ConsumerError) <-chan *sarama.ConsumerError {
+	out := make(chan *sarama.ConsumerError)
+	go func() {
+		for err := range errs {
+			r.record(err)
+			out <- err
+		}
+		close(out)
+	}()
+	return out
+}
+
+// wrapConsumerGroupErrors forwards errs to the returned channel, recording
+// each error on the way.
+func (r *consumerErrorsRecorder) wrapConsumerGroupErrors(errs <-chan error) <-chan error {
+	out := make(chan error)
+	go func() {
+		for err := range errs {
+			r.record(err)
+			out <- err
+		}
+		close(out)
+	}()
+	return out
+}
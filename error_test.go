@@ -0,0 +1,180 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestErrorType(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "kafka error",
+			err:      sarama.ErrNotLeaderForPartition,
+			expected: sarama.ErrNotLeaderForPartition.Error(),
+		},
+		{
+			name:     "consumer error",
+			err:      &sarama.ConsumerError{Err: sarama.ErrOffsetOutOfRange},
+			expected: sarama.ErrOffsetOutOfRange.Error(),
+		},
+		{
+			name:     "other error",
+			err:      errors.New("boom"),
+			expected: "*errors.errorString",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, errorType(tc.err))
+		})
+	}
+}
+
+func TestConfigErrorType(t *testing.T) {
+	cfg := newConfig(WithErrorTypeMapper(func(err error) string {
+		if errors.Is(err, sarama.ErrRequestTimedOut) {
+			return "downstream_timeout"
+		}
+		return ""
+	}))
+
+	assert.Equal(t, "downstream_timeout", cfg.errorType(sarama.ErrRequestTimedOut))
+	assert.Equal(t, "*errors.errorString", cfg.errorType(errors.New("boom")))
+}
+
+func TestWrapPartitionConsumerErrors(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Consumer.Return.Errors = true
+	consumer := mocks.NewConsumer(t, saramaConfig)
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithMeterProvider(mr), WithConsumerErrorSpans())
+
+	mockPartitionConsumer.YieldError(sarama.ErrOffsetOutOfRange)
+	consumerErr := <-pc.Errors()
+	assert.ErrorIs(t, consumerErr, sarama.ErrOffsetOutOfRange)
+	require.NoError(t, pc.Close())
+
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		errorTypeKey.String(sarama.ErrOffsetOutOfRange.Error()),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(1),
+	)}}, mr.Measurements("messaging.client.consumer.errors"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, topic+" receive", spans[0].name)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	assert.Len(t, spans[0].Events(), 1)
+}
+
+type fakeConsumerGroup struct {
+	sarama.ConsumerGroup
+
+	errors chan error
+}
+
+func (c *fakeConsumerGroup) Errors() <-chan error { return c.errors }
+
+func TestWrapConsumerGroupErrors(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	cg := &fakeConsumerGroup{errors: make(chan error, 1)}
+
+	wrapped := WrapConsumerGroup(cg, WithTracerProvider(sr), WithMeterProvider(mr), WithConsumerGroupID("my-group"))
+
+	cg.errors <- errors.New("boom")
+	close(cg.errors)
+	assert.EqualError(t, <-wrapped.Errors(), "boom")
+	_, ok := <-wrapped.Errors()
+	assert.False(t, ok)
+
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		errorTypeKey.String("*errors.errorString"),
+		semconv.MessagingKafkaConsumerGroup("my-group"),
+	)}}, mr.Measurements("messaging.client.consumer.errors"))
+	assert.Empty(t, sr.Spans())
+}
+
+func TestWrapPartitionConsumerCloseWithPendingErrors(t *testing.T) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Consumer.Return.Errors = true
+	consumer := mocks.NewConsumer(t, saramaConfig)
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithMeterProvider(newMetricRecorder()))
+
+	// Nobody reads the errors.
+	mockPartitionConsumer.YieldError(sarama.ErrOffsetOutOfRange)
+	mockPartitionConsumer.YieldError(sarama.ErrNotLeaderForPartition)
+
+	var errs sarama.ConsumerErrors
+	require.ErrorAs(t, pc.Close(), &errs)
+	require.Len(t, errs, 2)
+	assert.ErrorIs(t, errs[0], sarama.ErrOffsetOutOfRange)
+	assert.ErrorIs(t, errs[1], sarama.ErrNotLeaderForPartition)
+}
+
+// closingConsumerGroup drains its errors on Close like sarama's consumer
+// group does.
+type closingConsumerGroup struct {
+	fakeConsumerGroup
+}
+
+func (c *closingConsumerGroup) Close() error {
+	close(c.errors)
+	var err error
+	for e := range c.errors {
+		err = e
+	}
+	return err
+}
+
+func TestWrapConsumerGroupCloseWithPendingErrors(t *testing.T) {
+	cg := &closingConsumerGroup{fakeConsumerGroup{errors: make(chan error, 2)}}
+	wrapped := WrapConsumerGroup(cg, WithMeterProvider(newMetricRecorder()))
+
+	// Nobody reads the errors.
+	cg.errors <- errors.New("first")
+	cg.errors <- errors.New("second")
+
+	assert.Error(t, wrapped.Close())
+	_, ok := <-wrapped.Errors()
+	assert.False(t, ok)
+}
@@ -0,0 +1,182 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AttributePreset selects which of the topic, partition, consumer group and
+// message ID attributes are recorded on metrics. Other attributes, e.g. the
+// messaging system or those mapped with WithTopicAttributeMapper, are not
+// affected.
+type AttributePreset int
+
+const (
+	// LowCardinality records the topic but not the partition, consumer
+	// group and message ID.
+	LowCardinality AttributePreset = iota + 1
+	// Standard records the topic and consumer group but not the partition
+	// and message ID.
+	Standard
+	// Detailed records the topic, partition, consumer group and message ID.
+	Detailed
+)
+
+// apply returns filter restricted to the attributes recorded with p.
+func (p AttributePreset) apply(filter attribute.Filter) attribute.Filter {
+	dropped := map[attribute.Key]bool{}
+	switch p {
+	case LowCardinality:
+		dropped[semconv.MessagingKafkaConsumerGroupKey] = true
+		fallthrough
+	case Standard:
+		dropped[semconv.MessagingKafkaSourcePartitionKey] = true
+		dropped[semconv.MessagingKafkaDestinationPartitionKey] = true
+		dropped[destinationPartitionIDKey] = true
+		dropped[semconv.MessagingMessageIDKey] = true
+	default:
+		return filter
+	}
+	return func(kv attribute.KeyValue) bool {
+		return !dropped[kv.Key] && (filter == nil || filter(kv))
+	}
+}
+
+// Limits of the attributes derived by WithMetricAttributeExtractor.
+const (
+	// maxExtractedAttributeKeys is the maximum number of distinct keys
+	// recorded, attributes with further keys are dropped.
+	maxExtractedAttributeKeys = 8
+	// maxExtractedAttributeValues is the maximum number of distinct values
+	// recorded per key, further values are recorded as
+	// extractedAttributeOverflow.
+	maxExtractedAttributeValues = 100
+)
+
+// extractedAttributeOverflow replaces the values of extracted attributes
+// exceeding maxExtractedAttributeValues.
+const extractedAttributeOverflow = "_OTHER"
+
+// metricAttributeExtractor derives metric attributes from consumed messages,
+// capping their cardinality. A nil metricAttributeExtractor derives none.
+type metricAttributeExtractor struct {
+	fn func(msg *sarama.ConsumerMessage) []attribute.KeyValue
+
+	mtx    sync.Mutex
+	values map[attribute.Key]map[attribute.Value]struct{}
+}
+
+// extract returns the attributes derived from msg, with values exceeding
+// the cardinality cap replaced and keys exceeding it dropped.
+func (e *metricAttributeExtractor) extract(msg *sarama.ConsumerMessage) []attribute.KeyValue {
+	if e == nil {
+		return nil
+	}
+	attrs := e.fn(msg)
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	capped := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		values, ok := e.values[kv.Key]
+		if !ok {
+			if len(e.values) >= maxExtractedAttributeKeys {
+				continue
+			}
+			values = make(map[attribute.Value]struct{})
+			e.values[kv.Key] = values
+		}
+		if _, ok := values[kv.Value]; !ok {
+			if len(values) >= maxExtractedAttributeValues {
+				kv = kv.Key.String(extractedAttributeOverflow)
+			} else {
+				values[kv.Value] = struct{}{}
+			}
+		}
+		capped = append(capped, kv)
+	}
+	return capped
+}
+
+// withMetricAttributes returns the option recording measurements with attrs
+// accepted by the metric attribute filter.
+func (cfg config) withMetricAttributes(attrs ...attribute.KeyValue) metric.MeasurementOption {
+	if cfg.MetricAttributeFilter == nil {
+		return metric.WithAttributes(attrs...)
+	}
+	set, _ := attribute.NewSetWithFiltered(attrs, cfg.MetricAttributeFilter)
+	return metric.WithAttributeSet(set)
+}
+
+// filterAttributes returns the attributes of attrs accepted by filter.
+func filterAttributes(attrs []attribute.KeyValue, filter attribute.Filter) []attribute.KeyValue {
+	filtered := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		if filter(kv) {
+			filtered = append(filtered, kv)
+		}
+	}
+	return filtered
+}
+
+// attributeFilteringTracer starts spans that only record the attributes
+// accepted by filter.
+type attributeFilteringTracer struct {
+	trace.Tracer
+
+	filter attribute.Filter
+}
+
+func (t attributeFilteringTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	opts = []trace.SpanStartOption{
+		trace.WithAttributes(filterAttributes(cfg.Attributes(), t.filter)...),
+		trace.WithLinks(cfg.Links()...),
+		trace.WithSpanKind(cfg.SpanKind()),
+	}
+	if cfg.NewRoot() {
+		opts = append(opts, trace.WithNewRoot())
+	}
+	if ts := cfg.Timestamp(); !ts.IsZero() {
+		opts = append(opts, trace.WithTimestamp(ts))
+	}
+
+	ctx, span := t.Tracer.Start(ctx, name, opts...)
+	span = attributeFilteringSpan{Span: span, filter: t.filter}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// attributeFilteringSpan only sets the attributes accepted by filter.
+type attributeFilteringSpan struct {
+	trace.Span
+
+	filter attribute.Filter
+}
+
+func (s attributeFilteringSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.Span.SetAttributes(filterAttributes(kv, s.filter)...)
+}
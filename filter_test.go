@@ -0,0 +1,179 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithAttributeFilters(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	withoutPartition := attribute.Filter(func(kv attribute.KeyValue) bool {
+		return kv.Key != semconv.MessagingKafkaSourcePartitionKey
+	})
+	withoutMessageID := attribute.Filter(func(kv attribute.KeyValue) bool {
+		return kv.Key != semconv.MessagingMessageIDKey
+	})
+
+	var handlerSpan trace.Span
+	process := Instrument(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+		handlerSpan = trace.SpanFromContext(ctx)
+		return nil
+	},
+		WithTracerProvider(sr),
+		WithMeterProvider(mr),
+		WithMetricAttributeFilter(withoutPartition),
+		WithSpanAttributeFilter(withoutMessageID),
+	)
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Partition: 1}))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	attrs := spans[0].Attributes()
+	assert.Contains(t, attrs, semconv.MessagingKafkaSourcePartitionKey)
+	assert.NotContains(t, attrs, semconv.MessagingMessageIDKey)
+	assert.Equal(t, spans[0].sc, handlerSpan.SpanContext())
+
+	handlerSpan.SetAttributes(semconv.MessagingMessageID("1"), semconv.MessagingConsumerID("foo"))
+	attrs = spans[0].Attributes()
+	assert.NotContains(t, attrs, semconv.MessagingMessageIDKey)
+	assert.Contains(t, attrs, semconv.MessagingConsumerIDKey)
+
+	measurements := mr.Measurements("messaging.process.duration")
+	require.Len(t, measurements, 1)
+	assert.Equal(t, attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+	), measurements[0].attrs)
+}
+
+func TestWithAttributePreset(t *testing.T) {
+	withoutTopic := attribute.Filter(func(kv attribute.KeyValue) bool {
+		return kv.Key != semconv.MessagingDestinationNameKey
+	})
+	testCases := []struct {
+		name string
+		opts []Option
+		want attribute.Set
+	}{
+		{
+			name: "low cardinality",
+			opts: []Option{WithAttributePreset(LowCardinality)},
+			want: attribute.NewSet(
+				semconv.MessagingSystem("kafka"),
+				semconv.MessagingDestinationName(topic),
+			),
+		},
+		{
+			name: "low cardinality with topic attributes",
+			opts: []Option{WithAttributePreset(LowCardinality), WithTopicAttributeMapper(func(string) []attribute.KeyValue {
+				return []attribute.KeyValue{attribute.String("env", "prod")}
+			})},
+			want: attribute.NewSet(
+				semconv.MessagingSystem("kafka"),
+				semconv.MessagingDestinationName(topic),
+				attribute.String("env", "prod"),
+			),
+		},
+		{
+			name: "standard",
+			opts: []Option{WithAttributePreset(Standard)},
+			want: attribute.NewSet(
+				semconv.MessagingSystem("kafka"),
+				semconv.MessagingDestinationName(topic),
+				semconv.MessagingKafkaConsumerGroup("my-group"),
+			),
+		},
+		{
+			name: "detailed",
+			opts: []Option{WithAttributePreset(Detailed)},
+			want: attribute.NewSet(
+				semconv.MessagingSystem("kafka"),
+				semconv.MessagingDestinationName(topic),
+				semconv.MessagingKafkaSourcePartition(1),
+				semconv.MessagingKafkaConsumerGroup("my-group"),
+			),
+		},
+		{
+			name: "with filter",
+			opts: []Option{WithAttributePreset(Standard), WithMetricAttributeFilter(withoutTopic)},
+			want: attribute.NewSet(
+				semconv.MessagingSystem("kafka"),
+				semconv.MessagingKafkaConsumerGroup("my-group"),
+			),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := newMetricRecorder()
+			opts := append([]Option{WithMeterProvider(mr), WithConsumerGroupID("my-group")}, tc.opts...)
+			process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil }, opts...)
+			require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Partition: 1}))
+
+			measurements := mr.Measurements("messaging.process.duration")
+			require.Len(t, measurements, 1)
+			assert.Equal(t, tc.want, measurements[0].attrs)
+		})
+	}
+}
+
+func TestWithMetricAttributeExtractor(t *testing.T) {
+	mr := newMetricRecorder()
+	eventType := attribute.Key("app.event.type")
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil },
+		WithMeterProvider(mr),
+		WithMetricAttributeExtractor(func(msg *sarama.ConsumerMessage) []attribute.KeyValue {
+			return []attribute.KeyValue{eventType.String(string(msg.Key))}
+		}),
+	)
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Partition: 1, Key: []byte("created")}))
+
+	measurements := mr.Measurements("messaging.process.duration")
+	require.Len(t, measurements, 1)
+	value, ok := measurements[0].attrs.Value(eventType)
+	assert.True(t, ok)
+	assert.Equal(t, "created", value.AsString())
+}
+
+func TestMetricAttributeExtractorCardinalityCap(t *testing.T) {
+	cfg := newConfig(WithMetricAttributeExtractor(func(msg *sarama.ConsumerMessage) []attribute.KeyValue {
+		attrs := []attribute.KeyValue{attribute.Int64("offset", msg.Offset)}
+		for i := 0; i < maxExtractedAttributeKeys; i++ {
+			attrs = append(attrs, attribute.Int(strconv.Itoa(i), i))
+		}
+		return attrs
+	}))
+
+	for i := 0; i < maxExtractedAttributeValues; i++ {
+		attrs := cfg.MetricAttributeExtractor.extract(&sarama.ConsumerMessage{Offset: int64(i)})
+		require.Len(t, attrs, maxExtractedAttributeKeys)
+		assert.Equal(t, attribute.Int64("offset", int64(i)), attrs[0])
+	}
+	attrs := cfg.MetricAttributeExtractor.extract(&sarama.ConsumerMessage{Offset: maxExtractedAttributeValues})
+	assert.Equal(t, attribute.String("offset", extractedAttributeOverflow), attrs[0])
+	attrs = cfg.MetricAttributeExtractor.extract(&sarama.ConsumerMessage{Offset: 0})
+	assert.Equal(t, attribute.Int64("offset", 0), attrs[0])
+}
@@ -0,0 +1,103 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// leaderCacheTTL is the time leader addresses are used for before they are
+// looked up again.
+const leaderCacheTTL = 30 * time.Second
+
+// leaderCache caches the addresses of the leader brokers of partitions.
+// Looking up a leader may refresh the metadata of the client, so leaders are
+// looked up in the background and spans are recorded without the leader
+// until it is known.
+type leaderCache struct {
+	client sarama.Client
+	now    func() time.Time
+
+	mtx     sync.Mutex
+	leaders map[topicPartition]cachedLeader
+	// lookups are the partitions whose leader is being looked up.
+	lookups map[topicPartition]struct{}
+	// pending tracks running lookups.
+	pending sync.WaitGroup
+}
+
+// cachedLeader is the address of a leader, or empty if it is unknown, and
+// the time it expires at.
+type cachedLeader struct {
+	addr    string
+	expires time.Time
+}
+
+func newLeaderCache(client sarama.Client, now func() time.Time) *leaderCache {
+	return &leaderCache{
+		client:  unwrapClient(client),
+		now:     now,
+		leaders: make(map[topicPartition]cachedLeader),
+		lookups: make(map[topicPartition]struct{}),
+	}
+}
+
+// leader returns the cached address of the leader of partition of topic, or
+// an empty string if it is unknown. A lookup is started if no address is
+// cached or it expired.
+func (c *leaderCache) leader(topic string, partition int32) string {
+	tp := topicPartition{topic: topic, partition: partition}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	cached, ok := c.leaders[tp]
+	if _, running := c.lookups[tp]; (!ok || !c.now().Before(cached.expires)) && !running {
+		c.lookups[tp] = struct{}{}
+		c.pending.Add(1)
+		go c.lookup(tp)
+	}
+	return cached.addr
+}
+
+// invalidate expires the cached leader of partition of topic, e.g. after
+// producing to it failed because leadership moved.
+func (c *leaderCache) invalidate(topic string, partition int32) {
+	tp := topicPartition{topic: topic, partition: partition}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if cached, ok := c.leaders[tp]; ok {
+		cached.expires = time.Time{}
+		c.leaders[tp] = cached
+	}
+}
+
+func (c *leaderCache) lookup(tp topicPartition) {
+	defer c.pending.Done()
+	var addr string
+	if broker, err := c.client.Leader(tp.topic, tp.partition); err == nil && broker != nil {
+		addr = broker.Addr()
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.leaders[tp] = cachedLeader{addr: addr, expires: c.now().Add(leaderCacheTTL)}
+	delete(c.lookups, tp)
+}
+
+// wait waits for running lookups.
+func (c *leaderCache) wait() {
+	c.pending.Wait()
+}
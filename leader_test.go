@@ -0,0 +1,70 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingClient counts leader lookups.
+type countingClient struct {
+	fakeClient
+
+	lookups atomic.Int32
+}
+
+func (c *countingClient) Leader(topic string, partition int32) (*sarama.Broker, error) {
+	c.lookups.Add(1)
+	return c.fakeClient.Leader(topic, partition)
+}
+
+func TestLeaderCache(t *testing.T) {
+	client := &countingClient{fakeClient: fakeClient{leader: sarama.NewBroker("kafka-1:9092")}}
+	now := time.Unix(0, 0)
+	cache := newLeaderCache(client, func() time.Time { return now })
+
+	// Leaders are unknown until looked up in the background.
+	assert.Empty(t, cache.leader(topic, 0))
+	cache.wait()
+	assert.Equal(t, "kafka-1:9092", cache.leader(topic, 0))
+	assert.Equal(t, int32(1), client.lookups.Load())
+
+	// Cached leaders are used until they expire.
+	client.leader = sarama.NewBroker("kafka-2:9092")
+	now = now.Add(leaderCacheTTL - time.Second)
+	assert.Equal(t, "kafka-1:9092", cache.leader(topic, 0))
+	cache.wait()
+	assert.Equal(t, int32(1), client.lookups.Load())
+
+	// Expired leaders are used until they are looked up again.
+	now = now.Add(time.Second)
+	assert.Equal(t, "kafka-1:9092", cache.leader(topic, 0))
+	cache.wait()
+	assert.Equal(t, "kafka-2:9092", cache.leader(topic, 0))
+	assert.Equal(t, int32(2), client.lookups.Load())
+
+	// Invalidated leaders are looked up again.
+	client.leader = nil
+	cache.invalidate(topic, 0)
+	assert.Equal(t, "kafka-2:9092", cache.leader(topic, 0))
+	cache.wait()
+	assert.Empty(t, cache.leader(topic, 0))
+	assert.Equal(t, int32(3), client.lookups.Load())
+}
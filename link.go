@@ -0,0 +1,147 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// previousMessageLinks links the span of each message to the span of the
+// message previously consumed from the same partition. A nil
+// previousMessageLinks links nothing.
+type previousMessageLinks struct {
+	mtx   sync.Mutex
+	spans map[topicPartition]trace.SpanContext
+}
+
+// newPreviousMessageLinks returns the previousMessageLinks of cfg, which is
+// nil unless WithPreviousMessageLinks is set.
+func newPreviousMessageLinks(cfg config) *previousMessageLinks {
+	if !cfg.PreviousMessageLinks {
+		return nil
+	}
+	return &previousMessageLinks{spans: make(map[topicPartition]trace.SpanContext)}
+}
+
+// startOptions returns the options linking the span of msg to the span of
+// the previous message of its partition.
+func (l *previousMessageLinks) startOptions(msg *sarama.ConsumerMessage) []trace.SpanStartOption {
+	if l == nil {
+		return nil
+	}
+	l.mtx.Lock()
+	sc := l.spans[topicPartition{msg.Topic, msg.Partition}]
+	l.mtx.Unlock()
+	if !sc.IsValid() {
+		return nil
+	}
+	return []trace.SpanStartOption{trace.WithLinks(trace.Link{SpanContext: sc})}
+}
+
+// record records span as the span of the last message of the partition of
+// msg.
+func (l *previousMessageLinks) record(msg *sarama.ConsumerMessage, span trace.Span) {
+	if l == nil || !span.SpanContext().IsValid() {
+		return
+	}
+	l.mtx.Lock()
+	l.spans[topicPartition{msg.Topic, msg.Partition}] = span.SpanContext()
+	l.mtx.Unlock()
+}
+
+// receiveSpanContexts holds the span contexts of the receive spans of
+// messages handed over by dispatchers until they are processed, so their
+// process spans can link to them. It is keyed by the position of messages
+// rather than the messages themselves, so their keys, values and headers
+// are not retained.
+var receiveSpanContexts sync.Map // map[messagePosition]*trace.SpanContext
+
+// messagePosition identifies a consumed message by its topic, partition and
+// offset.
+type messagePosition struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+func positionOf(msg *sarama.ConsumerMessage) messagePosition {
+	return messagePosition{topic: msg.Topic, partition: msg.Partition, offset: msg.Offset}
+}
+
+// maxReceiveLinks is the maximum number of messages per dispatcher the
+// receive span contexts are held for, so messages never processed by an
+// instrumented handler are not held forever.
+const maxReceiveLinks = 256
+
+// receiveLink is a receive span context recorded in receiveSpanContexts. It
+// is held by pointer, which tells it apart from the span context of a
+// redelivery of the message.
+type receiveLink struct {
+	position messagePosition
+	sc       *trace.SpanContext
+}
+
+// receiveLinks records the receive spans of the messages handed over by a
+// dispatcher in receiveSpanContexts. It is not safe for concurrent use.
+type receiveLinks struct {
+	links []receiveLink
+	next  int
+}
+
+// record records span as the receive span of msg, evicting the oldest
+// message recorded if maxReceiveLinks are held.
+func (l *receiveLinks) record(msg *sarama.ConsumerMessage, span trace.Span) {
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	link := receiveLink{position: positionOf(msg), sc: &sc}
+	if len(l.links) < maxReceiveLinks {
+		l.links = append(l.links, link)
+	} else {
+		l.links[l.next].delete()
+		l.links[l.next] = link
+		l.next = (l.next + 1) % maxReceiveLinks
+	}
+	receiveSpanContexts.Store(link.position, link.sc)
+}
+
+// clear removes all messages recorded.
+func (l *receiveLinks) clear() {
+	for _, link := range l.links {
+		link.delete()
+	}
+	l.links, l.next = nil, 0
+}
+
+// delete removes the span context of l from receiveSpanContexts, unless it
+// was replaced by the receive span of a redelivery of the message.
+func (l receiveLink) delete() {
+	receiveSpanContexts.CompareAndDelete(l.position, l.sc)
+}
+
+// receiveSpanLinks returns the options linking the process span of msg to
+// its receive span, if msg was handed over by a dispatcher.
+func receiveSpanLinks(msg *sarama.ConsumerMessage) []trace.SpanStartOption {
+	sc, ok := receiveSpanContexts.LoadAndDelete(positionOf(msg))
+	if !ok {
+		return nil
+	}
+	return []trace.SpanStartOption{trace.WithLinks(trace.Link{SpanContext: *sc.(*trace.SpanContext)})}
+}
@@ -0,0 +1,118 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// saramaLogger emits the lines sarama logs as OpenTelemetry log records.
+type saramaLogger struct {
+	logger log.Logger
+}
+
+// NewOTelSaramaLogger returns a sarama.StdLogger that emits the lines it is
+// passed as OpenTelemetry log records of the package logger, so that it can
+// be set as sarama.Logger.
+//
+// sarama does not attach a level to its lines, the severity of a record is
+// therefore derived from its text: lines mentioning errors or failures are
+// reported as errors, lines mentioning retries or timeouts as warnings and
+// all other lines as info.
+func NewOTelSaramaLogger(opts ...Option) sarama.StdLogger {
+	cfg := newConfig(opts...)
+	provider := cfg.LoggerProvider
+	if provider == nil {
+		provider = global.GetLoggerProvider()
+	}
+	return &saramaLogger{
+		logger: provider.Logger(
+			cfg.scopeName(),
+			log.WithInstrumentationVersion(Version()),
+			log.WithSchemaURL(semconv.SchemaURL),
+		),
+	}
+}
+
+func (l *saramaLogger) Print(v ...interface{}) {
+	l.emit(fmt.Sprint(v...))
+}
+
+func (l *saramaLogger) Printf(format string, v ...interface{}) {
+	l.emit(fmt.Sprintf(format, v...))
+}
+
+func (l *saramaLogger) Println(v ...interface{}) {
+	l.emit(fmt.Sprintln(v...))
+}
+
+func (l *saramaLogger) emit(line string) {
+	severity, text := logSeverity(line)
+
+	var record log.Record
+	record.SetSeverity(severity)
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, record) {
+		return
+	}
+
+	now := time.Now()
+	record.SetTimestamp(now)
+	record.SetObservedTimestamp(now)
+	record.SetSeverityText(text)
+	record.SetBody(log.StringValue(strings.TrimRight(line, "\n")))
+	record.AddAttributes(log.String(string(semconv.MessagingSystemKey), "kafka"))
+	l.logger.Emit(ctx, record)
+}
+
+// logSeverity returns the severity and its text for a line logged by sarama.
+func logSeverity(line string) (log.Severity, string) {
+	line = strings.ToLower(line)
+	switch {
+	case strings.Contains(line, "error"), strings.Contains(line, "fail"), strings.Contains(line, "panic"):
+		return log.SeverityError, "ERROR"
+	case strings.Contains(line, "retry"), strings.Contains(line, "timeout"), strings.Contains(line, "timed out"),
+		strings.Contains(line, "warn"):
+		return log.SeverityWarn, "WARN"
+	default:
+		return log.SeverityInfo, "INFO"
+	}
+}
+
+// log logs msg to the logger configured with WithLogger, correlated with the
+// span in ctx.
+func (cfg config) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if cfg.Logger == nil || !cfg.Logger.Enabled(ctx, level) {
+		return
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		args = append(args,
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	cfg.Logger.Log(ctx, level, msg, args...)
+}
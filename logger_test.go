@@ -0,0 +1,80 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/logtest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestNewOTelSaramaLogger(t *testing.T) {
+	recorder := logtest.NewRecorder()
+	logger := NewOTelSaramaLogger(WithLoggerProvider(recorder))
+
+	logger.Println("Connected to broker at localhost:9092 (registered as #1)")
+	logger.Printf("Failed to connect to broker %s: %v\n", "localhost:9092", "connection refused")
+	logger.Print("client/metadata retrying after 250ms... (3 attempts remaining)")
+	NewOTelSaramaLogger(WithLoggerProvider(recorder), WithScopeName("my-scope")).Print("foo")
+
+	result := recorder.Result()
+	require.Len(t, result, 2)
+	assert.Equal(t, defaultTracerName, result[0].Name)
+	assert.Equal(t, Version(), result[0].Version)
+	assert.Equal(t, semconv.SchemaURL, result[0].SchemaURL)
+	assert.Equal(t, "my-scope", result[1].Name)
+	assert.Len(t, result[1].Records, 1)
+
+	expected := []struct {
+		body     string
+		severity log.Severity
+		text     string
+	}{
+		{"Connected to broker at localhost:9092 (registered as #1)", log.SeverityInfo, "INFO"},
+		{"Failed to connect to broker localhost:9092: connection refused", log.SeverityError, "ERROR"},
+		{"client/metadata retrying after 250ms... (3 attempts remaining)", log.SeverityWarn, "WARN"},
+	}
+	records := result[0].Records
+	require.Len(t, records, len(expected))
+	for i, e := range expected {
+		assert.Equal(t, e.body, records[i].Body().AsString())
+		assert.Equal(t, e.severity, records[i].Severity())
+		assert.Equal(t, e.text, records[i].SeverityText())
+		assert.False(t, records[i].Timestamp().IsZero())
+
+		var system string
+		records[i].WalkAttributes(func(kv log.KeyValue) bool {
+			if kv.Key == "messaging.system" {
+				system = kv.Value.AsString()
+			}
+			return true
+		})
+		assert.Equal(t, "kafka", system)
+	}
+}
+
+func TestNewOTelSaramaLoggerSemConvOptIn(t *testing.T) {
+	recorder := logtest.NewRecorder()
+	NewOTelSaramaLogger(WithLoggerProvider(recorder), WithSemConvOptIn()).Print("foo")
+
+	result := recorder.Result()
+	require.Len(t, result, 1)
+	assert.Equal(t, semconv.SchemaURL, result[0].SchemaURL)
+}
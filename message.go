@@ -15,28 +15,147 @@
 package otelsarama
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/IBM/sarama"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
+// messageValueKey is the attribute key for the captured value of a message.
+const messageValueKey = attribute.Key("messaging.kafka.message.value")
+
+// maxHeaderAttributeLength is the maximum length in bytes of header values
+// recorded as attributes.
+const maxHeaderAttributeLength = 256
+
 var _ propagation.TextMapCarrier = (*ProducerMessageCarrier)(nil)
 var _ propagation.TextMapCarrier = (*ConsumerMessageCarrier)(nil)
 
+// CarrierOption applies an option to a ProducerMessageCarrier or a
+// ConsumerMessageCarrier.
+type CarrierOption interface {
+	apply(*headerKeys)
+}
+
+type carrierOptionFunc func(*headerKeys)
+
+func (fn carrierOptionFunc) apply(k *headerKeys) {
+	fn(k)
+}
+
+// WithHeaderPrefix prefixes the header keys propagation fields are injected
+// into and extracted from, e.g. "myco-" to propagate the traceparent field in
+// the myco-traceparent header. Fields renamed by WithHeaderRenames are not
+// prefixed.
+func WithHeaderPrefix(prefix string) CarrierOption {
+	return carrierOptionFunc(func(k *headerKeys) {
+		k.prefix = prefix
+	})
+}
+
+// WithHeaderRenames maps propagation fields to the header keys they are
+// injected into and extracted from.
+func WithHeaderRenames(renames map[string]string) CarrierOption {
+	return carrierOptionFunc(func(k *headerKeys) {
+		if k.renames == nil {
+			k.renames = make(map[string]string, len(renames))
+		}
+		for field, header := range renames {
+			k.renames[field] = header
+		}
+	})
+}
+
+// headerKeys maps propagation fields to header keys.
+type headerKeys struct {
+	prefix  string
+	renames map[string]string
+}
+
+func newHeaderKeys(opts []CarrierOption) *headerKeys {
+	if len(opts) == 0 {
+		return nil
+	}
+	k := &headerKeys{}
+	for _, opt := range opts {
+		opt.apply(k)
+	}
+	return k
+}
+
+// header returns the header key of a propagation field.
+func (k *headerKeys) header(field string) string {
+	if k == nil {
+		return field
+	}
+	if header, ok := k.renames[field]; ok {
+		return header
+	}
+	return k.prefix + field
+}
+
+// field returns the propagation field of a header key and false if the
+// header does not carry a propagation field.
+func (k *headerKeys) field(header string) (string, bool) {
+	if k == nil {
+		return header, true
+	}
+	for field, h := range k.renames {
+		if strings.EqualFold(h, header) {
+			return field, true
+		}
+	}
+	if len(header) < len(k.prefix) || !strings.EqualFold(header[:len(k.prefix)], k.prefix) {
+		return "", false
+	}
+	return header[len(k.prefix):], true
+}
+
+// equalHeaderKey reports whether the header key b equals key. Header keys
+// are matched case-insensitively, as is conventional for Kafka headers.
+func equalHeaderKey(b []byte, key string) bool {
+	if len(b) != len(key) {
+		return false
+	}
+	for i := 0; i < len(b); i++ {
+		if lowerASCII(b[i]) != lowerASCII(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
 // ProducerMessageCarrier injects and extracts traces from a sarama.ProducerMessage.
 type ProducerMessageCarrier struct {
-	msg *sarama.ProducerMessage
+	msg  *sarama.ProducerMessage
+	keys *headerKeys
 }
 
 // NewProducerMessageCarrier creates a new ProducerMessageCarrier.
-func NewProducerMessageCarrier(msg *sarama.ProducerMessage) ProducerMessageCarrier {
-	return ProducerMessageCarrier{msg: msg}
+func NewProducerMessageCarrier(msg *sarama.ProducerMessage, opts ...CarrierOption) ProducerMessageCarrier {
+	return ProducerMessageCarrier{msg: msg, keys: newHeaderKeys(opts)}
 }
 
-// Get retrieves a single value for a given key.
+// Get retrieves a single value for a given key. Header keys are matched
+// case-insensitively.
 func (c ProducerMessageCarrier) Get(key string) string {
+	key = c.keys.header(key)
 	for _, h := range c.msg.Headers {
-		if string(h.Key) == key {
+		if equalHeaderKey(h.Key, key) {
 			return string(h.Value)
 		}
 	}
@@ -45,9 +164,10 @@ func (c ProducerMessageCarrier) Get(key string) string {
 
 // Set sets a header.
 func (c ProducerMessageCarrier) Set(key, val string) {
+	key = c.keys.header(key)
 	// Ensure uniqueness of keys
 	for i := 0; i < len(c.msg.Headers); i++ {
-		if string(c.msg.Headers[i].Key) == key {
+		if equalHeaderKey(c.msg.Headers[i].Key, key) {
 			c.msg.Headers = append(c.msg.Headers[:i], c.msg.Headers[i+1:]...)
 			i--
 		}
@@ -60,27 +180,32 @@ func (c ProducerMessageCarrier) Set(key, val string) {
 
 // Keys returns a slice of all key identifiers in the carrier.
 func (c ProducerMessageCarrier) Keys() []string {
-	out := make([]string, len(c.msg.Headers))
-	for i, h := range c.msg.Headers {
-		out[i] = string(h.Key)
+	out := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		if key, ok := c.keys.field(string(h.Key)); ok {
+			out = append(out, key)
+		}
 	}
 	return out
 }
 
 // ConsumerMessageCarrier injects and extracts traces from a sarama.ConsumerMessage.
 type ConsumerMessageCarrier struct {
-	msg *sarama.ConsumerMessage
+	msg  *sarama.ConsumerMessage
+	keys *headerKeys
 }
 
 // NewConsumerMessageCarrier creates a new ConsumerMessageCarrier.
-func NewConsumerMessageCarrier(msg *sarama.ConsumerMessage) ConsumerMessageCarrier {
-	return ConsumerMessageCarrier{msg: msg}
+func NewConsumerMessageCarrier(msg *sarama.ConsumerMessage, opts ...CarrierOption) ConsumerMessageCarrier {
+	return ConsumerMessageCarrier{msg: msg, keys: newHeaderKeys(opts)}
 }
 
-// Get retrieves a single value for a given key.
+// Get retrieves a single value for a given key. Header keys are matched
+// case-insensitively.
 func (c ConsumerMessageCarrier) Get(key string) string {
+	key = c.keys.header(key)
 	for _, h := range c.msg.Headers {
-		if h != nil && string(h.Key) == key {
+		if h != nil && equalHeaderKey(h.Key, key) {
 			return string(h.Value)
 		}
 	}
@@ -89,9 +214,10 @@ func (c ConsumerMessageCarrier) Get(key string) string {
 
 // Set sets a header.
 func (c ConsumerMessageCarrier) Set(key, val string) {
+	key = c.keys.header(key)
 	// Ensure uniqueness of keys
 	for i := 0; i < len(c.msg.Headers); i++ {
-		if c.msg.Headers[i] != nil && string(c.msg.Headers[i].Key) == key {
+		if c.msg.Headers[i] != nil && equalHeaderKey(c.msg.Headers[i].Key, key) {
 			c.msg.Headers = append(c.msg.Headers[:i], c.msg.Headers[i+1:]...)
 			i--
 		}
@@ -104,9 +230,168 @@ func (c ConsumerMessageCarrier) Set(key, val string) {
 
 // Keys returns a slice of all key identifiers in the carrier.
 func (c ConsumerMessageCarrier) Keys() []string {
-	out := make([]string, len(c.msg.Headers))
-	for i, h := range c.msg.Headers {
-		out[i] = string(h.Key)
+	out := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		if h == nil {
+			continue
+		}
+		if key, ok := c.keys.field(string(h.Key)); ok {
+			out = append(out, key)
+		}
 	}
 	return out
 }
+
+// headerAttributes returns attributes for the headers selected by keys.
+// Header names are matched case-insensitively.
+func headerAttributes(headers []*sarama.RecordHeader, keys map[string]attribute.Key) []attribute.KeyValue {
+	if len(keys) == 0 {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		for name, key := range keys {
+			if equalHeaderKey(h.Key, name) {
+				value := truncateUTF8(h.Value, maxHeaderAttributeLength)
+				attrs = append(attrs, key.String(string(value)))
+				break
+			}
+		}
+	}
+	return attrs
+}
+
+// truncateUTF8 returns the first n bytes of b. A UTF-8 encoded rune cut at n
+// is dropped entirely, so valid UTF-8 stays valid.
+func truncateUTF8(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	b = b[:n]
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				b = b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// conversationIDAttributes returns the messaging.message.conversation_id
+// attribute read from the header specified by WithConversationIDHeader.
+func (cfg config) conversationIDAttributes(headers []*sarama.RecordHeader) []attribute.KeyValue {
+	if cfg.ConversationIDHeader == "" {
+		return nil
+	}
+	for _, h := range headers {
+		if h != nil && equalHeaderKey(h.Key, cfg.ConversationIDHeader) {
+			return conversationIDAttribute(h.Value)
+		}
+	}
+	return nil
+}
+
+// producerConversationIDAttributes is conversationIDAttributes for the
+// headers of produced messages.
+func (cfg config) producerConversationIDAttributes(headers []sarama.RecordHeader) []attribute.KeyValue {
+	if cfg.ConversationIDHeader == "" {
+		return nil
+	}
+	for _, h := range headers {
+		if equalHeaderKey(h.Key, cfg.ConversationIDHeader) {
+			return conversationIDAttribute(h.Value)
+		}
+	}
+	return nil
+}
+
+func conversationIDAttribute(value []byte) []attribute.KeyValue {
+	if len(value) == 0 {
+		return nil
+	}
+	value = truncateUTF8(value, maxHeaderAttributeLength)
+	return []attribute.KeyValue{semconv.MessagingMessageConversationID(string(value))}
+}
+
+// KeyRedaction transforms the key of a message before it is recorded as
+// attribute. It returns false if the key must not be recorded at all.
+type KeyRedaction func(key []byte) (string, bool)
+
+// HashSHA256 is a KeyRedaction recording the hex encoded SHA-256 hash of
+// message keys.
+func HashSHA256(key []byte) (string, bool) {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// Drop is a KeyRedaction not recording message keys.
+func Drop([]byte) (string, bool) {
+	return "", false
+}
+
+// Truncate returns a KeyRedaction recording the first n bytes of message
+// keys. A UTF-8 encoded rune cut at n is dropped entirely.
+func Truncate(n int) KeyRedaction {
+	return func(key []byte) (string, bool) {
+		return string(truncateUTF8(key, n)), true
+	}
+}
+
+// keyAttributes returns the attribute for the key of a message, redacted
+// as configured.
+func (cfg config) keyAttributes(key []byte) []attribute.KeyValue {
+	if key == nil {
+		return nil
+	}
+	k := string(key)
+	if cfg.KeyRedaction != nil {
+		var ok bool
+		if k, ok = cfg.KeyRedaction(key); !ok {
+			return nil
+		}
+	}
+	return []attribute.KeyValue{semconv.MessagingKafkaMessageKey(k)}
+}
+
+// payloadAttributes returns attributes for the value of a message truncated
+// to maxBytes.
+func payloadAttributes(value []byte, maxBytes int) []attribute.KeyValue {
+	if maxBytes <= 0 || value == nil {
+		return nil
+	}
+	value = truncateUTF8(value, maxBytes)
+	return []attribute.KeyValue{messageValueKey.String(string(value))}
+}
+
+// PartitionOffsetMessageID formats the ID of msg as "<partition>:<offset>",
+// which is unique within a topic. It can be used with
+// WithMessageIDFormatter.
+func PartitionOffsetMessageID(msg *sarama.ConsumerMessage) string {
+	return strconv.FormatInt(int64(msg.Partition), 10) + ":" + strconv.FormatInt(msg.Offset, 10)
+}
+
+// messageID returns the messaging.message.id of msg, formatted by the
+// configured MessageIDFormatter. It defaults to the offset of msg.
+func (cfg config) messageID(msg *sarama.ConsumerMessage) string {
+	if cfg.MessageIDFormatter != nil {
+		return cfg.MessageIDFormatter(msg)
+	}
+	return strconv.FormatInt(msg.Offset, 10)
+}
+
+// encode returns the encoded form of e, or nil if it cannot be encoded.
+func encode(e sarama.Encoder) []byte {
+	if e == nil {
+		return nil
+	}
+	b, err := e.Encode()
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -15,10 +15,14 @@
 package otelsarama
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/IBM/sarama"
 	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
 func TestProducerMessageCarrierGet(t *testing.T) {
@@ -36,6 +40,14 @@ func TestProducerMessageCarrierGet(t *testing.T) {
 			key:      "foo",
 			expected: "bar",
 		},
+		{
+			name: "case-insensitive",
+			carrier: ProducerMessageCarrier{msg: &sarama.ProducerMessage{Headers: []sarama.RecordHeader{
+				{Key: []byte("TraceParent"), Value: []byte("bar")},
+			}}},
+			key:      "traceparent",
+			expected: "bar",
+		},
 		{
 			name:     "not exists",
 			carrier:  ProducerMessageCarrier{msg: &sarama.ProducerMessage{Headers: []sarama.RecordHeader{}}},
@@ -121,6 +133,15 @@ func TestConsumerMessageCarrierGet(t *testing.T) {
 			key:      "foo",
 			expected: "bar",
 		},
+		{
+			name: "case-insensitive",
+			carrier: ConsumerMessageCarrier{msg: &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+				nil,
+				{Key: []byte("TraceParent"), Value: []byte("bar")},
+			}}},
+			key:      "traceparent",
+			expected: "bar",
+		},
 		{
 			name:     "not exists",
 			carrier:  ConsumerMessageCarrier{msg: &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{}}},
@@ -181,6 +202,22 @@ func TestConsumerMessageCarrierKeys(t *testing.T) {
 			}}},
 			expected: []string{"foo", "baz"},
 		},
+		{
+			name: "nil header",
+			carrier: ConsumerMessageCarrier{msg: &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+				nil,
+				{Key: []byte("foo"), Value: []byte("bar")},
+			}}},
+			expected: []string{"foo"},
+		},
+		{
+			name: "case-insensitive prefix",
+			carrier: NewConsumerMessageCarrier(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+				{Key: []byte("MyCo-traceparent"), Value: []byte("bar")},
+				{Key: []byte("other"), Value: []byte("quux")},
+			}}, WithHeaderPrefix("myco-")),
+			expected: []string{"traceparent"},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -190,3 +227,218 @@ func TestConsumerMessageCarrierKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestMessageCarrierGetAllocs(t *testing.T) {
+	consumerCarrier := NewConsumerMessageCarrier(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+	}})
+	producerCarrier := NewProducerMessageCarrier(&sarama.ProducerMessage{Headers: []sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+	}}, WithHeaderPrefix("myco-"))
+
+	// Matching header keys does not allocate, only copying the value does.
+	assert.Equal(t, float64(1), testing.AllocsPerRun(100, func() { consumerCarrier.Get("traceparent") }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { consumerCarrier.Get("tracestate") }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { producerCarrier.Get("traceparent") }))
+}
+
+func TestMessageCarrierGetCopiesValue(t *testing.T) {
+	value := []byte(traceparent)
+	carrier := NewConsumerMessageCarrier(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: value},
+	}})
+
+	got := carrier.Get("traceparent")
+	value[0] = 'x'
+	assert.Equal(t, traceparent, got)
+}
+
+func BenchmarkConsumerMessageCarrierGet(b *testing.B) {
+	carrier := NewConsumerMessageCarrier(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("x-tenant-id"), Value: []byte("tenant-a")},
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+	}})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		carrier.Get("traceparent")
+	}
+}
+
+func BenchmarkProducerMessageCarrierGet(b *testing.B) {
+	carrier := NewProducerMessageCarrier(&sarama.ProducerMessage{Headers: []sarama.RecordHeader{
+		{Key: []byte("x-tenant-id"), Value: []byte("tenant-a")},
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+	}})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		carrier.Get("traceparent")
+	}
+}
+
+func BenchmarkConsumerMessageCarrierKeys(b *testing.B) {
+	carrier := NewConsumerMessageCarrier(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("x-tenant-id"), Value: []byte("tenant-a")},
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+	}})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		carrier.Keys()
+	}
+}
+
+func TestHeaderAttributes(t *testing.T) {
+	long := strings.Repeat("x", maxHeaderAttributeLength+1)
+	// The last rune starts at maxHeaderAttributeLength-1 and is cut.
+	multiByte := strings.Repeat("x", maxHeaderAttributeLength-1) + "é"
+	headers := []*sarama.RecordHeader{
+		{Key: []byte("X-Tenant-ID"), Value: []byte("tenant-a")},
+		{Key: []byte("x-schema-id"), Value: []byte(long)},
+		{Key: []byte("x-name"), Value: []byte(multiByte)},
+		{Key: []byte("x-other"), Value: []byte("ignored")},
+		nil,
+	}
+
+	attrs := headerAttributes(headers, map[string]attribute.Key{
+		"x-tenant-id": "tenant.id",
+		"x-schema-id": "schema.id",
+		"x-name":      "name",
+	})
+
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("tenant.id", "tenant-a"),
+		attribute.String("schema.id", long[:maxHeaderAttributeLength]),
+		attribute.String("name", multiByte[:maxHeaderAttributeLength-1]),
+	}, attrs)
+	assert.Nil(t, headerAttributes(headers, nil))
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	testCases := []struct {
+		value string
+		n     int
+		want  string
+	}{
+		{value: "abc", n: 4, want: "abc"},
+		{value: "abc", n: 2, want: "ab"},
+		{value: "aé", n: 2, want: "a"},
+		{value: "aé", n: 3, want: "aé"},
+		{value: "a€b", n: 3, want: "a"},
+		{value: "a€b", n: 4, want: "a€"},
+		{value: "\xff\xfe\xfd", n: 2, want: "\xff\xfe"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, string(truncateUTF8([]byte(tc.value), tc.n)), "%q", tc.value)
+	}
+}
+
+func TestConversationIDAttributes(t *testing.T) {
+	headers := []*sarama.RecordHeader{
+		nil,
+		{Key: []byte("Correlation-ID"), Value: []byte("42")},
+	}
+
+	cfg := newConfig(WithConversationIDHeader("correlation-id"))
+	assert.Equal(t, []attribute.KeyValue{semconv.MessagingMessageConversationID("42")}, cfg.conversationIDAttributes(headers))
+	assert.Equal(t, []attribute.KeyValue{semconv.MessagingMessageConversationID("42")}, cfg.producerConversationIDAttributes([]sarama.RecordHeader{*headers[1]}))
+	assert.Nil(t, cfg.conversationIDAttributes(nil))
+	assert.Nil(t, newConfig().conversationIDAttributes(headers))
+}
+
+func TestPayloadAttributes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    []byte
+		maxBytes int
+		expected []attribute.KeyValue
+	}{
+		{
+			name:     "disabled",
+			value:    []byte("bar"),
+			expected: nil,
+		},
+		{
+			name:     "value",
+			value:    []byte("bar"),
+			maxBytes: 3,
+			expected: []attribute.KeyValue{messageValueKey.String("bar")},
+		},
+		{
+			name:     "truncated value",
+			value:    []byte("barbaz"),
+			maxBytes: 3,
+			expected: []attribute.KeyValue{messageValueKey.String("bar")},
+		},
+		{
+			name:     "tombstone",
+			maxBytes: 3,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, payloadAttributes(tc.value, tc.maxBytes))
+		})
+	}
+}
+
+func TestKeyAttributes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []Option
+		key      []byte
+		expected []attribute.KeyValue
+	}{
+		{
+			name:     "verbatim",
+			key:      []byte("user-42"),
+			expected: []attribute.KeyValue{semconv.MessagingKafkaMessageKey("user-42")},
+		},
+		{
+			name:     "no key",
+			expected: nil,
+		},
+		{
+			name:     "hash",
+			opts:     []Option{WithKeyRedaction(HashSHA256)},
+			key:      []byte("user-42"),
+			expected: []attribute.KeyValue{semconv.MessagingKafkaMessageKey("6d894aa3ee802549d7f340e7c1cf0d1c1cb14cd84f768d92ffaa6785337c4997")},
+		},
+		{
+			name:     "truncate",
+			opts:     []Option{WithKeyRedaction(Truncate(4))},
+			key:      []byte("user-42"),
+			expected: []attribute.KeyValue{semconv.MessagingKafkaMessageKey("user")},
+		},
+		{
+			name:     "truncate at rune boundary",
+			opts:     []Option{WithKeyRedaction(Truncate(4))},
+			key:      []byte("usé-42"),
+			expected: []attribute.KeyValue{semconv.MessagingKafkaMessageKey("usé")},
+		},
+		{
+			name:     "truncate within rune",
+			opts:     []Option{WithKeyRedaction(Truncate(3))},
+			key:      []byte("usé-42"),
+			expected: []attribute.KeyValue{semconv.MessagingKafkaMessageKey("us")},
+		},
+		{
+			name:     "drop",
+			opts:     []Option{WithKeyRedaction(Drop)},
+			key:      []byte("user-42"),
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, newConfig(tc.opts...).keyAttributes(tc.key))
+		})
+	}
+}
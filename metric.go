@@ -0,0 +1,136 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// defaultDurationHistogramBoundaries are the default bucket boundaries of
+// duration histograms in seconds.
+var defaultDurationHistogramBoundaries = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10,
+}
+
+// handleError reports err to the configured error handler, or to the global
+// OpenTelemetry error handler if none is configured.
+func (cfg config) handleError(err error) {
+	if cfg.ErrorHandler != nil {
+		cfg.ErrorHandler(err)
+		return
+	}
+	otel.Handle(err)
+}
+
+// newInstrument creates an instrument with create. Creation errors are
+// reported to the error handler and fallback is returned instead of a nil
+// instrument. Identical instruments created by several wrappers sharing a
+// meter are de-duplicated by the SDK.
+func newInstrument[T any](cfg config, create func() (T, error), fallback T) T {
+	instrument, err := create()
+	if err != nil {
+		cfg.handleError(err)
+	}
+	if any(instrument) == nil {
+		return fallback
+	}
+	return instrument
+}
+
+// int64Counter creates the counter with name with the meter of cfg.
+func (cfg config) int64Counter(name string, opts ...metric.Int64CounterOption) metric.Int64Counter {
+	return newInstrument[metric.Int64Counter](cfg, func() (metric.Int64Counter, error) {
+		return cfg.Meter.Int64Counter(name, opts...)
+	}, noop.Int64Counter{})
+}
+
+// int64UpDownCounter creates the up-down counter with name with the meter
+// of cfg.
+func (cfg config) int64UpDownCounter(name string, opts ...metric.Int64UpDownCounterOption) metric.Int64UpDownCounter {
+	return newInstrument[metric.Int64UpDownCounter](cfg, func() (metric.Int64UpDownCounter, error) {
+		return cfg.Meter.Int64UpDownCounter(name, opts...)
+	}, noop.Int64UpDownCounter{})
+}
+
+// int64Histogram creates the histogram with name with the meter of cfg.
+func (cfg config) int64Histogram(name string, opts ...metric.Int64HistogramOption) metric.Int64Histogram {
+	return newInstrument[metric.Int64Histogram](cfg, func() (metric.Int64Histogram, error) {
+		return cfg.Meter.Int64Histogram(name, opts...)
+	}, noop.Int64Histogram{})
+}
+
+// durationHistogram creates the histogram with name with the meter of cfg
+// recording durations in seconds.
+func (cfg config) durationHistogram(name, desc string) metric.Float64Histogram {
+	boundaries := cfg.DurationHistogramBoundaries
+	if boundaries == nil {
+		boundaries = defaultDurationHistogramBoundaries
+	}
+	return newInstrument[metric.Float64Histogram](cfg, func() (metric.Float64Histogram, error) {
+		return cfg.Meter.Float64Histogram(
+			name,
+			metric.WithUnit("s"),
+			metric.WithDescription(desc),
+			metric.WithExplicitBucketBoundaries(boundaries...),
+		)
+	}, noop.Float64Histogram{})
+}
+
+// int64ObservableGauge creates an observable gauge with the meter of cfg and
+// registers callback to observe it until the returned registration is
+// unregistered. Creation and registration errors are reported to the error
+// handler.
+func (cfg config) int64ObservableGauge(name string, callback metric.Int64Callback, opts ...metric.Int64ObservableGaugeOption) metric.Registration {
+	gauge, err := cfg.Meter.Int64ObservableGauge(name, opts...)
+	if err != nil {
+		cfg.handleError(err)
+		return noop.Registration{}
+	}
+	registration, err := cfg.Meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		return callback(ctx, gaugeObserver{observer: o, gauge: gauge})
+	}, gauge)
+	if err != nil {
+		cfg.handleError(err)
+		return noop.Registration{}
+	}
+	return registration
+}
+
+// unregister unregisters registrations. Errors are reported to the error
+// handler.
+func (cfg config) unregister(registrations []metric.Registration) {
+	for _, registration := range registrations {
+		if err := registration.Unregister(); err != nil {
+			cfg.handleError(err)
+		}
+	}
+}
+
+// gaugeObserver observes values of gauge with observer.
+type gaugeObserver struct {
+	embedded.Int64Observer
+
+	observer metric.Observer
+	gauge    metric.Int64ObservableGauge
+}
+
+func (o gaugeObserver) Observe(value int64, opts ...metric.ObserveOption) {
+	o.observer.ObserveInt64(o.gauge, value, opts...)
+}
@@ -0,0 +1,171 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+	gometrics "github.com/rcrowley/go-metrics"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	// brokerIDKey is the attribute key for the ID of a Kafka broker.
+	brokerIDKey = attribute.Key("messaging.kafka.broker.id")
+	// quantileKey is the attribute key for the quantile of a sarama
+	// histogram.
+	quantileKey = attribute.Key("quantile")
+
+	brokerMetricSuffix = "-for-broker-"
+	topicMetricSuffix  = "-for-topic-"
+)
+
+// saramaHistogramQuantiles are the quantiles reported for sarama histograms.
+var saramaHistogramQuantiles = []float64{0.5, 0.95, 0.99}
+
+type saramaMetricKind int
+
+const (
+	saramaMeter saramaMetricKind = iota
+	saramaCounter
+	saramaHistogram
+)
+
+// saramaMetric describes how a metric of the sarama registry is exported.
+type saramaMetric struct {
+	kind saramaMetricKind
+	name string
+	unit string
+	desc string
+}
+
+// saramaMetrics maps the base name of sarama's metrics to the OpenTelemetry
+// instrument they are exported as. Meters are exported as observable
+// counters of their total count, counters as observable up-down counters and
+// histograms as observable gauges of their quantiles.
+var saramaMetrics = map[string]saramaMetric{
+	"incoming-byte-rate":           {saramaMeter, "messaging.kafka.broker.incoming_bytes", "By", "Bytes read off brokers."},
+	"outgoing-byte-rate":           {saramaMeter, "messaging.kafka.broker.outgoing_bytes", "By", "Bytes written to brokers."},
+	"request-rate":                 {saramaMeter, "messaging.kafka.broker.requests", "{request}", "Requests sent to brokers."},
+	"response-rate":                {saramaMeter, "messaging.kafka.broker.responses", "{response}", "Responses received from brokers."},
+	"requests-in-flight":           {saramaCounter, "messaging.kafka.broker.requests_in_flight", "{request}", "In-flight requests awaiting a response."},
+	"request-size":                 {saramaHistogram, "messaging.kafka.broker.request_size", "By", "Size of requests sent to brokers."},
+	"response-size":                {saramaHistogram, "messaging.kafka.broker.response_size", "By", "Size of responses received from brokers."},
+	"request-latency-in-ms":        {saramaHistogram, "messaging.kafka.broker.request_latency", "ms", "Latency of requests sent to brokers."},
+	"batch-size":                   {saramaHistogram, "messaging.kafka.producer.batch_size", "By", "Bytes sent per partition per request."},
+	"record-send-rate":             {saramaMeter, "messaging.kafka.producer.records_sent", "{record}", "Records sent to topics."},
+	"records-per-request":          {saramaHistogram, "messaging.kafka.producer.records_per_request", "{record}", "Records sent per request."},
+	"compression-ratio":            {saramaHistogram, "messaging.kafka.producer.compression_ratio", "%", "Compression ratio of record batches times 100."},
+	"consumer-batch-size":          {saramaHistogram, "messaging.kafka.consumer.batch_size", "{message}", "Messages per fetched batch."},
+	"consumer-fetch-rate":          {saramaMeter, "messaging.kafka.consumer.fetches", "{request}", "Fetch requests sent to brokers."},
+	"consumer-fetch-response-size": {saramaHistogram, "messaging.kafka.consumer.fetch_response_size", "By", "Size of fetch responses."},
+}
+
+// RegisterSaramaMetrics exports the metrics sarama records in the
+// MetricRegistry of saramaConfig as OpenTelemetry instruments of the package
+// meter.
+//
+// Metrics sarama records per broker or per topic carry the broker ID or the
+// topic as attribute, totals are reported without these attributes. Note
+// that sarama replaces dots in topic names with underscores.
+//
+// The returned registration stops the export once unregistered.
+func RegisterSaramaMetrics(saramaConfig *sarama.Config, opts ...Option) (metric.Registration, error) {
+	if saramaConfig == nil || saramaConfig.MetricRegistry == nil {
+		return nil, errors.New("otelsarama: sarama config has no metric registry")
+	}
+	cfg := newConfig(opts...)
+	registry := saramaConfig.MetricRegistry
+
+	var (
+		observables []metric.Observable
+		counters    = make(map[string]metric.Int64ObservableCounter)
+		upDowns     = make(map[string]metric.Int64ObservableUpDownCounter)
+		gauges      = make(map[string]metric.Float64ObservableGauge)
+	)
+	for base, m := range saramaMetrics {
+		var err error
+		switch m.kind {
+		case saramaMeter:
+			counters[base], err = cfg.Meter.Int64ObservableCounter(m.name, metric.WithUnit(m.unit), metric.WithDescription(m.desc))
+			observables = append(observables, counters[base])
+		case saramaCounter:
+			upDowns[base], err = cfg.Meter.Int64ObservableUpDownCounter(m.name, metric.WithUnit(m.unit), metric.WithDescription(m.desc))
+			observables = append(observables, upDowns[base])
+		case saramaHistogram:
+			gauges[base], err = cfg.Meter.Float64ObservableGauge(m.name, metric.WithUnit(m.unit), metric.WithDescription(m.desc))
+			observables = append(observables, gauges[base])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg.Meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		registry.Each(func(name string, i interface{}) {
+			base, attrs, ok := parseSaramaMetricName(name)
+			if !ok {
+				return
+			}
+			attrs = append(attrs, cfg.Attributes...)
+			switch v := i.(type) {
+			case gometrics.Meter:
+				if c, ok := counters[base]; ok {
+					o.ObserveInt64(c, v.Count(), cfg.withMetricAttributes(attrs...))
+				}
+			case gometrics.Counter:
+				if c, ok := upDowns[base]; ok {
+					o.ObserveInt64(c, v.Count(), cfg.withMetricAttributes(attrs...))
+				}
+			case gometrics.Histogram:
+				g, ok := gauges[base]
+				if !ok {
+					return
+				}
+				snapshot := v.Snapshot()
+				values := snapshot.Percentiles(saramaHistogramQuantiles)
+				for i, q := range saramaHistogramQuantiles {
+					o.ObserveFloat64(g, values[i], cfg.withMetricAttributes(append(attrs, quantileKey.Float64(q))...))
+				}
+			}
+		})
+		return nil
+	}, observables...)
+}
+
+// parseSaramaMetricName splits the name of a sarama metric into its base
+// name and the attributes encoded in its suffix. It returns false for
+// metrics that are not exported.
+func parseSaramaMetricName(name string) (string, []attribute.KeyValue, bool) {
+	base, attrs := name, []attribute.KeyValue{semconv.MessagingSystem("kafka")}
+	if i := strings.LastIndex(name, brokerMetricSuffix); i >= 0 {
+		id, err := strconv.Atoi(name[i+len(brokerMetricSuffix):])
+		if err != nil {
+			return "", nil, false
+		}
+		base, attrs = name[:i], append(attrs, brokerIDKey.Int(id))
+	} else if i := strings.Index(name, topicMetricSuffix); i >= 0 {
+		base, attrs = name[:i], append(attrs, semconv.MessagingDestinationName(name[i+len(topicMetricSuffix):]))
+	}
+	_, ok := saramaMetrics[base]
+	return base, attrs, ok
+}
@@ -0,0 +1,66 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestRegisterSaramaMetrics(t *testing.T) {
+	saramaConfig := sarama.NewConfig()
+	registry := saramaConfig.MetricRegistry
+	gometrics.GetOrRegisterMeter("incoming-byte-rate", registry).Mark(30)
+	gometrics.GetOrRegisterMeter("incoming-byte-rate-for-broker-2", registry).Mark(10)
+	gometrics.GetOrRegisterCounter("requests-in-flight", registry).Inc(3)
+	gometrics.GetOrRegisterMeter("record-send-rate-for-topic-my_topic", registry).Mark(5)
+	gometrics.GetOrRegisterMeter("protocol-requests-rate-3", registry).Mark(1)
+	histogram := gometrics.GetOrRegisterHistogram("request-latency-in-ms", registry, gometrics.NewUniformSample(10))
+	histogram.Update(4)
+
+	mr := newMetricRecorder()
+	reg, err := RegisterSaramaMetrics(saramaConfig, WithMeterProvider(mr))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []measurement{
+		{value: 30, attrs: attribute.NewSet(semconv.MessagingSystem("kafka"))},
+		{value: 10, attrs: attribute.NewSet(semconv.MessagingSystem("kafka"), brokerIDKey.Int(2))},
+	}, mr.Collect("messaging.kafka.broker.incoming_bytes"))
+	assert.Equal(t, []measurement{
+		{value: 3, attrs: attribute.NewSet(semconv.MessagingSystem("kafka"))},
+	}, mr.Collect("messaging.kafka.broker.requests_in_flight"))
+	assert.Equal(t, []measurement{
+		{value: 5, attrs: attribute.NewSet(semconv.MessagingSystem("kafka"), semconv.MessagingDestinationName("my_topic"))},
+	}, mr.Collect("messaging.kafka.producer.records_sent"))
+	assert.Len(t, mr.Collect("messaging.kafka.broker.request_latency"), len(saramaHistogramQuantiles))
+
+	require.NoError(t, reg.Unregister())
+	assert.Empty(t, mr.Collect("messaging.kafka.broker.incoming_bytes"))
+}
+
+func TestRegisterSaramaMetricsWithoutRegistry(t *testing.T) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.MetricRegistry = nil
+
+	_, err := RegisterSaramaMetrics(saramaConfig)
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+var errInstrument = errors.New("instrument creation failed")
+
+// failingMeterProvider provides meters failing to create instruments.
+type failingMeterProvider struct {
+	embedded.MeterProvider
+}
+
+func (failingMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return failingMeter{}
+}
+
+type failingMeter struct {
+	noop.Meter
+}
+
+func (failingMeter) Int64Counter(string, ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return nil, errInstrument
+}
+
+func (failingMeter) Int64ObservableGauge(string, ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	return nil, errInstrument
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	var errs []error
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1, Value: []byte("foo")},
+		WithMeterProvider(failingMeterProvider{}),
+		WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	assert.Equal(t, []byte("foo"), received.Value)
+	assert.NotEmpty(t, errs)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, errInstrument)
+	}
+}
+
+func TestInstrumentsPerConfig(t *testing.T) {
+	mr := newMetricRecorder()
+	newDispatcherInstruments(newConfig(WithMeterProvider(mr)))
+	assert.Equal(t, defaultDurationHistogramBoundaries, mr.Boundaries("messaging.client.operation.duration"))
+
+	// Wrappers sharing a meter create their instruments with their own
+	// options.
+	newDispatcherInstruments(newConfig(
+		WithMeterProvider(mr),
+		WithDurationHistogramBoundaries([]float64{1, 2}),
+	))
+	assert.Equal(t, []float64{1, 2}, mr.Boundaries("messaging.client.operation.duration"))
+	assert.Equal(t, 2, mr.Created("messaging.client.operation.duration"))
+}
+
+func TestInstrumentsSharedByWrapper(t *testing.T) {
+	mr := newMetricRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	wrapped := WrapConsumer(consumer, WithMeterProvider(mr))
+	handler := WrapConsumerGroupHandler(fakeConsumerGroupHandler{}, WithMeterProvider(mr))
+	session := &fakeConsumerGroupSession{ctx: context.Background()}
+	require.NoError(t, handler.Setup(session))
+
+	// Partitions and claims are consumed concurrently.
+	var wg sync.WaitGroup
+	for partition := int32(0); partition < 4; partition++ {
+		consumer.ExpectConsumePartition(topic, partition, 0)
+		wg.Add(2)
+		go func(partition int32) {
+			defer wg.Done()
+			pc, err := wrapped.ConsumePartition(topic, partition, 0)
+			assert.NoError(t, err)
+			assert.NoError(t, pc.Close())
+		}(partition)
+		go func(partition int32) {
+			defer wg.Done()
+			claim := &fakeConsumerGroupClaim{topic: topic, partition: partition, messages: make(chan *sarama.ConsumerMessage)}
+			close(claim.messages)
+			assert.NoError(t, handler.ConsumeClaim(session, claim))
+		}(partition)
+	}
+	wg.Wait()
+	require.NoError(t, handler.Cleanup(session))
+
+	// Once by the consumer and once by the consumer group handler.
+	assert.Equal(t, 2, mr.Created("messaging.kafka.consumed.bytes"))
+	assert.Equal(t, 2, mr.Created("messaging.client.operation.duration"))
+	assert.Equal(t, 1, mr.Created("messaging.client.consumer.errors"))
+}
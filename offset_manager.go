@@ -0,0 +1,206 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type offsetManager struct {
+	sarama.OffsetManager
+
+	cfg    config
+	errors *consumerErrorsRecorder
+
+	registration metric.Registration
+	unregister   sync.Once
+
+	mtx        sync.Mutex
+	partitions map[topicPartition]*partitionOffsetManager
+}
+
+// WrapOffsetManager wraps a sarama.OffsetManager causing each call to Commit
+// to be traced, the committed offsets to be observed until the offset manager
+// is closed, and the errors returned by the managed partitions to be counted.
+//
+// Only offsets committed via Commit are observed. If auto-commit is enabled,
+// offsets committed in the background are not reflected.
+func WrapOffsetManager(om sarama.OffsetManager, opts ...Option) sarama.OffsetManager {
+	if wrapped, ok := om.(*offsetManager); ok {
+		return wrapped
+	}
+	cfg := newConfig(opts...)
+
+	wrapped := &offsetManager{
+		OffsetManager: om,
+		cfg:           cfg,
+		errors:        newConsumerErrorsRecorder(cfg),
+		partitions:    make(map[topicPartition]*partitionOffsetManager),
+	}
+	wrapped.registration = cfg.int64ObservableGauge(
+		"messaging.kafka.consumer.committed_offset",
+		wrapped.observeCommittedOffsets,
+		metric.WithUnit("{offset}"),
+		metric.WithDescription("Next offset to consume of partitions as of their last commit."),
+	)
+	return wrapped
+}
+
+// Close stops observing the committed offsets and invokes
+// OffsetManager.Close.
+func (om *offsetManager) Close() error {
+	om.unregister.Do(func() {
+		om.cfg.unregister([]metric.Registration{om.registration})
+	})
+	return om.OffsetManager.Close()
+}
+
+// ManagePartition invokes OffsetManager.ManagePartition and wraps the
+// resulting PartitionOffsetManager.
+func (om *offsetManager) ManagePartition(topic string, partition int32) (sarama.PartitionOffsetManager, error) {
+	pom, err := om.OffsetManager.ManagePartition(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	tp := topicPartition{topic: topic, partition: partition}
+	wrapped := &partitionOffsetManager{
+		PartitionOffsetManager: pom,
+		errors:                 om.errors.wrapPartitionConsumerErrors(pom.Errors()),
+		committed:              -1,
+		release: func() {
+			om.mtx.Lock()
+			delete(om.partitions, tp)
+			om.mtx.Unlock()
+		},
+	}
+	om.mtx.Lock()
+	om.partitions[tp] = wrapped
+	om.mtx.Unlock()
+	return wrapped, nil
+}
+
+// Commit invokes OffsetManager.Commit and traces the commit.
+func (om *offsetManager) Commit() {
+	attrs := []attribute.KeyValue{semconv.MessagingSystem("kafka")}
+	attrs = append(attrs, om.cfg.operationAttributes("commit", "settle")...)
+	if om.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(om.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, om.cfg.Attributes...)
+	_, span := om.cfg.Tracer.Start(context.Background(), "commit",
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+
+	om.OffsetManager.Commit()
+
+	om.mtx.Lock()
+	for _, pom := range om.partitions {
+		pom.commit()
+	}
+	om.mtx.Unlock()
+
+	span.End()
+}
+
+func (om *offsetManager) observeCommittedOffsets(_ context.Context, o metric.Int64Observer) error {
+	om.mtx.Lock()
+	defer om.mtx.Unlock()
+
+	for tp, pom := range om.partitions {
+		committed := pom.committedOffset()
+		if committed < 0 {
+			continue
+		}
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(tp.topic),
+			semconv.MessagingKafkaSourcePartition(int(tp.partition)),
+		}
+		if om.cfg.ConsumerGroupID != "" {
+			attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(om.cfg.ConsumerGroupID))
+		}
+		attrs = append(attrs, om.cfg.Attributes...)
+		o.Observe(committed, om.cfg.withMetricAttributes(attrs...))
+	}
+	return nil
+}
+
+type partitionOffsetManager struct {
+	sarama.PartitionOffsetManager
+
+	errors  <-chan *sarama.ConsumerError
+	release func()
+
+	mtx       sync.Mutex
+	committed int64
+}
+
+// Errors returns a read channel of errors that occur during offset
+// management.
+func (pom *partitionOffsetManager) Errors() <-chan *sarama.ConsumerError {
+	return pom.errors
+}
+
+// AsyncClose stops observing the committed offset of the partition and
+// invokes PartitionOffsetManager.AsyncClose.
+func (pom *partitionOffsetManager) AsyncClose() {
+	pom.release()
+	pom.PartitionOffsetManager.AsyncClose()
+}
+
+// Close stops observing the committed offset of the partition, invokes
+// PartitionOffsetManager.AsyncClose and drains the remaining errors, which
+// are returned as sarama.ConsumerErrors like PartitionOffsetManager.Close
+// does.
+func (pom *partitionOffsetManager) Close() error {
+	pom.release()
+	pom.PartitionOffsetManager.AsyncClose()
+	var errs sarama.ConsumerErrors
+	for err := range pom.errors {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// commit records the offset marked at the time of a commit as committed.
+func (pom *partitionOffsetManager) commit() {
+	offset, _ := pom.NextOffset()
+	pom.mtx.Lock()
+	pom.committed = offset
+	pom.mtx.Unlock()
+}
+
+func (pom *partitionOffsetManager) committedOffset() int64 {
+	pom.mtx.Lock()
+	defer pom.mtx.Unlock()
+	return pom.committed
+}
@@ -0,0 +1,136 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+type fakeOffsetManager struct {
+	sarama.OffsetManager
+
+	commits int
+}
+
+func (om *fakeOffsetManager) ManagePartition(string, int32) (sarama.PartitionOffsetManager, error) {
+	return &fakePartitionOffsetManager{errors: make(chan *sarama.ConsumerError, 1)}, nil
+}
+
+func (om *fakeOffsetManager) Commit()      { om.commits++ }
+func (om *fakeOffsetManager) Close() error { return nil }
+
+// managingOffsetManager manages pom for every partition.
+type managingOffsetManager struct {
+	fakeOffsetManager
+
+	pom *fakePartitionOffsetManager
+}
+
+func (om *managingOffsetManager) ManagePartition(string, int32) (sarama.PartitionOffsetManager, error) {
+	return om.pom, nil
+}
+
+type fakePartitionOffsetManager struct {
+	sarama.PartitionOffsetManager
+
+	offset int64
+	errors chan *sarama.ConsumerError
+}
+
+func (pom *fakePartitionOffsetManager) NextOffset() (int64, string)       { return pom.offset, "" }
+func (pom *fakePartitionOffsetManager) MarkOffset(offset int64, _ string) { pom.offset = offset }
+func (pom *fakePartitionOffsetManager) Errors() <-chan *sarama.ConsumerError {
+	return pom.errors
+}
+
+func (pom *fakePartitionOffsetManager) AsyncClose() { close(pom.errors) }
+
+func (pom *fakePartitionOffsetManager) Close() error {
+	pom.AsyncClose()
+	var errs sarama.ConsumerErrors
+	for err := range pom.errors {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func TestWrapOffsetManager(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	fake := &fakeOffsetManager{}
+	om := WrapOffsetManager(fake, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	pom, err := om.ManagePartition(topic, 2)
+	require.NoError(t, err)
+	pom.MarkOffset(42, "")
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.committed_offset"))
+
+	om.Commit()
+	assert.Equal(t, 1, fake.commits)
+	assert.Equal(t, []measurement{{value: 42, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(2),
+	)}}, mr.Collect("messaging.kafka.consumer.committed_offset"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "commit", spans[0].name)
+
+	require.NoError(t, pom.Close())
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.committed_offset"))
+}
+
+func TestWrapOffsetManagerClose(t *testing.T) {
+	mr := newMetricRecorder()
+	om := WrapOffsetManager(&fakeOffsetManager{}, WithMeterProvider(mr))
+
+	pom, err := om.ManagePartition(topic, 0)
+	require.NoError(t, err)
+	pom.MarkOffset(7, "")
+	om.Commit()
+	require.Len(t, mr.Collect("messaging.kafka.consumer.committed_offset"), 1)
+
+	require.NoError(t, om.Close())
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.committed_offset"))
+	assert.Zero(t, mr.Registered())
+}
+
+func TestPartitionOffsetManagerCloseWithPendingErrors(t *testing.T) {
+	fake := &fakePartitionOffsetManager{errors: make(chan *sarama.ConsumerError, 1)}
+	om := WrapOffsetManager(&managingOffsetManager{pom: fake}, WithMeterProvider(newMetricRecorder()))
+	pom, err := om.ManagePartition(topic, 0)
+	require.NoError(t, err)
+
+	// Nobody reads the errors.
+	fake.errors <- &sarama.ConsumerError{Topic: topic, Err: sarama.ErrOffsetOutOfRange}
+	require.Eventually(t, func() bool { return len(fake.errors) == 0 }, time.Second, time.Millisecond)
+
+	var errs sarama.ConsumerErrors
+	require.ErrorAs(t, pom.Close(), &errs)
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], sarama.ErrOffsetOutOfRange)
+}
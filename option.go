@@ -15,38 +15,259 @@
 package otelsarama
 
 import (
+	"log/slog"
+	"math/rand"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
 const defaultTracerName = "go.opentelemetry.io/contrib/instrumentation/github.com/IBM/sarama/otelsarama"
 
+const (
+	clientIDKey             = attribute.Key("messaging.client.id")
+	kafkaVersionKey         = attribute.Key("messaging.kafka.version")
+	saramaVersionKey        = attribute.Key("messaging.kafka.sarama.version")
+	producerCompressionKey  = attribute.Key("messaging.kafka.producer.compression")
+	producerRequiredAcksKey = attribute.Key("messaging.kafka.producer.required_acks")
+	bootstrapServersKey     = attribute.Key("messaging.kafka.bootstrap.servers")
+	serverAddressKey        = attribute.Key("server.address")
+	serverPortKey           = attribute.Key("server.port")
+	networkPeerAddressKey   = attribute.Key("network.peer.address")
+	samplingPriorityKey     = attribute.Key("sampling.priority")
+
+	// Attribute keys of newer messaging semantic conventions, recorded with
+	// WithSemConvOptIn.
+	messagingOperationNameKey = attribute.Key("messaging.operation.name")
+	messagingOperationTypeKey = attribute.Key("messaging.operation.type")
+
+	// Attribute keys of newer messaging semantic conventions identifying
+	// the record written by a publish operation.
+	destinationPartitionIDKey = attribute.Key("messaging.destination.partition.id")
+	messageOffsetKey          = attribute.Key("messaging.kafka.message.offset")
+)
+
 type config struct {
 	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
 	Propagators    propagation.TextMapPropagator
 
+	// LoggerProvider is nil if the global logger provider is used.
+	LoggerProvider log.LoggerProvider
+
+	// ScopeName is empty if the default instrumentation scope name is used.
+	ScopeName string
+
+	ConsumerGroupID    string
+	ConsumerErrorSpans bool
+
+	// AutoCommitInterval is the interval sarama commits marked offsets in,
+	// or zero if auto-commit is disabled.
+	AutoCommitInterval time.Duration
+
+	// Attributes are added to all spans and metrics.
+	Attributes []attribute.KeyValue
+
+	Client sarama.Client
+	// leaders caches the leaders looked up with Client.
+	leaders *leaderCache
+
+	PeerService string
+
+	DestinationTemplate  func(topic string) string
+	TopicAttributeMapper func(topic string) []attribute.KeyValue
+
+	ErrorTypeMapper func(error) string
+	SpanStartHook   func(*sarama.ConsumerMessage) []trace.SpanStartOption
+	SpanSampler     func(topic string, partition int32) bool
+
+	PreviousMessageLinks bool
+	ClaimSpanMode        bool
+
+	// ReceiveSpanRatio is nil if receive spans are created for all messages.
+	ReceiveSpanRatio *float64
+
+	PriorityHeader string
+
+	HeaderAttributes map[string]attribute.Key
+
+	ConversationIDHeader  string
+	DeliveryAttemptHeader string
+
+	// DeadLetter is true for handlers instrumented with InstrumentDeadLetter.
+	DeadLetter bool
+
+	PayloadCapture  bool
+	PayloadMaxBytes int
+	KeyRedaction    KeyRedaction
+
+	MessageIDFormatter func(*sarama.ConsumerMessage) string
+
+	ReceiveSpanKind trace.SpanKind
+
+	// The operation names are empty if the default names are used.
+	ReceiveOperationName string
+	ProcessOperationName string
+	PublishOperationName string
+
+	TracesDisabled  bool
+	MetricsDisabled bool
+
+	EnabledFunc func() bool
+
+	TimeSource func() time.Time
+	// afterFunc is nil if timers are started with time.AfterFunc.
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+
+	MetricAttributeFilter attribute.Filter
+	SpanAttributeFilter   attribute.Filter
+
+	// AttributePreset is zero if all metric attributes are recorded.
+	AttributePreset AttributePreset
+
+	MetricAttributeExtractor *metricAttributeExtractor
+
+	SemConvOptIn bool
+
+	// BaggagePropagation is nil if baggage is propagated as configured by
+	// the propagators.
+	BaggagePropagation *bool
+
+	ExtractFallbacks []propagation.TextMapPropagator
+
+	InjectedHeadersFilter func(key string) bool
+
+	ContextCodec ContextCodec
+
+	CarrierOptions []CarrierOption
+
+	DeferReceiveSpanEnd         bool
+	ReceiveSpanExcludesDelivery bool
+	ReceiveStageEvents          bool
+
+	// MaxMessageAge is zero if the age of consumed messages is not checked.
+	MaxMessageAge time.Duration
+
+	// ProcessTimeoutWarning is zero if processing messages is not watched
+	// for timeouts.
+	ProcessTimeoutWarning time.Duration
+
+	RecoverPanics bool
+
+	MarkOnSuccess   bool
+	CommitOnSuccess bool
+
+	// DispatchBufferSize is the capacity of the messages channel of wrapped
+	// consumers, zero if it is unbuffered.
+	DispatchBufferSize int
+
+	ErrorHandler func(error)
+
+	Logger *slog.Logger
+
+	DurationHistogramBoundaries []float64
+
+	PartitionOrder bool
+
 	Tracer trace.Tracer
+	Meter  metric.Meter
 }
 
-// newConfig returns a config with all Options set.
+// defaultOptions holds the Options set by SetDefaultOptions.
+var (
+	defaultOptionsMtx sync.RWMutex
+	defaultOptions    []Option
+)
+
+// SetDefaultOptions sets Options applied to every wrapper created afterwards,
+// before the Options passed to the wrapper, e.g. to set organization-wide
+// propagators or attribute filters once. It replaces the Options set by
+// previous calls. Wrappers created before are not affected.
+func SetDefaultOptions(opts ...Option) {
+	defaultOptionsMtx.Lock()
+	defer defaultOptionsMtx.Unlock()
+	defaultOptions = append([]Option(nil), opts...)
+}
+
+// newConfig returns a config with the default Options and all Options set,
+// overridden by the environment variables set.
 func newConfig(opts ...Option) config {
 	cfg := config{
 		Propagators:    otel.GetTextMapPropagator(),
 		TracerProvider: otel.GetTracerProvider(),
+		MeterProvider:  otel.GetMeterProvider(),
+	}
+	defaultOptionsMtx.RLock()
+	defaults := defaultOptions
+	defaultOptionsMtx.RUnlock()
+	for _, opt := range defaults {
+		opt.apply(&cfg)
 	}
 	for _, opt := range opts {
 		opt.apply(&cfg)
 	}
+	cfg.applyEnv()
+
+	if len(cfg.ExtractFallbacks) > 0 {
+		cfg.Propagators = extractFallbacks{cfg.Propagators, cfg.ExtractFallbacks}
+	}
+	if cfg.BaggagePropagation != nil {
+		if *cfg.BaggagePropagation {
+			cfg.Propagators = propagation.NewCompositeTextMapPropagator(cfg.Propagators, propagation.Baggage{})
+		} else {
+			cfg.Propagators = withoutBaggage{cfg.Propagators}
+		}
+	}
+	cfg.MetricAttributeFilter = cfg.AttributePreset.apply(cfg.MetricAttributeFilter)
+	if cfg.Client != nil {
+		cfg.leaders = newLeaderCache(cfg.Client, cfg.now)
+	}
+	if cfg.TracesDisabled {
+		cfg.TracerProvider = trace.NewNoopTracerProvider()
+	}
+	if cfg.MetricsDisabled {
+		cfg.MeterProvider = noop.NewMeterProvider()
+	}
 
 	cfg.Tracer = cfg.TracerProvider.Tracer(
-		defaultTracerName,
+		cfg.scopeName(),
 		trace.WithInstrumentationVersion(Version()),
+		trace.WithSchemaURL(semconv.SchemaURL),
+	)
+	if cfg.SpanAttributeFilter != nil {
+		cfg.Tracer = attributeFilteringTracer{Tracer: cfg.Tracer, filter: cfg.SpanAttributeFilter}
+	}
+	cfg.Meter = cfg.MeterProvider.Meter(
+		cfg.scopeName(),
+		metric.WithInstrumentationVersion(Version()),
+		metric.WithSchemaURL(semconv.SchemaURL),
 	)
 
 	return cfg
 }
 
+// scopeName returns the name of the instrumentation scope of the tracer,
+// meter and logger.
+func (cfg config) scopeName() string {
+	if cfg.ScopeName != "" {
+		return cfg.ScopeName
+	}
+	return defaultTracerName
+}
+
 // Option interface used for setting optional config properties.
 type Option interface {
 	apply(*config)
@@ -68,6 +289,36 @@ func WithTracerProvider(provider trace.TracerProvider) Option {
 	})
 }
 
+// WithMeterProvider specifies a meter provider to use for creating a meter.
+// If none is specified, the global provider is used.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return optionFunc(func(cfg *config) {
+		if provider != nil {
+			cfg.MeterProvider = provider
+		}
+	})
+}
+
+// WithLoggerProvider specifies a logger provider to use for creating the
+// logger of [NewOTelSaramaLogger]. If none is specified, the global provider
+// is used.
+func WithLoggerProvider(provider log.LoggerProvider) Option {
+	return optionFunc(func(cfg *config) {
+		if provider != nil {
+			cfg.LoggerProvider = provider
+		}
+	})
+}
+
+// WithScopeName specifies the name of the instrumentation scope of the
+// tracer, meter and logger, e.g. to route telemetry by scope. If none is
+// specified, the import path of the contrib instrumentation is used.
+func WithScopeName(name string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ScopeName = name
+	})
+}
+
 // WithPropagators specifies propagators to use for extracting
 // information from the HTTP requests. If none are specified, global
 // ones will be used.
@@ -78,3 +329,741 @@ func WithPropagators(propagators propagation.TextMapPropagator) Option {
 		}
 	})
 }
+
+// WithConsumerGroupID specifies the ID of the consumer group a wrapped
+// consumer group handler belongs to. It is recorded on rebalance spans and
+// metrics, as sarama does not expose it via the consumer group session.
+func WithConsumerGroupID(groupID string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ConsumerGroupID = groupID
+	})
+}
+
+// WithConsumerErrorSpans enables emitting a receive span with an exception
+// event and error status for each error returned by the Errors channel of a
+// wrapped partition consumer or consumer group. By default, such errors are
+// only counted.
+func WithConsumerErrorSpans() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ConsumerErrorSpans = true
+	})
+}
+
+// WithSaramaConfig derives attributes describing the client from the passed
+// sarama config and adds them to all spans and metrics: the client ID, the
+// Kafka version, the version of the sarama library if the binary was built
+// with module support, and the producer's compression codec and required
+// acks. If auto-commit is enabled, wrapped consumer group handlers record
+// the offsets marked when its interval elapses as marked at the auto-commit
+// tick; only offsets committed via Commit are recorded as committed.
+func WithSaramaConfig(saramaConfig *sarama.Config) Option {
+	return optionFunc(func(cfg *config) {
+		if saramaConfig == nil {
+			return
+		}
+		if saramaConfig.ClientID != "" {
+			cfg.Attributes = append(cfg.Attributes, clientIDKey.String(saramaConfig.ClientID))
+		}
+		cfg.Attributes = append(cfg.Attributes, kafkaVersionKey.String(saramaConfig.Version.String()))
+		if v := saramaVersion(); v != "" {
+			cfg.Attributes = append(cfg.Attributes, saramaVersionKey.String(v))
+		}
+		cfg.Attributes = append(cfg.Attributes,
+			producerCompressionKey.String(saramaConfig.Producer.Compression.String()),
+			producerRequiredAcksKey.Int(int(saramaConfig.Producer.RequiredAcks)),
+		)
+		if saramaConfig.Consumer.Offsets.AutoCommit.Enable {
+			cfg.AutoCommitInterval = saramaConfig.Consumer.Offsets.AutoCommit.Interval
+		}
+	})
+}
+
+// saramaVersion returns the version of the sarama module the binary was
+// built with, or an empty string if it is unknown.
+var saramaVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/IBM/sarama" {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return ""
+})
+
+// WithClientID specifies the client ID recorded as messaging.client.id on all
+// spans and metrics. WithSaramaConfig records the ClientID of the sarama
+// config instead.
+func WithClientID(id string) Option {
+	return optionFunc(func(cfg *config) {
+		if id != "" {
+			cfg.Attributes = append(cfg.Attributes, clientIDKey.String(id))
+		}
+	})
+}
+
+// WithPeerService specifies the name of the Kafka cluster, or of the logical
+// service on its other side, recorded as peer.service on publish, receive and
+// process spans.
+func WithPeerService(name string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PeerService = name
+	})
+}
+
+// WithDestinationTemplate specifies a function mapping topics to the
+// template they were subscribed by, e.g. the pattern of a regex
+// subscription, or to an empty string if they have none. Spans record the
+// template as messaging.destination.template next to the topic, while
+// counters and histograms record it instead of the topic, so they aggregate
+// across the topics matching the template.
+func WithDestinationTemplate(fn func(topic string) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DestinationTemplate = fn
+	})
+}
+
+// WithTopicAttributeMapper specifies a function deriving attributes from the
+// names of topics, e.g. tenant.id from the tenantA in tenantA.orders. The
+// attributes are recorded on spans and metrics of messages of the topic.
+func WithTopicAttributeMapper(fn func(topic string) []attribute.KeyValue) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TopicAttributeMapper = fn
+	})
+}
+
+// WithBrokerAddresses records the addresses of the brokers used to bootstrap
+// the client. They are added to all spans and metrics as a list, rather than
+// as server.address, as any of the brokers might be the one serving a
+// message. If a single address is passed, it is the only broker and is also
+// recorded as server.address and server.port.
+func WithBrokerAddresses(addrs ...string) Option {
+	return optionFunc(func(cfg *config) {
+		if len(addrs) == 0 {
+			return
+		}
+		cfg.Attributes = append(cfg.Attributes, bootstrapServersKey.StringSlice(addrs))
+		if len(addrs) == 1 {
+			cfg.Attributes = append(cfg.Attributes, serverAttributes(addrs[0])...)
+		}
+	})
+}
+
+// serverAttributes returns the server.address and server.port attributes
+// for a broker address of the form host:port.
+func serverAttributes(addr string) []attribute.KeyValue {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []attribute.KeyValue{serverAddressKey.String(addr)}
+	}
+	attrs := []attribute.KeyValue{serverAddressKey.String(host)}
+	if port, err := strconv.Atoi(portStr); err == nil {
+		attrs = append(attrs, serverPortKey.Int(port))
+	}
+	return attrs
+}
+
+// WithClient specifies a sarama client used to look up the leader broker of
+// the partition a message is consumed from or produced to. The leader's
+// address is recorded as server.address, server.port and
+// network.peer.address on receive and publish spans.
+//
+// Leaders are looked up in the background and cached for 30 seconds, as a
+// lookup may refresh the client's metadata, e.g. if the partition is unknown
+// to the client. Spans are recorded without the leader until it is known.
+// Lookups are not traced if client is wrapped with WrapClient.
+func WithClient(client sarama.Client) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.Client = client
+	})
+}
+
+// leaderAttributes returns the attributes describing the leader broker of
+// the partition, if a client was configured and the leader is known.
+func (cfg config) leaderAttributes(topic string, partition int32) []attribute.KeyValue {
+	if cfg.leaders == nil || partition < 0 {
+		return nil
+	}
+	addr := cfg.leaders.leader(topic, partition)
+	if addr == "" {
+		return nil
+	}
+	attrs := serverAttributes(addr)
+	return append(attrs, networkPeerAddressKey.String(attrs[0].Value.AsString()))
+}
+
+// WithErrorTypeMapper specifies a function mapping errors to the value of the
+// error.type attribute recorded on spans and metrics, allowing errors to be
+// classified by a bounded, application specific taxonomy. If the function
+// returns an empty string, the default classification is used: Kafka errors
+// are described by their message and all other errors by their type.
+func WithErrorTypeMapper(fn func(error) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ErrorTypeMapper = fn
+	})
+}
+
+// WithSpanStartHook specifies a function called for each consumed message
+// whose returned options are applied when starting the message's receive
+// and process spans, e.g. to add attributes derived from headers or the key.
+func WithSpanStartHook(fn func(msg *sarama.ConsumerMessage) []trace.SpanStartOption) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SpanStartHook = fn
+	})
+}
+
+// WithSpanSampler specifies a function deciding per topic and partition
+// whether receive and process spans are created for consumed messages. It is
+// evaluated before spans are started, so high-volume topics can be sampled
+// down without the cost of creating spans. Metrics are recorded for all
+// messages. By default, spans are created for all messages.
+func WithSpanSampler(fn func(topic string, partition int32) bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SpanSampler = fn
+	})
+}
+
+// WithPriorityHeader specifies a record header marking consumed messages for
+// debugging. Receive and process spans are created for messages carrying the
+// header regardless of WithSpanSampler and WithReceiveSpanRatio. Their parent
+// span context is marked as sampled, so parent-based samplers record them,
+// and they are started with the sampling.priority attribute set to 1 for
+// other samplers to consider.
+func WithPriorityHeader(header string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PriorityHeader = header
+	})
+}
+
+// WithReceiveSpanRatio specifies the fraction of consumed messages receive
+// spans are created for, e.g. 0.01 for one in a hundred messages. Messages
+// are picked at random, independently of the sampler of the tracer provider,
+// and metrics are recorded for all messages. Process spans are not affected.
+// By default, receive spans are created for all messages.
+func WithReceiveSpanRatio(ratio float64) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ReceiveSpanRatio = &ratio
+	})
+}
+
+// WithPreviousMessageLinks links the receive and process span of each
+// consumed message to the respective span of the message previously consumed
+// from the same partition, so ordered processing can be followed across
+// traces.
+func WithPreviousMessageLinks() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PreviousMessageLinks = true
+	})
+}
+
+// WithClaimSpanMode traces each ConsumeClaim invocation of handlers wrapped
+// with WrapConsumerGroupHandler in a single receive span instead of a receive
+// span per message. Consumed messages are recorded as events of that span,
+// which carries their number as messaging.batch.message_count once the claim
+// is consumed, and its context is propagated in the messages.
+func WithClaimSpanMode() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ClaimSpanMode = true
+	})
+}
+
+// WithConversationIDHeader specifies the record header holding the ID of the
+// conversation a message belongs to, e.g. correlating requests and replies.
+// The header name is matched case-insensitively.
+// It is recorded as messaging.message.conversation_id on publish, receive and
+// process spans.
+func WithConversationIDHeader(header string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ConversationIDHeader = header
+	})
+}
+
+// WithDeliveryAttemptHeader specifies the record header holding the delivery
+// attempt of consumed messages, e.g. set by retry frameworks republishing
+// failed messages. The attempt is recorded as
+// messaging.kafka.message.delivery_attempt on process spans, and messages
+// with an attempt above 1 are counted by the
+// messaging.kafka.consumer.redelivered_messages counter. Messages without the
+// header are counted if a message at or after their offset was processed
+// before, e.g. after an offset reset. This assumes the messages of a
+// partition are processed in order.
+func WithDeliveryAttemptHeader(header string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DeliveryAttemptHeader = header
+	})
+}
+
+// WithHeaderAttributes specifies record headers to be copied into attributes
+// of receive spans and of the process spans of Instrument. The map is keyed
+// by header name, matched case-insensitively like the headers of propagation
+// fields, its values are the attribute keys to record the header values as.
+// Values longer than 256 bytes are truncated at a UTF-8 rune boundary.
+func WithHeaderAttributes(headers map[string]attribute.Key) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.HeaderAttributes = headers
+	})
+}
+
+// WithPayloadCapture enables recording the first maxBytes bytes of the value
+// of consumed and produced messages, cut at a UTF-8 rune boundary, as
+// attribute of their receive and publish spans. Payloads are not captured by
+// default, as they may contain personal data and increase the size of spans.
+func WithPayloadCapture(maxBytes int) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PayloadCapture = true
+		cfg.PayloadMaxBytes = maxBytes
+	})
+}
+
+// WithReceiveSpanKind specifies the kind of receive spans and of the process
+// spans of Instrument. It defaults to trace.SpanKindConsumer, but some
+// backends treat consumer spans specially, e.g. as entry points, which may be
+// undesirable.
+func WithReceiveSpanKind(kind trace.SpanKind) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ReceiveSpanKind = kind
+	})
+}
+
+// enabled reports whether the next message is instrumented.
+func (cfg config) enabled() bool {
+	return cfg.EnabledFunc == nil || cfg.EnabledFunc()
+}
+
+// now returns the current time of the configured time source.
+func (cfg config) now() time.Time {
+	if cfg.TimeSource == nil {
+		return time.Now()
+	}
+	return cfg.TimeSource()
+}
+
+// startTimer calls f in its own goroutine after d has elapsed, unless the
+// returned function is called first.
+func (cfg config) startTimer(d time.Duration, f func()) (stop func() bool) {
+	if cfg.afterFunc == nil {
+		return time.AfterFunc(d, f).Stop
+	}
+	return cfg.afterFunc(d, f)
+}
+
+// since returns the time elapsed since start according to the configured time
+// source.
+func (cfg config) since(start time.Time) time.Duration {
+	return cfg.now().Sub(start)
+}
+
+// destinationTemplateAttributes returns the messaging.destination.template
+// attribute of topic if WithDestinationTemplate maps it to a template.
+func (cfg config) destinationTemplateAttributes(topic string) []attribute.KeyValue {
+	if cfg.DestinationTemplate == nil {
+		return nil
+	}
+	if template := cfg.DestinationTemplate(topic); template != "" {
+		return []attribute.KeyValue{semconv.MessagingDestinationTemplate(template)}
+	}
+	return nil
+}
+
+// topicAttributes returns the attributes WithTopicAttributeMapper derives
+// from topic.
+func (cfg config) topicAttributes(topic string) []attribute.KeyValue {
+	if cfg.TopicAttributeMapper == nil {
+		return nil
+	}
+	return cfg.TopicAttributeMapper(topic)
+}
+
+// destinationMetricAttribute returns the attribute identifying topic on
+// counters and histograms: its template if WithDestinationTemplate maps it
+// to one, otherwise its name.
+func (cfg config) destinationMetricAttribute(topic string) attribute.KeyValue {
+	if attrs := cfg.destinationTemplateAttributes(topic); len(attrs) > 0 {
+		return attrs[0]
+	}
+	return semconv.MessagingDestinationName(topic)
+}
+
+// operationName returns the name of the receive, process or publish
+// operation in span names, as overridden by WithOperationName.
+func (cfg config) operationName(operation string) string {
+	var name string
+	switch operation {
+	case "receive":
+		name = cfg.ReceiveOperationName
+	case "process":
+		name = cfg.ProcessOperationName
+	case "publish":
+		name = cfg.PublishOperationName
+	}
+	if name == "" {
+		return operation
+	}
+	return name
+}
+
+// operationAttributes returns the messaging.operation.name and
+// messaging.operation.type attributes of an operation if WithSemConvOptIn is
+// set. The name is replaced if WithOperationName overrides the name of typ.
+func (cfg config) operationAttributes(name, typ string) []attribute.KeyValue {
+	if !cfg.SemConvOptIn {
+		return nil
+	}
+	if renamed := cfg.operationName(typ); renamed != typ {
+		name = renamed
+	}
+	return []attribute.KeyValue{
+		messagingOperationNameKey.String(name),
+		messagingOperationTypeKey.String(typ),
+	}
+}
+
+// spanSampled reports whether spans are created for messages consumed from
+// partition of topic.
+func (cfg config) spanSampled(topic string, partition int32) bool {
+	return cfg.SpanSampler == nil || cfg.SpanSampler(topic, partition)
+}
+
+// receiveSpanSampled reports whether a receive span is created for the next
+// consumed message.
+func (cfg config) receiveSpanSampled() bool {
+	return cfg.ReceiveSpanRatio == nil || rand.Float64() < *cfg.ReceiveSpanRatio
+}
+
+// prioritized reports whether msg carries the header specified by
+// WithPriorityHeader.
+func (cfg config) prioritized(msg *sarama.ConsumerMessage) bool {
+	if cfg.PriorityHeader == "" {
+		return false
+	}
+	for _, h := range msg.Headers {
+		if h != nil && equalHeaderKey(h.Key, cfg.PriorityHeader) {
+			return true
+		}
+	}
+	return false
+}
+
+// consumerSpanKind returns the configured kind of receive and process spans.
+func (cfg config) consumerSpanKind() trace.SpanKind {
+	if cfg.ReceiveSpanKind == trace.SpanKindUnspecified {
+		return trace.SpanKindConsumer
+	}
+	return cfg.ReceiveSpanKind
+}
+
+// WithoutTraces disables tracing. No spans are created and no trace context
+// is extracted from or injected into messages, while metrics are still
+// recorded.
+func WithoutTraces() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TracesDisabled = true
+	})
+}
+
+// WithoutMetrics disables recording metrics, while spans are still created.
+func WithoutMetrics() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricsDisabled = true
+	})
+}
+
+// WithEnabledFunc specifies a function consulted for each message to decide
+// whether it is instrumented at all, e.g. backed by an atomic.Bool operators
+// can flip during incidents. Messages are passed through without any span,
+// metric or allocation while it returns false. By default, all messages are
+// instrumented.
+func WithEnabledFunc(fn func() bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.EnabledFunc = fn
+	})
+}
+
+// WithSemConvOptIn records attributes of newer messaging semantic conventions
+// in addition to the ones of semantic conventions v1.17.0, e.g.
+// messaging.operation.name and messaging.operation.type next to
+// messaging.operation. The tracer, meter and logger keep the schema URL of
+// semantic conventions v1.17.0.
+func WithSemConvOptIn() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SemConvOptIn = true
+	})
+}
+
+// WithMetricAttributeFilter specifies a filter deciding which attributes are
+// recorded on metrics, e.g. to drop the partition from metrics to limit their
+// cardinality. Attributes the filter returns false for are dropped. It does
+// not affect spans.
+func WithMetricAttributeFilter(filter attribute.Filter) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricAttributeFilter = filter
+	})
+}
+
+// WithAttributePreset selects which of the topic, partition, consumer group
+// and message ID attributes are recorded on metrics, to stay within the
+// cardinality limits of metrics backends without hand-rolled filters. It can
+// be combined with WithMetricAttributeFilter, attributes are recorded if both
+// accept them. It does not affect spans. By default, all attributes are
+// recorded.
+func WithAttributePreset(preset AttributePreset) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.AttributePreset = preset
+	})
+}
+
+// WithMetricAttributeExtractor specifies a function deriving attributes of
+// consumed messages, e.g. their event type read from a header, recorded on
+// the throughput and duration metrics of receive and process operations. It
+// does not affect spans. To bound the cardinality of metrics, attributes of at
+// most 8 distinct keys and at most 100 distinct values per key are recorded.
+// Further keys are dropped, further values are recorded as "_OTHER".
+func WithMetricAttributeExtractor(fn func(msg *sarama.ConsumerMessage) []attribute.KeyValue) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricAttributeExtractor = &metricAttributeExtractor{
+			fn:     fn,
+			values: make(map[attribute.Key]map[attribute.Value]struct{}),
+		}
+	})
+}
+
+// WithSpanAttributeFilter specifies a filter deciding which attributes are
+// recorded on spans. Attributes the filter returns false for are dropped.
+// Attributes of span events are not filtered. It does not affect metrics.
+func WithSpanAttributeFilter(filter attribute.Filter) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SpanAttributeFilter = filter
+	})
+}
+
+// WithTimeSource specifies the function returning the current time when
+// measuring durations of receiving, processing and publishing messages, e.g. a
+// fake clock in tests. It defaults to time.Now.
+func WithTimeSource(now func() time.Time) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TimeSource = now
+	})
+}
+
+// WithBaggagePropagation specifies whether baggage is propagated through
+// messages. If enabled, baggage is propagated in addition to what the
+// configured propagators propagate, making baggage set by producers available
+// in consumer contexts. If disabled, baggage is neither injected into nor
+// extracted from messages, e.g. for topics shared with untrusted parties.
+// By default, baggage is propagated only if the configured propagators do so.
+func WithBaggagePropagation(enabled bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.BaggagePropagation = &enabled
+	})
+}
+
+// WithInjectedHeadersFilter specifies a function deciding which propagation
+// fields are injected into produced messages, e.g. to drop baggage from
+// messages leaving a trust boundary. It is called with the field names of the
+// propagators, before WithHeaderPrefix and WithHeaderRenames are applied. By
+// default, all fields are injected.
+func WithInjectedHeadersFilter(fn func(key string) bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.InjectedHeadersFilter = fn
+	})
+}
+
+// WithContextCodec specifies a codec embedding the span context of the
+// publish span into the key or value of produced messages instead of their
+// headers, for clusters or messages that cannot carry record headers.
+// Wrapped consumers configured with the same codec remove the embedded
+// fields from consumed messages and extract the span context from them. The
+// messages returned by async producers carry the embedded fields.
+func WithContextCodec(codec ContextCodec) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ContextCodec = codec
+	})
+}
+
+// WithExtractFallbacks specifies propagators tried in order when extracting
+// a span context from a message the configured propagators find none in,
+// e.g. to accept B3 or Jaeger headers of legacy producers. Fallbacks are not
+// used to inject span contexts.
+func WithExtractFallbacks(propagators ...propagation.TextMapPropagator) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ExtractFallbacks = append(cfg.ExtractFallbacks, propagators...)
+	})
+}
+
+// WithCarrierOptions specifies options for the carriers span contexts are
+// injected into and extracted from messages with, e.g. WithHeaderPrefix.
+func WithCarrierOptions(opts ...CarrierOption) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.CarrierOptions = append(cfg.CarrierOptions, opts...)
+	})
+}
+
+// WithOperationName overrides the names of the receive, process and publish
+// operations in span names, e.g. "poll", "handle" and "send" to name spans
+// "<topic> poll", "<topic> handle" and "<topic> send", and in the
+// messaging.operation.name attribute recorded with WithSemConvOptIn. Empty
+// names keep the default name of their operation.
+func WithOperationName(receive, process, publish string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ReceiveOperationName = receive
+		cfg.ProcessOperationName = process
+		cfg.PublishOperationName = publish
+	})
+}
+
+// WithDeferredReceiveSpanEnd keeps receive spans open after messages are
+// handed over until Done is called for them, so their duration covers the
+// processing of the messages. Done must be called for every consumed
+// message. Spans Done was not called for are ended with an error once the
+// claim they were consumed from is consumed, or once their partition
+// consumer is closed with Close. Otherwise they are never released.
+func WithDeferredReceiveSpanEnd() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DeferReceiveSpanEnd = true
+	})
+}
+
+// WithReceiveSpanIncludesDelivery specifies whether receive spans cover
+// handing messages over until they are read from the messages channel. With
+// false, receive spans end before messages are handed over, so slow
+// consumers do not prolong them; the time blocked is still recorded by the
+// messaging.client.delivery.blocked_time histogram. It has no effect with
+// WithDeferredReceiveSpanEnd. Receive spans include the delivery by default.
+func WithReceiveSpanIncludesDelivery(include bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ReceiveSpanExcludesDelivery = !include
+	})
+}
+
+// WithReceiveStageEvents adds events marking the stages of receiving a
+// message to its receive span: "context.extracted" once the propagated
+// context was extracted and "delivered.to.channel" once the message is
+// handed over to the messages channel. The time after the latter is spent
+// waiting for the application to read the message, so the share of
+// instrumentation overhead and of channel backpressure in the duration of
+// the span can be told apart.
+func WithReceiveStageEvents() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ReceiveStageEvents = true
+	})
+}
+
+// WithProcessTimeoutWarning makes handlers wrapped by Instrument warn about
+// messages whose processing takes longer than d: a "process.timeout" event is
+// added to the process span, a warning is logged to the logger configured
+// with WithLogger and messaging.client.process.timeouts is incremented.
+// Processing is not canceled. By default, processing is not watched.
+func WithProcessTimeoutWarning(d time.Duration) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ProcessTimeoutWarning = d
+	})
+}
+
+// WithPanicRecovery makes handlers wrapped by Instrument recover panics of
+// the handler they wrap and return them as *PanicError. By default, panics
+// are recorded and re-raised.
+func WithPanicRecovery() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RecoverPanics = true
+	})
+}
+
+// WithMarkOnSuccess makes handlers wrapped by Instrument mark messages they
+// processed without error in the consumer group session carried by the
+// context, see ContextWithSession, and commit the session afterwards if
+// commit is true. Marking and committing are recorded as "message.marked"
+// and "offsets.committed" events on the process span. Messages are marked
+// even while instrumentation is disabled with WithEnabledFunc. By default,
+// handlers mark messages themselves.
+func WithMarkOnSuccess(commit bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MarkOnSuccess = true
+		cfg.CommitOnSuccess = commit
+	})
+}
+
+// WithDispatchBufferSize specifies the capacity of the messages channel
+// wrapped partition consumers and consumer group claims hand consumed
+// messages over in. The number of messages buffered in it is observed in
+// messaging.kafka.consumer.dispatch.depth and its capacity in
+// messaging.kafka.consumer.dispatch.capacity, telling whether the
+// instrumentation or the consumption loop is the bottleneck. By default, the
+// channel is unbuffered.
+func WithDispatchBufferSize(size int) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DispatchBufferSize = size
+	})
+}
+
+// WithMaxMessageAge specifies the maximum age of consumed messages, e.g. the
+// latency SLO of a consumer. Messages whose timestamp is older than d when
+// they are received are counted in messaging.kafka.message.stale and their
+// receive span is marked with the messaging.kafka.message.stale attribute,
+// signaling the consumer is falling behind. By default, the age of messages
+// is not checked.
+func WithMaxMessageAge(d time.Duration) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MaxMessageAge = d
+	})
+}
+
+// WithErrorHandler specifies a function errors of the instrumentation
+// itself, e.g. failures to create instruments, are reported to. By default,
+// they are reported to the global OpenTelemetry error handler.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ErrorHandler = fn
+	})
+}
+
+// WithLogger specifies a logger the wrappers log operational issues to, such
+// as stalled message dispatching or failures to extract a trace context from
+// consumed messages. Records carry the trace_id and span_id of the span they
+// relate to. By default, nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.Logger = logger
+	})
+}
+
+// WithDurationHistogramBoundaries specifies the bucket boundaries in seconds
+// advised for the duration histograms of publish and receive operations.
+// By default, boundaries from 5ms to 10s are advised.
+func WithDurationHistogramBoundaries(boundaries []float64) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DurationHistogramBoundaries = boundaries
+	})
+}
+
+// WithPartitionOrder makes a ConcurrentProcessor process the messages of a
+// partition one after another, in the order they were consumed.
+func WithPartitionOrder() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PartitionOrder = true
+	})
+}
+
+// WithKeyRedaction specifies how message keys are redacted before they are
+// recorded as messaging.kafka.message.key attribute, e.g. HashSHA256,
+// Truncate or Drop. By default, keys are recorded verbatim.
+func WithKeyRedaction(redaction KeyRedaction) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.KeyRedaction = redaction
+	})
+}
+
+// WithMessageIDFormatter specifies a function formatting the
+// messaging.message.id of consumed messages, e.g. PartitionOffsetMessageID
+// or a function returning the value of a ce_id header. By default, the
+// offset of a message is its ID.
+func WithMessageIDFormatter(fn func(msg *sarama.ConsumerMessage) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MessageIDFormatter = fn
+	})
+}
@@ -17,17 +17,26 @@ package otelsarama
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/stretchr/testify/assert"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
 )
 
 // We need a fake tracer provider to ensure the one passed in options is the one used afterwards.
 // In order to avoid adding the SDK as a dependency, we use this mock.
-type fakeTracerProvider struct{}
+type fakeTracerProvider struct {
+	embedded.TracerProvider
+}
 
 func (fakeTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
 	return fakeTracer{
@@ -36,6 +45,8 @@ func (fakeTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.
 }
 
 type fakeTracer struct {
+	embedded.Tracer
+
 	name string
 }
 
@@ -45,7 +56,12 @@ func (fakeTracer) Start(ctx context.Context, spanName string, opts ...trace.Span
 
 func TestNewConfig(t *testing.T) {
 	tp := fakeTracerProvider{}
+	mp := newMetricRecorder()
 	prop := propagation.NewCompositeTextMapPropagator()
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = "my-client"
+	saramaConfig.Version = sarama.V2_8_0_0
+	saramaConfig.Producer.Compression = sarama.CompressionZSTD
 
 	testCases := []struct {
 		name     string
@@ -59,8 +75,25 @@ func TestNewConfig(t *testing.T) {
 			},
 			expected: config{
 				TracerProvider: tp,
-				Tracer:         tp.Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version())),
+				Tracer:         tp.Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+			},
+		},
+		{
+			name: "with scope name",
+			opts: []Option{
+				WithTracerProvider(tp),
+				WithScopeName("my-scope"),
+			},
+			expected: config{
+				ScopeName:      "my-scope",
+				TracerProvider: tp,
+				Tracer:         tp.Tracer("my-scope", trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
 				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter("my-scope", metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
 			},
 		},
 		{
@@ -70,8 +103,138 @@ func TestNewConfig(t *testing.T) {
 			},
 			expected: config{
 				TracerProvider: otel.GetTracerProvider(),
-				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version())),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+			},
+		},
+		{
+			name: "with meter provider",
+			opts: []Option{
+				WithMeterProvider(mp),
+			},
+			expected: config{
+				TracerProvider: otel.GetTracerProvider(),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  mp,
+				Meter:          mp,
+			},
+		},
+		{
+			name: "with consumer group ID",
+			opts: []Option{
+				WithConsumerGroupID("my-group"),
+			},
+			expected: config{
+				TracerProvider:  otel.GetTracerProvider(),
+				Tracer:          otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:     otel.GetTextMapPropagator(),
+				MeterProvider:   otel.GetMeterProvider(),
+				Meter:           otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+				ConsumerGroupID: "my-group",
+			},
+		},
+		{
+			name: "with sarama config",
+			opts: []Option{
+				WithSaramaConfig(saramaConfig),
+			},
+			expected: config{
+				TracerProvider: otel.GetTracerProvider(),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+				Attributes: []attribute.KeyValue{
+					clientIDKey.String("my-client"),
+					kafkaVersionKey.String("2.8.0"),
+					saramaVersionKey.String(saramaVersion()),
+					producerCompressionKey.String("zstd"),
+					producerRequiredAcksKey.Int(1),
+				},
+				AutoCommitInterval: time.Second,
+			},
+		},
+		{
+			name: "with client ID",
+			opts: []Option{
+				WithClientID("my-client"),
+			},
+			expected: config{
+				TracerProvider: otel.GetTracerProvider(),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+				Attributes: []attribute.KeyValue{
+					clientIDKey.String("my-client"),
+				},
+			},
+		},
+		{
+			name: "with broker addresses",
+			opts: []Option{
+				WithBrokerAddresses("kafka-1:9092", "kafka-2:9092"),
+			},
+			expected: config{
+				TracerProvider: otel.GetTracerProvider(),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
 				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+				Attributes: []attribute.KeyValue{
+					bootstrapServersKey.StringSlice([]string{"kafka-1:9092", "kafka-2:9092"}),
+				},
+			},
+		},
+		{
+			name: "with single broker address",
+			opts: []Option{
+				WithBrokerAddresses("kafka-1:9092"),
+			},
+			expected: config{
+				TracerProvider: otel.GetTracerProvider(),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+				Attributes: []attribute.KeyValue{
+					bootstrapServersKey.StringSlice([]string{"kafka-1:9092"}),
+					serverAddressKey.String("kafka-1"),
+					serverPortKey.Int(9092),
+				},
+			},
+		},
+		{
+			name: "without traces",
+			opts: []Option{
+				WithTracerProvider(tp),
+				WithoutTraces(),
+			},
+			expected: config{
+				TracerProvider: trace.NewNoopTracerProvider(),
+				Tracer:         trace.NewNoopTracerProvider().Tracer(""),
+				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
+				TracesDisabled: true,
+			},
+		},
+		{
+			name: "without metrics",
+			opts: []Option{
+				WithMeterProvider(mp),
+				WithoutMetrics(),
+			},
+			expected: config{
+				TracerProvider:  otel.GetTracerProvider(),
+				Tracer:          otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
+				Propagators:     otel.GetTextMapPropagator(),
+				MeterProvider:   noop.NewMeterProvider(),
+				Meter:           noop.NewMeterProvider().Meter(""),
+				MetricsDisabled: true,
 			},
 		},
 		{
@@ -81,8 +244,10 @@ func TestNewConfig(t *testing.T) {
 			},
 			expected: config{
 				TracerProvider: otel.GetTracerProvider(),
-				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version())),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
 				Propagators:    prop,
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
 			},
 		},
 		{
@@ -92,8 +257,10 @@ func TestNewConfig(t *testing.T) {
 			},
 			expected: config{
 				TracerProvider: otel.GetTracerProvider(),
-				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version())),
+				Tracer:         otel.GetTracerProvider().Tracer(defaultTracerName, trace.WithInstrumentationVersion(Version()), trace.WithSchemaURL(semconv.SchemaURL)),
 				Propagators:    otel.GetTextMapPropagator(),
+				MeterProvider:  otel.GetMeterProvider(),
+				Meter:          otel.GetMeterProvider().Meter(defaultTracerName, metric.WithInstrumentationVersion(Version()), metric.WithSchemaURL(semconv.SchemaURL)),
 			},
 		},
 	}
@@ -105,3 +272,33 @@ func TestNewConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestSetDefaultOptions(t *testing.T) {
+	SetDefaultOptions(WithConsumerGroupID("default-group"), WithClientID("default-client"))
+	t.Cleanup(func() { SetDefaultOptions() })
+
+	cfg := newConfig(WithConsumerGroupID("my-group"))
+	assert.Equal(t, "my-group", cfg.ConsumerGroupID)
+	assert.Equal(t, []attribute.KeyValue{clientIDKey.String("default-client")}, cfg.Attributes)
+
+	SetDefaultOptions()
+	cfg = newConfig()
+	assert.Empty(t, cfg.ConsumerGroupID)
+	assert.Empty(t, cfg.Attributes)
+}
+
+func TestWithOperationName(t *testing.T) {
+	cfg := newConfig(WithOperationName("poll", "handle", ""), WithSemConvOptIn())
+
+	assert.Equal(t, "poll", cfg.operationName("receive"))
+	assert.Equal(t, "handle", cfg.operationName("process"))
+	assert.Equal(t, "publish", cfg.operationName("publish"))
+	assert.Equal(t, []attribute.KeyValue{
+		messagingOperationNameKey.String("handle"),
+		messagingOperationTypeKey.String("process"),
+	}, cfg.operationAttributes("process", "process"))
+	assert.Equal(t, []attribute.KeyValue{
+		messagingOperationNameKey.String("send"),
+		messagingOperationTypeKey.String("publish"),
+	}, cfg.operationAttributes("send", "publish"))
+}
@@ -0,0 +1,27 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package otelsaramatest provides helpers to unit test code instrumented with
+otelsarama without a Kafka broker.
+
+A Recorder records the spans and metrics of the instrumentation in memory,
+NewConsumerMessage and NewProducerMessage build messages carrying a trace
+context, and the Require methods of a Recorder assert on what was recorded.
+
+This package is in a separate module from the instrumentation to isolate the
+dependency of the default SDK and not impose this as a transitive dependency
+for users.
+*/
+package otelsaramatest
@@ -0,0 +1,95 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsaramatest
+
+import (
+	"context"
+	"errors"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	"github.com/dnwe/otelsarama"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewSyncProducer returns a mock sync producer to set expectations on and
+// the same producer instrumented with the options of r.
+func (r *Recorder) NewSyncProducer(t mocks.ErrorReporter, config *sarama.Config) (*mocks.SyncProducer, sarama.SyncProducer) {
+	mock := mocks.NewSyncProducer(t, config)
+	return mock, otelsarama.WrapSyncProducer(config, mock, r.Options()...)
+}
+
+// NewAsyncProducer returns a mock async producer to set expectations on and
+// the same producer instrumented with the options of r.
+func (r *Recorder) NewAsyncProducer(t mocks.ErrorReporter, config *sarama.Config) (*mocks.AsyncProducer, sarama.AsyncProducer) {
+	mock := mocks.NewAsyncProducer(t, config)
+	return mock, otelsarama.WrapAsyncProducer(config, mock, r.Options()...)
+}
+
+// NewConsumer returns a mock consumer to set expectations on and the same
+// consumer instrumented with the options of r, whose messages are handed over
+// by the instrumentation.
+func (r *Recorder) NewConsumer(t mocks.ErrorReporter, config *sarama.Config) (*mocks.Consumer, sarama.Consumer) {
+	mock := mocks.NewConsumer(t, config)
+	return mock, otelsarama.WrapConsumer(mock, r.Options()...)
+}
+
+// WithTraceContext returns a message checker for producer mock expectations
+// that requires the message to carry a valid trace context and then calls
+// checker, if not nil, with the trace context headers removed. This keeps
+// checkers asserting on the exact headers of messages passing after adopting
+// the instrumentation.
+func WithTraceContext(checker mocks.MessageChecker) mocks.MessageChecker {
+	return func(msg *sarama.ProducerMessage) error {
+		ctx := propagation.TraceContext{}.Extract(context.Background(), otelsarama.NewProducerMessageCarrier(msg))
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return errors.New("otelsaramatest: message carries no valid trace context")
+		}
+		if checker == nil {
+			return nil
+		}
+		return WithoutTraceContext(checker)(msg)
+	}
+}
+
+// WithoutTraceContext returns a message checker for producer mock
+// expectations that calls checker with the trace context headers of the
+// message removed.
+func WithoutTraceContext(checker mocks.MessageChecker) mocks.MessageChecker {
+	return func(msg *sarama.ProducerMessage) error {
+		stripped := *msg
+		stripped.Headers = nil
+		for _, h := range msg.Headers {
+			if !isTraceContextHeader(string(h.Key)) {
+				stripped.Headers = append(stripped.Headers, h)
+			}
+		}
+		return checker(&stripped)
+	}
+}
+
+// isTraceContextHeader reports whether key is a header the propagator of
+// Recorder.Options propagates trace context in.
+func isTraceContextHeader(key string) bool {
+	for _, f := range (propagation.TraceContext{}).Fields() {
+		if key == f {
+			return true
+		}
+	}
+	return false
+}
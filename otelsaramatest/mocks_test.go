@@ -0,0 +1,77 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsaramatest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestRecorderNewSyncProducer(t *testing.T) {
+	rec := NewRecorder()
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mock, producer := rec.NewSyncProducer(t, config)
+
+	var headers []sarama.RecordHeader
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(WithTraceContext(func(msg *sarama.ProducerMessage) error {
+		headers = msg.Headers
+		return nil
+	}))
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Headers: []sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	assert.Equal(t, []sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}}, headers)
+	rec.RequireSpanWithAttributes(t, topic+" publish", semconv.MessagingDestinationName(topic))
+}
+
+func TestRecorderNewConsumer(t *testing.T) {
+	rec := NewRecorder()
+	mock, consumer := rec.NewConsumer(t, nil)
+	mock.ExpectConsumePartition(topic, 0, 0).YieldMessage(&sarama.ConsumerMessage{Value: []byte("foo")})
+
+	pc, err := consumer.ConsumePartition(topic, 0, 0)
+	require.NoError(t, err)
+	msg := <-pc.Messages()
+	require.NoError(t, consumer.Close())
+
+	assert.Equal(t, []byte("foo"), msg.Value)
+	rec.RequireSpanWithAttributes(t, topic+" receive", semconv.MessagingDestinationName(topic))
+}
+
+func TestWithTraceContext(t *testing.T) {
+	errCheck := errors.New("check failed")
+	checker := WithTraceContext(func(*sarama.ProducerMessage) error { return errCheck })
+
+	assert.Error(t, checker(&sarama.ProducerMessage{Topic: topic}))
+
+	rec := NewRecorder()
+	ctx, span := rec.TracerProvider.Tracer("test").Start(context.Background(), "parent")
+	span.End()
+	msg := NewProducerMessage(ctx, topic, nil, nil)
+	assert.ErrorIs(t, checker(msg), errCheck)
+	assert.NoError(t, WithTraceContext(nil)(msg))
+}
@@ -0,0 +1,176 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsaramatest
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"github.com/dnwe/otelsarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestingT is the subset of testing.TB used by the Require methods.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+	FailNow()
+}
+
+// Recorder records the spans and metrics of the instrumentation in memory.
+type Recorder struct {
+	TracerProvider *sdktrace.TracerProvider
+	MeterProvider  *sdkmetric.MeterProvider
+
+	spans  *tracetest.SpanRecorder
+	reader *sdkmetric.ManualReader
+}
+
+// NewRecorder returns a Recorder with empty in-memory tracer and meter
+// providers.
+func NewRecorder() *Recorder {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	return &Recorder{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		spans:          spans,
+		reader:         reader,
+	}
+}
+
+// Options returns the options instrumenting sarama with the providers of r
+// and the W3C trace context propagator the message builders of this package
+// use.
+func (r *Recorder) Options() []otelsarama.Option {
+	return []otelsarama.Option{
+		otelsarama.WithTracerProvider(r.TracerProvider),
+		otelsarama.WithMeterProvider(r.MeterProvider),
+		otelsarama.WithPropagators(propagation.TraceContext{}),
+	}
+}
+
+// Spans returns the spans ended so far.
+func (r *Recorder) Spans() []sdktrace.ReadOnlySpan {
+	return r.spans.Ended()
+}
+
+// Metrics collects the metrics recorded so far.
+func (r *Recorder) Metrics(ctx context.Context) (metricdata.ResourceMetrics, error) {
+	var rm metricdata.ResourceMetrics
+	err := r.reader.Collect(ctx, &rm)
+	return rm, err
+}
+
+// RequireSpanWithAttributes returns the first ended span named name that has
+// all attrs. It fails t immediately if there is no such span.
+func (r *Recorder) RequireSpanWithAttributes(t TestingT, name string, attrs ...attribute.KeyValue) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range r.Spans() {
+		if span.Name() == name && hasAttributes(attribute.NewSet(span.Attributes()...), attrs) {
+			return span
+		}
+	}
+	t.Errorf("otelsaramatest: no span %q with attributes %v", name, attrs)
+	t.FailNow()
+	return nil
+}
+
+// RequireMetricSum requires the counter or up-down counter named name to sum
+// up to want over all data points that have all attrs. It fails t
+// immediately otherwise.
+func (r *Recorder) RequireMetricSum(t TestingT, name string, want float64, attrs ...attribute.KeyValue) {
+	t.Helper()
+	rm, err := r.Metrics(context.Background())
+	if err != nil {
+		t.Errorf("otelsaramatest: collecting metrics: %v", err)
+		t.FailNow()
+		return
+	}
+
+	var (
+		got   float64
+		found bool
+	)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					if hasAttributes(dp.Attributes, attrs) {
+						got, found = got+float64(dp.Value), true
+					}
+				}
+			case metricdata.Sum[float64]:
+				for _, dp := range data.DataPoints {
+					if hasAttributes(dp.Attributes, attrs) {
+						got, found = got+dp.Value, true
+					}
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("otelsaramatest: no data points of metric %q with attributes %v", name, attrs)
+		t.FailNow()
+		return
+	}
+	if got != want {
+		t.Errorf("otelsaramatest: metric %q sums up to %v, want %v", name, got, want)
+		t.FailNow()
+	}
+}
+
+// hasAttributes reports whether set contains all attrs.
+func hasAttributes(set attribute.Set, attrs []attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if v, ok := set.Value(attr.Key); !ok || v != attr.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// NewConsumerMessage returns a message consumed from partition 0 of topic
+// whose headers carry the trace context of ctx.
+func NewConsumerMessage(ctx context.Context, topic string, key, value []byte) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Topic: topic, Key: key, Value: value}
+	propagation.TraceContext{}.Inject(ctx, otelsarama.NewConsumerMessageCarrier(msg))
+	return msg
+}
+
+// NewProducerMessage returns a message to produce to topic whose headers
+// carry the trace context of ctx.
+func NewProducerMessage(ctx context.Context, topic string, key, value []byte) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{Topic: topic}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	otelsarama.InjectContext(ctx, msg, otelsarama.WithPropagators(propagation.TraceContext{}))
+	return msg
+}
@@ -0,0 +1,120 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsaramatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dnwe/otelsarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const topic = "test-topic"
+
+func TestRecorderConsumer(t *testing.T) {
+	rec := NewRecorder()
+	ctx, parent := rec.TracerProvider.Tracer("test").Start(context.Background(), "parent")
+	parent.End()
+
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 0, 0)
+	pc, err := consumer.ConsumePartition(topic, 0, 0)
+	require.NoError(t, err)
+	pc = otelsarama.WrapPartitionConsumer(pc, rec.Options()...)
+
+	mockPartitionConsumer.YieldMessage(NewConsumerMessage(ctx, topic, []byte("foo"), []byte("barbaz")))
+	<-pc.Messages()
+	require.NoError(t, pc.Close())
+
+	span := rec.RequireSpanWithAttributes(t, topic+" receive",
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingOperationReceive,
+	)
+	assert.Equal(t, parent.SpanContext().TraceID(), span.Parent().TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	rec.RequireMetricSum(t, "messaging.kafka.consumed.bytes", 9, semconv.MessagingDestinationName(topic))
+}
+
+func TestNewProducerMessage(t *testing.T) {
+	rec := NewRecorder()
+	ctx, span := rec.TracerProvider.Tracer("test").Start(context.Background(), "parent")
+	span.End()
+
+	msg := NewProducerMessage(ctx, topic, nil, []byte("foo"))
+
+	assert.Nil(t, msg.Key)
+	assert.Equal(t, sarama.ByteEncoder("foo"), msg.Value)
+	require.Len(t, msg.Headers, 1)
+	assert.Equal(t, "traceparent", string(msg.Headers[0].Key))
+	assert.Contains(t, string(msg.Headers[0].Value), span.SpanContext().TraceID().String())
+}
+
+// fakeT records failures of the Require methods.
+type fakeT struct {
+	errors []string
+	failed bool
+}
+
+func (*fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) FailNow() {
+	t.failed = true
+}
+
+func TestRequireFailures(t *testing.T) {
+	rec := NewRecorder()
+	counter, err := rec.MeterProvider.Meter("test").Int64Counter("test.counter")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 2)
+
+	for name, require := range map[string]func(*fakeT){
+		"missing span": func(ft *fakeT) {
+			rec.RequireSpanWithAttributes(ft, "missing")
+		},
+		"missing metric": func(ft *fakeT) {
+			rec.RequireMetricSum(ft, "missing", 1)
+		},
+		"mismatching attributes": func(ft *fakeT) {
+			rec.RequireMetricSum(ft, "test.counter", 2, attribute.String("foo", "bar"))
+		},
+		"mismatching sum": func(ft *fakeT) {
+			rec.RequireMetricSum(ft, "test.counter", 1)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ft := &fakeT{}
+			require(ft)
+			assert.True(t, ft.failed)
+			assert.Len(t, ft.errors, 1)
+		})
+	}
+
+	ft := &fakeT{}
+	rec.RequireMetricSum(ft, "test.counter", 2)
+	assert.False(t, ft.failed)
+}
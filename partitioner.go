@@ -0,0 +1,104 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type partitioner struct {
+	sarama.Partitioner
+	cfg config
+
+	selections metric.Int64Counter
+}
+
+var _ sarama.DynamicConsistencyPartitioner = (*partitioner)(nil)
+
+// WrapPartitioner wraps a sarama.Partitioner so that the partitions it
+// chooses are counted per topic and recorded on the publish spans of the
+// messages.
+func WrapPartitioner(p sarama.Partitioner, opts ...Option) sarama.Partitioner {
+	if wrapped, ok := p.(*partitioner); ok {
+		return wrapped
+	}
+	cfg := newConfig(opts...)
+	return &partitioner{
+		Partitioner: p,
+		cfg:         cfg,
+		selections: cfg.int64Counter(
+			"messaging.kafka.producer.partition_selections",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of messages the partitioner assigned to partitions."),
+		),
+	}
+}
+
+// WrapPartitionerConstructor wraps a sarama.PartitionerConstructor so that
+// the partitioners it constructs are wrapped with WrapPartitioner.
+func WrapPartitionerConstructor(constructor sarama.PartitionerConstructor, opts ...Option) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return WrapPartitioner(constructor(topic), opts...)
+	}
+}
+
+// InstrumentPartitioner wraps the partitioner configured in saramaConfig
+// with WrapPartitionerConstructor. It must be called before the producer is
+// created from saramaConfig.
+func InstrumentPartitioner(saramaConfig *sarama.Config, opts ...Option) {
+	constructor := saramaConfig.Producer.Partitioner
+	if constructor == nil {
+		constructor = sarama.NewHashPartitioner
+	}
+	saramaConfig.Producer.Partitioner = WrapPartitionerConstructor(constructor, opts...)
+}
+
+// Partition calls sarama.Partitioner.Partition and records the chosen
+// partition.
+func (p *partitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	partition, err := p.Partitioner.Partition(msg, numPartitions)
+	if err != nil || !p.cfg.enabled() {
+		return partition, err
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		p.cfg.destinationMetricAttribute(msg.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(partition)),
+	}
+	attrs = append(attrs, p.cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, p.cfg.Attributes...)
+	p.selections.Add(context.Background(), 1, p.cfg.withMetricAttributes(attrs...))
+	if span, ok := publishSpans.Load(msg); ok {
+		span.(trace.Span).SetAttributes(semconv.MessagingKafkaDestinationPartition(int(partition)))
+	}
+	return partition, nil
+}
+
+// MessageRequiresConsistency implements sarama.DynamicConsistencyPartitioner
+// by delegating to the wrapped partitioner, if it implements it.
+func (p *partitioner) MessageRequiresConsistency(msg *sarama.ProducerMessage) bool {
+	if dp, ok := p.Partitioner.(sarama.DynamicConsistencyPartitioner); ok {
+		return dp.MessageRequiresConsistency(msg)
+	}
+	return p.Partitioner.RequiresConsistency()
+}
@@ -0,0 +1,76 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestWrapPartitioner(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	cfg := newConfig(WithTracerProvider(sr))
+	msg := &sarama.ProducerMessage{Topic: topic, Partition: 2}
+	span := startProducerSpan(cfg, newSaramaConfig(), msg)
+
+	p := WrapPartitioner(sarama.NewManualPartitioner(topic), WithMeterProvider(mr))
+	partition, err := p.Partition(msg, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), partition)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, attribute.IntValue(2), spans[0].Attributes()[semconv.MessagingKafkaDestinationPartitionKey])
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaDestinationPartition(2),
+	)}}, mr.Measurements("messaging.kafka.producer.partition_selections"))
+
+	finishProducerSpan(cfg, span, msg, partition, 0, nil)
+	_, ok := publishSpans.Load(msg)
+	assert.False(t, ok)
+}
+
+func TestWrapPartitionerConsistency(t *testing.T) {
+	msg := &sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder("foo")}
+
+	p := WrapPartitioner(sarama.NewHashPartitioner(topic)).(sarama.DynamicConsistencyPartitioner)
+	assert.True(t, p.RequiresConsistency())
+	assert.True(t, p.MessageRequiresConsistency(msg))
+
+	p = WrapPartitioner(sarama.NewRandomPartitioner(topic)).(sarama.DynamicConsistencyPartitioner)
+	assert.False(t, p.MessageRequiresConsistency(msg))
+}
+
+func TestInstrumentPartitioner(t *testing.T) {
+	mr := newMetricRecorder()
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Partitioner = sarama.NewManualPartitioner
+
+	InstrumentPartitioner(saramaConfig, WithMeterProvider(mr))
+
+	p := saramaConfig.Producer.Partitioner(topic)
+	_, err := p.Partition(&sarama.ProducerMessage{Topic: topic, Partition: 1}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), mr.Sum("messaging.kafka.producer.partition_selections"))
+}
@@ -0,0 +1,211 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"sort"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	partitionsKey = attribute.Key("messaging.kafka.partitions")
+	// pauseReasonKey is the attribute key for the reason partitions were
+	// paused for, as passed to PauseWithReason.
+	pauseReasonKey = attribute.Key("messaging.kafka.pause.reason")
+)
+
+// claimTrackingHandler tells a consumerGroup the partitions claimed in its
+// sessions.
+type claimTrackingHandler struct {
+	sarama.ConsumerGroupHandler
+
+	cg *consumerGroup
+}
+
+func (h *claimTrackingHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.cg.setClaims(session.Claims())
+	trace.SpanFromContext(session.Context()).SetAttributes(
+		consumerGroupGenerationIDKey.Int64(int64(session.GenerationID())),
+		consumerGroupMemberIDKey.String(session.MemberID()),
+		assignedPartitionsAttribute(session.Claims()),
+	)
+	return h.ConsumerGroupHandler.Setup(session)
+}
+
+func (h *claimTrackingHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	err := h.ConsumerGroupHandler.Cleanup(session)
+	h.cg.setClaims(nil)
+	return err
+}
+
+// setClaims sets the partitions claimed in a new session, in which no
+// partition is paused yet.
+func (c *consumerGroup) setClaims(claims map[string][]int32) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.claims = claims
+	c.paused = make(map[topicPartition]struct{})
+}
+
+// Pause invokes ConsumerGroup.Pause and records the partitions as paused.
+func (c *consumerGroup) Pause(partitions map[string][]int32) {
+	c.ConsumerGroup.Pause(partitions)
+	c.setPaused("pause", "", partitions)
+}
+
+// Resume invokes ConsumerGroup.Resume and records the partitions as resumed.
+func (c *consumerGroup) Resume(partitions map[string][]int32) {
+	c.ConsumerGroup.Resume(partitions)
+	c.setPaused("resume", "", partitions)
+}
+
+// PauseAll invokes ConsumerGroup.PauseAll and records all claimed partitions
+// as paused.
+func (c *consumerGroup) PauseAll() {
+	c.ConsumerGroup.PauseAll()
+	c.mtx.Lock()
+	claims := c.claims
+	c.mtx.Unlock()
+	c.setPaused("pause", "", claims)
+}
+
+// ResumeAll invokes ConsumerGroup.ResumeAll and records all claimed
+// partitions as resumed.
+func (c *consumerGroup) ResumeAll() {
+	c.ConsumerGroup.ResumeAll()
+	c.mtx.Lock()
+	claims := c.claims
+	c.mtx.Unlock()
+	c.setPaused("resume", "", claims)
+}
+
+// PauseWithReason pauses partitions of cg like ConsumerGroup.Pause, recording
+// why, e.g. "downstream_slow" or "rate_limit". If cg is wrapped with
+// WrapConsumerGroup, the reason is added to the pause events of the span of
+// the current Consume call and to the counter of pauses, so backpressure
+// decisions can be explained from telemetry.
+func PauseWithReason(cg sarama.ConsumerGroup, partitions map[string][]int32, reason string) {
+	c, ok := cg.(*consumerGroup)
+	if !ok {
+		cg.Pause(partitions)
+		return
+	}
+	c.ConsumerGroup.Pause(partitions)
+	c.setPaused("pause", reason, partitions)
+}
+
+// setPaused records partitions as paused or resumed, depending on operation,
+// and adds an event per topic to the span of the current Consume call. Pauses
+// are counted per topic, with reason if it is not empty.
+func (c *consumerGroup) setPaused(operation, reason string, partitions map[string][]int32) {
+	c.mtx.Lock()
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			tp := topicPartition{topic: topic, partition: p}
+			if operation == "pause" {
+				c.paused[tp] = struct{}{}
+			} else {
+				delete(c.paused, tp)
+			}
+		}
+	}
+	ctx := c.ctx
+	c.mtx.Unlock()
+
+	if operation == "pause" {
+		c.recordPauses(ctx, reason, partitions)
+	}
+	if ctx == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	topics := make([]string, 0, len(partitions))
+	for topic := range partitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		ps := make([]int64, len(partitions[topic]))
+		for i, p := range partitions[topic] {
+			ps[i] = int64(p)
+		}
+		attrs := []attribute.KeyValue{
+			semconv.MessagingDestinationName(topic),
+			partitionsKey.Int64Slice(ps),
+		}
+		if reason != "" {
+			attrs = append(attrs, pauseReasonKey.String(reason))
+		}
+		span.AddEvent(operation, trace.WithAttributes(attrs...))
+	}
+}
+
+// recordPauses counts a pause of partitions per topic, with reason if it is
+// not empty.
+func (c *consumerGroup) recordPauses(ctx context.Context, reason string, partitions map[string][]int32) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for topic := range partitions {
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem("kafka"),
+			c.cfg.destinationMetricAttribute(topic),
+		}
+		if c.cfg.ConsumerGroupID != "" {
+			attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(c.cfg.ConsumerGroupID))
+		}
+		if reason != "" {
+			attrs = append(attrs, pauseReasonKey.String(reason))
+		}
+		attrs = append(attrs, c.cfg.topicAttributes(topic)...)
+		attrs = append(attrs, c.cfg.Attributes...)
+		c.pauses.Add(ctx, 1, c.cfg.withMetricAttributes(attrs...))
+	}
+}
+
+// observePausedPartitions reports the number of partitions paused per topic
+// in the current session.
+func (c *consumerGroup) observePausedPartitions(_ context.Context, o metric.Int64Observer) error {
+	c.mtx.Lock()
+	paused := make(map[string]int64, len(c.claims))
+	for topic := range c.claims {
+		paused[topic] = 0
+	}
+	for tp := range c.paused {
+		paused[tp.topic]++
+	}
+	c.mtx.Unlock()
+
+	for topic, n := range paused {
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem("kafka"),
+			c.cfg.destinationMetricAttribute(topic),
+		}
+		if c.cfg.ConsumerGroupID != "" {
+			attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(c.cfg.ConsumerGroupID))
+		}
+		attrs = append(attrs, c.cfg.topicAttributes(topic)...)
+		attrs = append(attrs, c.cfg.Attributes...)
+		o.Observe(n, c.cfg.withMetricAttributes(attrs...))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// fakeSessionConsumerGroup runs a single session with session as long as
+// consume runs.
+type fakeSessionConsumerGroup struct {
+	fakeConsumerGroup
+
+	session *fakeConsumerGroupSession
+	consume func()
+}
+
+func (c *fakeSessionConsumerGroup) Consume(ctx context.Context, _ []string, handler sarama.ConsumerGroupHandler) error {
+	c.session.ctx = ctx
+	if err := handler.Setup(c.session); err != nil {
+		return err
+	}
+	c.consume()
+	return handler.Cleanup(c.session)
+}
+
+func (c *fakeSessionConsumerGroup) Close() error              { return nil }
+func (c *fakeSessionConsumerGroup) Pause(map[string][]int32)  {}
+func (c *fakeSessionConsumerGroup) Resume(map[string][]int32) {}
+func (c *fakeSessionConsumerGroup) PauseAll()                 {}
+func (c *fakeSessionConsumerGroup) ResumeAll()                {}
+
+func TestWrapConsumerGroupPausedPartitions(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	errs := make(chan error)
+	close(errs)
+	fake := &fakeSessionConsumerGroup{
+		fakeConsumerGroup: fakeConsumerGroup{errors: errs},
+		session:           &fakeConsumerGroupSession{claims: map[string][]int32{topic: {0, 1, 2}}},
+	}
+	cg := WrapConsumerGroup(fake, WithTracerProvider(sr), WithMeterProvider(mr))
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+	)
+
+	var observed []float64
+	fake.consume = func() {
+		observe := func() {
+			measurements := mr.Collect("messaging.kafka.consumer.paused_partitions")
+			require.Len(t, measurements, 1)
+			assert.Equal(t, wantAttrs, measurements[0].attrs)
+			observed = append(observed, measurements[0].value)
+		}
+		observe()
+		cg.Pause(map[string][]int32{topic: {1}})
+		observe()
+		cg.PauseAll()
+		observe()
+		cg.Resume(map[string][]int32{topic: {0, 2}})
+		observe()
+		cg.ResumeAll()
+		observe()
+	}
+	require.NoError(t, cg.Consume(context.Background(), []string{topic}, fakeConsumerGroupHandler{}))
+
+	assert.Equal(t, []float64{0, 1, 3, 1, 0}, observed)
+	assert.Empty(t, mr.Collect("messaging.kafka.consumer.paused_partitions"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	events := spans[0].Events()
+	require.Len(t, events, 4)
+	for i, name := range []string{"pause", "pause", "resume", "resume"} {
+		assert.Equal(t, name, events[i].name)
+		assert.Contains(t, events[i].attrs, semconv.MessagingDestinationName(topic))
+	}
+	assert.Contains(t, events[0].attrs, partitionsKey.Int64Slice([]int64{1}))
+	assert.Contains(t, events[2].attrs, partitionsKey.Int64Slice([]int64{0, 2}))
+
+	assert.Equal(t, 1, mr.Registered())
+	require.NoError(t, cg.Close())
+	assert.Zero(t, mr.Registered())
+}
+
+func TestPauseWithReason(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	errs := make(chan error)
+	close(errs)
+	fake := &fakeSessionConsumerGroup{
+		fakeConsumerGroup: fakeConsumerGroup{errors: errs},
+		session:           &fakeConsumerGroupSession{claims: map[string][]int32{topic: {0, 1, 2}}},
+	}
+	cg := WrapConsumerGroup(fake, WithTracerProvider(sr), WithMeterProvider(mr))
+	fake.consume = func() {
+		PauseWithReason(cg, map[string][]int32{topic: {1}}, "downstream_slow")
+		cg.Pause(map[string][]int32{topic: {2}})
+	}
+	require.NoError(t, cg.Consume(context.Background(), []string{topic}, fakeConsumerGroupHandler{}))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	events := spans[0].Events()
+	require.Len(t, events, 2)
+	assert.Contains(t, events[0].attrs, pauseReasonKey.String("downstream_slow"))
+	assert.NotContains(t, events[1].attrs, pauseReasonKey.String("downstream_slow"))
+
+	assert.Equal(t, []measurement{
+		{value: 1, attrs: attribute.NewSet(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(topic),
+			pauseReasonKey.String("downstream_slow"),
+		)},
+		{value: 1, attrs: attribute.NewSet(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(topic),
+		)},
+	}, mr.Measurements("messaging.kafka.consumer.pauses"))
+}
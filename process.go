@@ -0,0 +1,398 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler processes a consumed message.
+type Handler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+// Middleware wraps a Handler, e.g. to add logging, retries or authorization
+// to processing messages.
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares into a single Middleware. The first middleware
+// is the outermost one, i.e. it sees messages first and errors last.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(handler Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+		return handler
+	}
+}
+
+// InstrumentMiddleware returns a Middleware instrumenting handlers with
+// Instrument and opts.
+func InstrumentMiddleware(opts ...Option) Middleware {
+	return func(handler Handler) Handler {
+		return Instrument(handler, opts...)
+	}
+}
+
+// processOutcomeKey is the attribute key for the outcome of processing a
+// message, "success" or "error".
+const processOutcomeKey = attribute.Key("messaging.process.outcome")
+
+// processOperationKey is the context key of the processOperation of the
+// message an instrumented handler processes.
+type processOperationKey struct{}
+
+// processInstrumenter holds the instruments of a handler wrapped by
+// Instrument.
+type processInstrumenter struct {
+	cfg               config
+	processDuration   metric.Float64Histogram
+	processedMessages metric.Int64Counter
+	inflight          metric.Int64UpDownCounter
+	timeouts          metric.Int64Counter
+	links             *previousMessageLinks
+	redeliveries      *redeliveries
+	extractFailures   metric.Int64Counter
+}
+
+func newProcessInstrumenter(cfg config) *processInstrumenter {
+	return &processInstrumenter{
+		cfg: cfg,
+		processDuration: cfg.durationHistogram(
+			"messaging.process.duration",
+			"Duration of processing operations.",
+		),
+		processedMessages: cfg.int64Counter(
+			"messaging.client.processed.messages",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of messages processed, by outcome."),
+		),
+		inflight: cfg.int64UpDownCounter(
+			"messaging.client.process.inflight",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of messages currently processed."),
+		),
+		timeouts: cfg.int64Counter(
+			"messaging.client.process.timeouts",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of messages processed longer than the configured timeout warning."),
+		),
+		links:           newPreviousMessageLinks(cfg),
+		redeliveries:    newRedeliveries(cfg),
+		extractFailures: newExtractFailuresCounter(cfg),
+	}
+}
+
+// processOperation is the processing of a message by an instrumented
+// handler. It is stopped exactly once, however often stop is called.
+type processOperation struct {
+	instrumenter *processInstrumenter
+	msg          *sarama.ConsumerMessage
+	span         trace.Span
+	start        time.Time
+
+	// mtx guards attrs, the attributes added with SetProcessAttributes.
+	mtx   sync.Mutex
+	attrs []attribute.KeyValue
+
+	stopOnce sync.Once
+	stopped  atomic.Bool
+	// stopWatchdog stops the timer firing if the operation is not stopped
+	// in time, see WithProcessTimeoutWarning.
+	stopWatchdog func() bool
+}
+
+// start starts the processing of msg in a process span.
+func (p *processInstrumenter) start(ctx context.Context, msg *sarama.ConsumerMessage) (context.Context, *processOperation) {
+	op := &processOperation{instrumenter: p, msg: msg, start: p.cfg.now()}
+	ctx = context.WithValue(ctx, processOperationKey{}, op)
+	p.inflight.Add(ctx, 1, p.inflightAttributes(msg))
+	ctx, op.span = startProcessSpan(ctx, p.cfg, p.links, p.extractFailures, msg)
+	if attrs := p.redeliveries.record(ctx, msg); len(attrs) > 0 {
+		op.span.SetAttributes(attrs...)
+	}
+	if timeout := p.cfg.ProcessTimeoutWarning; timeout > 0 {
+		op.stopWatchdog = p.cfg.startTimer(timeout, func() { op.timedOut(ctx) })
+	}
+	return ctx, op
+}
+
+// mark marks the message of the operation as processed in the session of
+// ctx, if any, and commits the session with WithMarkOnSuccess(true). Both are
+// recorded as events on the process span.
+func (op *processOperation) mark(ctx context.Context) {
+	markProcessed(ctx, op.instrumenter.cfg, op.msg, op.span)
+}
+
+// markProcessed marks msg as processed in the session of ctx, if any, and
+// commits the session with WithMarkOnSuccess(true). Both are recorded as
+// events on span.
+func markProcessed(ctx context.Context, cfg config, msg *sarama.ConsumerMessage, span trace.Span) {
+	session, ok := ctx.Value(consumerGroupSessionKey{}).(sarama.ConsumerGroupSession)
+	if !ok {
+		return
+	}
+	session.MarkMessage(msg, "")
+	span.AddEvent("message.marked", trace.WithAttributes(
+		semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+	))
+	if cfg.CommitOnSuccess {
+		session.Commit()
+		span.AddEvent("offsets.committed")
+	}
+}
+
+// timedOut reports that the operation was not stopped within the timeout
+// configured with WithProcessTimeoutWarning.
+func (op *processOperation) timedOut(ctx context.Context) {
+	if op.stopped.Load() {
+		return
+	}
+	cfg, msg := op.instrumenter.cfg, op.msg
+	op.span.AddEvent("process.timeout")
+	cfg.log(ctx, slog.LevelWarn, "otelsarama: processing message exceeds timeout",
+		slog.String("topic", msg.Topic),
+		slog.Int("partition", int(msg.Partition)),
+		slog.Int64("offset", msg.Offset),
+		slog.Duration("timeout", cfg.ProcessTimeoutWarning),
+	)
+	op.instrumenter.timeouts.Add(ctx, 1, op.instrumenter.inflightAttributes(msg))
+}
+
+// stop ends the operation with the error returned by the handler, if any.
+// Only the first call has an effect.
+func (op *processOperation) stop(ctx context.Context, err error) {
+	op.stopOnce.Do(func() {
+		op.stopped.Store(true)
+		if op.stopWatchdog != nil {
+			op.stopWatchdog()
+		}
+		op.end(ctx, err)
+	})
+}
+
+// inflightAttributes returns the attributes messages processed from the
+// topic of msg are counted with.
+func (p *processInstrumenter) inflightAttributes(msg *sarama.ConsumerMessage) metric.MeasurementOption {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		p.cfg.destinationMetricAttribute(msg.Topic),
+	}
+	if p.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(p.cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, p.cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, p.cfg.Attributes...)
+	return p.cfg.withMetricAttributes(attrs...)
+}
+
+func (op *processOperation) end(ctx context.Context, err error) {
+	cfg, msg, span := op.instrumenter.cfg, op.msg, op.span
+	op.instrumenter.inflight.Add(ctx, -1, op.instrumenter.inflightAttributes(msg))
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		cfg.destinationMetricAttribute(msg.Topic),
+		semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+	}
+	if cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, cfg.topicAttributes(msg.Topic)...)
+	if err != nil {
+		errType := errorTypeKey.String(cfg.errorType(err))
+		attrs = append(attrs, errType)
+		span.SetAttributes(errType)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	attrs = append(attrs, cfg.Attributes...)
+	attrs = append(attrs, cfg.MetricAttributeExtractor.extract(msg)...)
+	op.mtx.Lock()
+	durationAttrs := append(attrs[:len(attrs):len(attrs)], op.attrs...)
+	op.mtx.Unlock()
+	op.instrumenter.processDuration.Record(ctx, cfg.since(op.start).Seconds(), cfg.withMetricAttributes(durationAttrs...))
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	op.instrumenter.processedMessages.Add(ctx, 1, cfg.withMetricAttributes(append(attrs, processOutcomeKey.String(outcome))...))
+	span.End()
+}
+
+// Instrument wraps handler so that processing messages is traced and
+// measured. Each message is processed in a process span, created as child
+// of the span context propagated in the message, e.g. the context of its
+// receive span. Process spans of messages handed over by wrapped consumers
+// are linked to their receive spans, which connects them even if the
+// context cannot be extracted from the message, e.g. with different
+// propagators or carrier options. Errors returned by handler are recorded on
+// the span, the messaging.process.duration histogram and the
+// messaging.client.processed.messages counter. Panics are recorded the same
+// way, with error.type "panic", and are then re-raised unless
+// WithPanicRecovery is set. The messages currently processed are counted per
+// topic in messaging.client.process.inflight.
+//
+// The returned handler can be called from within ConsumeClaim:
+//
+//	process := otelsarama.Instrument(handle, otelsarama.WithConsumerGroupID(group))
+//	for msg := range claim.Messages() {
+//		if err := process(session.Context(), msg); err != nil {
+//			return err
+//		}
+//		session.MarkMessage(msg, "")
+//	}
+func Instrument(handler Handler, opts ...Option) Handler {
+	cfg := newConfig(opts...)
+	instrumenter := newProcessInstrumenter(cfg)
+
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) (err error) {
+		if op, ok := ctx.Value(processOperationKey{}).(*processOperation); !cfg.enabled() || ok && op.msg == msg {
+			// Instrumentation is disabled or msg is processed by an outer
+			// instrumented handler already. Marking is not telemetry, so
+			// messages are marked nonetheless.
+			err = handler(ctx, msg)
+			if err == nil && cfg.MarkOnSuccess {
+				markProcessed(ctx, cfg, msg, trace.SpanFromContext(ctx))
+			}
+			return err
+		}
+
+		ctx, op := instrumenter.start(ctx, msg)
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r}
+				op.stop(ctx, err)
+				if !cfg.RecoverPanics {
+					panic(r)
+				}
+			}
+		}()
+		err = handler(ctx, msg)
+		if err == nil && cfg.MarkOnSuccess {
+			op.mark(ctx)
+		}
+		op.stop(ctx, err)
+		return err
+	}
+}
+
+// AddProcessEvent adds an event to the process span of the message ctx is
+// processed for by a handler wrapped by Instrument, e.g. to mark stages like
+// "validated" or "persisted". For other contexts, the event is added to the
+// span of ctx.
+func AddProcessEvent(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	if op, ok := ctx.Value(processOperationKey{}).(*processOperation); ok {
+		span = op.span
+	}
+	span.AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// SetProcessAttributes sets attributes on the process span of the message ctx
+// is processed for by a handler wrapped by Instrument. The attributes are
+// also recorded on the messaging.process.duration histogram, subject to the
+// configured metric attribute filter, so they must be of low cardinality.
+// For other contexts, the attributes are set on the span of ctx.
+func SetProcessAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	op, ok := ctx.Value(processOperationKey{}).(*processOperation)
+	if !ok {
+		trace.SpanFromContext(ctx).SetAttributes(attrs...)
+		return
+	}
+	op.span.SetAttributes(attrs...)
+	op.mtx.Lock()
+	op.attrs = append(op.attrs, attrs...)
+	op.mtx.Unlock()
+}
+
+// PanicError is the error a handler panicking is recorded with by
+// Instrument, and returned with WithPanicRecovery.
+type PanicError struct {
+	// Value is the value the handler panicked with.
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("otelsarama: handler panicked: %v", e.Value)
+}
+
+// startProcessSpan starts the process span of msg as child of the span
+// context propagated in msg and records it in links. If tracing is disabled
+// or the span sampler rejects msg, ctx is left untouched and a non-recording
+// span is returned.
+func startProcessSpan(ctx context.Context, cfg config, links *previousMessageLinks, extractFailures metric.Int64Counter, msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	prioritized := cfg.prioritized(msg)
+	if cfg.TracesDisabled || !prioritized && !cfg.spanSampled(msg.Topic, msg.Partition) {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(msg.Topic),
+		semconv.MessagingOperationProcess,
+		semconv.MessagingMessageID(cfg.messageID(msg)),
+		semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+	}
+	attrs = append(attrs, cfg.operationAttributes("process", "process")...)
+	attrs = append(attrs, cfg.destinationTemplateAttributes(msg.Topic)...)
+	attrs = append(attrs, cfg.topicAttributes(msg.Topic)...)
+	if cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(cfg.ConsumerGroupID))
+	}
+	if msg.Value == nil {
+		attrs = append(attrs, semconv.MessagingKafkaMessageTombstone(true))
+	}
+	attrs = append(attrs, cfg.keyAttributes(msg.Key)...)
+	attrs = append(attrs, consumerGroupMemberAttributes(ctx)...)
+	attrs = append(attrs, cfg.Attributes...)
+	if cfg.PeerService != "" {
+		attrs = append(attrs, semconv.PeerService(cfg.PeerService))
+	}
+	attrs = append(attrs, headerAttributes(msg.Headers, cfg.HeaderAttributes)...)
+	attrs = append(attrs, cfg.conversationIDAttributes(msg.Headers)...)
+	parent := cfg.contextFromMessage(ctx, msg)
+	if prioritized {
+		attrs = append(attrs, samplingPriorityKey.Int(1))
+		parent = forceSampled(parent)
+	}
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(cfg.consumerSpanKind()),
+	}
+	opts = append(opts, links.startOptions(msg)...)
+	opts = append(opts, receiveSpanLinks(msg)...)
+	opts = append(opts, cfg.deadLetterStartOptions(msg)...)
+	if cfg.SpanStartHook != nil {
+		opts = append(opts, cfg.SpanStartHook(msg)...)
+	}
+	newCtx, span := cfg.Tracer.Start(parent, fmt.Sprintf("%s %s", msg.Topic, cfg.operationName("process")), opts...)
+	links.record(msg, span)
+	if reason := cfg.extractFailure(ctx, parent, NewConsumerMessageCarrier(msg, cfg.CarrierOptions...)); reason != "" {
+		cfg.recordExtractFailure(newCtx, extractFailures, semconv.MessagingOperationProcess, msg, reason)
+	}
+	return newCtx, span
+}
@@ -0,0 +1,602 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInstrument(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	opts := []Option{
+		WithTracerProvider(sr),
+		WithMeterProvider(mr),
+		WithPropagators(propagation.TraceContext{}),
+		WithConsumerGroupID("my-group"),
+	}
+	received := receiveMessage(t, &sarama.ConsumerMessage{Topic: topic, Partition: 1}, opts...)
+
+	var handlerSpan trace.Span
+	process := Instrument(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handlerSpan = trace.SpanFromContext(ctx)
+		return nil
+	}, opts...)
+	require.NoError(t, process(context.Background(), received))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	receive, processSpan := spans[0], spans[1]
+	assert.Equal(t, processSpan, handlerSpan)
+	assert.Equal(t, "test-topic process", processSpan.name)
+	assert.Equal(t, trace.SpanKindConsumer, processSpan.kind)
+	assert.Equal(t, receive.sc.SpanID(), processSpan.parent.SpanID())
+	assert.True(t, processSpan.Ended())
+
+	attrs := processSpan.Attributes()
+	assert.Equal(t, semconv.MessagingOperationProcess.Value, attrs[semconv.MessagingOperationKey])
+	assert.Equal(t, strconv.FormatInt(received.Offset, 10), attrs[semconv.MessagingMessageIDKey].AsString())
+	assert.Equal(t, "my-group", attrs[semconv.MessagingKafkaConsumerGroupKey].AsString())
+
+	require.Len(t, mr.Measurements("messaging.process.duration"), 1)
+}
+
+func TestInstrumentError(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return sarama.ErrMessageSizeTooLarge
+	}, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	err := process(context.Background(), &sarama.ConsumerMessage{Topic: topic})
+	assert.ErrorIs(t, err, sarama.ErrMessageSizeTooLarge)
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	assert.Equal(t, sarama.ErrMessageSizeTooLarge.Error(), spans[0].Attributes()[errorTypeKey].AsString())
+
+	measurements := mr.Measurements("messaging.process.duration")
+	require.Len(t, measurements, 1)
+	errType, ok := measurements[0].attrs.Value(errorTypeKey)
+	require.True(t, ok)
+	assert.Equal(t, sarama.ErrMessageSizeTooLarge.Error(), errType.AsString())
+}
+
+func TestInstrumentProcessedMessages(t *testing.T) {
+	mr := newMetricRecorder()
+	process := Instrument(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset > 0 {
+			return sarama.ErrMessageSizeTooLarge
+		}
+		return nil
+	}, WithMeterProvider(mr))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+	require.Error(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Offset: 1}))
+
+	measurements := mr.Measurements("messaging.client.processed.messages")
+	require.Len(t, measurements, 2)
+	outcome, _ := measurements[0].attrs.Value(processOutcomeKey)
+	assert.Equal(t, "success", outcome.AsString())
+	outcome, _ = measurements[1].attrs.Value(processOutcomeKey)
+	assert.Equal(t, "error", outcome.AsString())
+	assert.Equal(t, float64(2), mr.Sum("messaging.client.processed.messages"))
+}
+
+func TestInstrumentWithoutTraces(t *testing.T) {
+	sr := newSpanRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr), WithoutTraces())
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+	assert.Empty(t, sr.Spans())
+}
+
+func TestInstrumentWithSpanSampler(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr), WithMeterProvider(mr), WithSpanSampler(func(topic string, _ int32) bool {
+		return topic != "firehose"
+	}))
+
+	ctx, parent := sr.Start(context.Background(), "parent")
+	require.NoError(t, process(ctx, &sarama.ConsumerMessage{Topic: "firehose"}))
+	require.NoError(t, process(ctx, &sarama.ConsumerMessage{Topic: topic}))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "parent", spans[0].name)
+	assert.False(t, parent.(*recordedSpan).Ended())
+	assert.Equal(t, topic+" process", spans[1].name)
+	assert.Len(t, mr.Measurements("messaging.process.duration"), 2)
+}
+
+func TestInstrumentWithPriorityHeader(t *testing.T) {
+	sr := newSpanRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr), WithSpanSampler(func(string, int32) bool { return false }), WithPriorityHeader("x-trace-priority"))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Headers: []*sarama.RecordHeader{
+		{Key: []byte("X-Trace-Priority")},
+	}}))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, topic+" process", spans[0].name)
+	assert.Equal(t, attribute.IntValue(1), spans[0].Attributes()[samplingPriorityKey])
+}
+
+func TestInstrumentWithDeliveryAttemptHeader(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr), WithMeterProvider(mr), WithDeliveryAttemptHeader("x-delivery-attempt"))
+
+	attempt := func(v string) []*sarama.RecordHeader {
+		return []*sarama.RecordHeader{{Key: []byte("x-delivery-attempt"), Value: []byte(v)}}
+	}
+	for _, msg := range []*sarama.ConsumerMessage{
+		{Topic: topic, Offset: 1, Headers: attempt("1")},
+		{Topic: topic, Offset: 2, Headers: []*sarama.RecordHeader{{Key: []byte("X-Delivery-Attempt"), Value: []byte("3")}}},
+		{Topic: topic, Offset: 3},
+		// Processed again after an offset reset.
+		{Topic: topic, Offset: 2},
+	} {
+		require.NoError(t, process(context.Background(), msg))
+	}
+
+	spans := sr.Spans()
+	require.Len(t, spans, 4)
+	assert.Equal(t, attribute.Int64Value(1), spans[0].Attributes()[deliveryAttemptKey])
+	assert.Equal(t, attribute.Int64Value(3), spans[1].Attributes()[deliveryAttemptKey])
+	assert.NotContains(t, spans[2].Attributes(), deliveryAttemptKey)
+	assert.NotContains(t, spans[3].Attributes(), deliveryAttemptKey)
+	assert.Equal(t, []measurement{
+		{value: 1, attrs: attribute.NewSet(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingKafkaSourcePartition(0),
+		)},
+		{value: 1, attrs: attribute.NewSet(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingKafkaSourcePartition(0),
+		)},
+	}, mr.Measurements("messaging.kafka.consumer.redelivered_messages"))
+}
+
+func TestInstrumentWithPreviousMessageLinks(t *testing.T) {
+	sr := newSpanRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr), WithPreviousMessageLinks())
+
+	for _, partition := range []int32{0, 1, 0} {
+		require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Partition: partition}))
+	}
+
+	spans := sr.Spans()
+	require.Len(t, spans, 3)
+	assert.Empty(t, spans[0].links)
+	assert.Empty(t, spans[1].links)
+	require.Len(t, spans[2].links, 1)
+	assert.Equal(t, spans[0].sc, spans[2].links[0].SpanContext)
+}
+
+func TestInstrumentLinksReceiveSpan(t *testing.T) {
+	sr := newSpanRecorder()
+	consumer := mocks.NewConsumer(t, sarama.NewConfig())
+	mockPartitionConsumer := consumer.ExpectConsumePartition(topic, 1, 0)
+	pc, err := consumer.ConsumePartition(topic, 1, 0)
+	require.NoError(t, err)
+	pc = WrapPartitionConsumer(pc, WithTracerProvider(sr), WithPropagators(propagation.TraceContext{}))
+
+	// The handler extracts nothing, so its span is not a child of the
+	// receive span.
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil },
+		WithTracerProvider(sr), WithPropagators(propagation.NewCompositeTextMapPropagator()))
+
+	mockPartitionConsumer.YieldMessage(&sarama.ConsumerMessage{Topic: topic, Partition: 1})
+	msg := <-pc.Messages()
+	require.NoError(t, process(context.Background(), msg))
+	require.NoError(t, pc.Close())
+	for range pc.Messages() {
+	}
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	receive, processed := spans[0], spans[1]
+	assert.Equal(t, topic+" receive", receive.name)
+	assert.False(t, processed.parent.IsValid())
+	require.Len(t, processed.links, 1)
+	assert.Equal(t, receive.sc, processed.links[0].SpanContext)
+
+	// Messages are linked only once.
+	require.NoError(t, process(context.Background(), msg))
+	assert.Empty(t, sr.Spans()[2].links)
+}
+
+func TestReceiveLinksRedelivery(t *testing.T) {
+	sr := newSpanRecorder()
+	_, first := sr.Start(context.Background(), "first")
+	_, second := sr.Start(context.Background(), "second")
+	msg := &sarama.ConsumerMessage{Topic: topic, Partition: 3, Offset: 7}
+
+	// The message is redelivered to another dispatcher before the first one
+	// clears its links.
+	var l1, l2 receiveLinks
+	l1.record(msg, first)
+	l2.record(msg, second)
+	l1.clear()
+
+	// Links are looked up by position, copies of the message are linked too.
+	redelivered := *msg
+	links := receiveSpanLinks(&redelivered)
+	require.Len(t, links, 1)
+	cfg := trace.NewSpanStartConfig(links...)
+	assert.Equal(t, second.SpanContext(), cfg.Links()[0].SpanContext)
+	assert.Nil(t, receiveSpanLinks(msg))
+	l2.clear()
+}
+
+func TestInstrumentWithEnabledFunc(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	var enabled atomic.Bool
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr), WithMeterProvider(mr), WithEnabledFunc(enabled.Load))
+
+	ctx := context.Background()
+	msg := &sarama.ConsumerMessage{Topic: topic}
+	allocs := testing.AllocsPerRun(10, func() {
+		require.NoError(t, process(ctx, msg))
+	})
+	assert.Zero(t, allocs)
+	assert.Empty(t, sr.Spans())
+	assert.Empty(t, mr.Measurements("messaging.process.duration"))
+
+	enabled.Store(true)
+	require.NoError(t, process(ctx, msg))
+	assert.Len(t, sr.Spans(), 1)
+	assert.Len(t, mr.Measurements("messaging.process.duration"), 1)
+}
+
+func TestInstrumentWithTimeSource(t *testing.T) {
+	mr := newMetricRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithMeterProvider(mr), WithTimeSource(steppingClock(250*time.Millisecond)))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+
+	measurements := mr.Measurements("messaging.process.duration")
+	require.Len(t, measurements, 1)
+	assert.Equal(t, 0.25, measurements[0].value)
+}
+
+func TestInstrumentNested(t *testing.T) {
+	sr := newSpanRecorder()
+	var calls int
+	process := Instrument(Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		calls++
+		return nil
+	}, WithTracerProvider(sr)), WithTracerProvider(sr))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+
+	assert.Equal(t, 1, calls)
+	assert.Len(t, sr.Spans(), 1)
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	sr := newSpanRecorder()
+
+	handler := Chain(
+		middleware("logging"),
+		InstrumentMiddleware(WithTracerProvider(sr)),
+		middleware("retry"),
+	)(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls = append(calls, "handler")
+		assert.True(t, trace.SpanFromContext(ctx).SpanContext().IsValid())
+		return nil
+	})
+
+	require.NoError(t, handler(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+	assert.Equal(t, []string{"logging", "retry", "handler"}, calls)
+	assert.Len(t, sr.Spans(), 1)
+}
+
+func TestInstrumentTombstone(t *testing.T) {
+	sr := newSpanRecorder()
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, WithTracerProvider(sr))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Key: []byte("foo")}))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].Attributes()[semconv.MessagingKafkaMessageTombstoneKey].AsBool())
+}
+
+func TestProcessOperationStop(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	instrumenter := newProcessInstrumenter(newConfig(WithTracerProvider(sr), WithMeterProvider(mr)))
+
+	ctx, op := instrumenter.start(context.Background(), &sarama.ConsumerMessage{Topic: topic})
+	assert.Same(t, instrumenter, op.instrumenter)
+	assert.Equal(t, op, ctx.Value(processOperationKey{}))
+
+	op.stop(ctx, nil)
+	op.stop(ctx, errors.New("stopped again"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Unset, spans[0].Status())
+	assert.Len(t, mr.Measurements("messaging.process.duration"), 1)
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaSourcePartition(0),
+		processOutcomeKey.String("success"),
+	)}}, mr.Measurements("messaging.client.processed.messages"))
+}
+
+func TestInstrumentInflight(t *testing.T) {
+	mr := newMetricRecorder()
+	var inflight float64
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		inflight = mr.Sum("messaging.client.process.inflight")
+		return nil
+	}, WithMeterProvider(mr))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+
+	assert.Equal(t, float64(1), inflight)
+	assert.Equal(t, float64(0), mr.Sum("messaging.client.process.inflight"))
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+	)
+	for _, m := range mr.Measurements("messaging.client.process.inflight") {
+		assert.Equal(t, wantAttrs, m.attrs)
+	}
+}
+
+func TestInstrumentWithProcessTimeoutWarning(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	var (
+		timeouts []time.Duration
+		fire     func()
+		stopped  bool
+	)
+	timers := optionFunc(func(cfg *config) {
+		cfg.afterFunc = func(d time.Duration, f func()) func() bool {
+			timeouts, fire = append(timeouts, d), f
+			return func() bool {
+				stopped = true
+				return true
+			}
+		}
+	})
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+		fire()
+		return nil
+	}, WithTracerProvider(sr), WithMeterProvider(mr), WithProcessTimeoutWarning(time.Minute), timers)
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+	assert.Equal(t, []time.Duration{time.Minute}, timeouts)
+	assert.True(t, stopped)
+	assert.Equal(t, float64(1), mr.Sum("messaging.client.process.timeouts"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	events := spans[0].Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "process.timeout", events[0].name)
+
+	// Messages processed in time are not reported, even if the timer fires
+	// after processing.
+	process = Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil },
+		WithMeterProvider(mr), WithProcessTimeoutWarning(time.Minute), timers)
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+	fire()
+	assert.Equal(t, float64(1), mr.Sum("messaging.client.process.timeouts"))
+}
+
+func TestInstrumentPanic(t *testing.T) {
+	for _, recoverPanics := range []bool{false, true} {
+		t.Run(strconv.FormatBool(recoverPanics), func(t *testing.T) {
+			sr := newSpanRecorder()
+			mr := newMetricRecorder()
+			opts := []Option{WithTracerProvider(sr), WithMeterProvider(mr)}
+			if recoverPanics {
+				opts = append(opts, WithPanicRecovery())
+			}
+			process := Instrument(func(context.Context, *sarama.ConsumerMessage) error {
+				panic("boom")
+			}, opts...)
+
+			var err error
+			call := func() { err = process(context.Background(), &sarama.ConsumerMessage{Topic: topic}) }
+			if recoverPanics {
+				require.NotPanics(t, call)
+				var perr *PanicError
+				require.ErrorAs(t, err, &perr)
+				assert.Equal(t, "boom", perr.Value)
+			} else {
+				assert.PanicsWithValue(t, "boom", call)
+			}
+
+			spans := sr.Ended()
+			require.Len(t, spans, 1)
+			assert.Equal(t, codes.Error, spans[0].Status())
+			assert.Equal(t, "panic", spans[0].Attributes()[errorTypeKey].AsString())
+			durations := mr.Measurements("messaging.process.duration")
+			require.Len(t, durations, 1)
+			errType, _ := durations[0].attrs.Value(errorTypeKey)
+			assert.Equal(t, "panic", errType.AsString())
+			assert.Equal(t, float64(0), mr.Sum("messaging.client.process.inflight"))
+		})
+	}
+}
+
+func TestInstrumentProcessAnnotations(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	stage := attribute.Key("app.stage")
+	process := Instrument(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		ctx, child := sr.Start(ctx, "persist")
+		defer child.End()
+		AddProcessEvent(ctx, "validated", stage.String("validate"))
+		SetProcessAttributes(ctx, stage.String("persisted"))
+		return nil
+	}, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic}))
+
+	var processSpan *recordedSpan
+	for _, span := range sr.Ended() {
+		if span.name == "test-topic process" {
+			processSpan = span
+		} else {
+			assert.Empty(t, span.Events())
+		}
+	}
+	require.NotNil(t, processSpan)
+	events := processSpan.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "validated", events[0].name)
+	assert.Equal(t, "persisted", processSpan.Attributes()[stage].AsString())
+
+	durations := mr.Measurements("messaging.process.duration")
+	require.Len(t, durations, 1)
+	value, ok := durations[0].attrs.Value(stage)
+	assert.True(t, ok)
+	assert.Equal(t, "persisted", value.AsString())
+	processed := mr.Measurements("messaging.client.processed.messages")
+	require.Len(t, processed, 1)
+	assert.False(t, processed[0].attrs.HasValue(stage))
+}
+
+// markingSession records the messages marked and the commits in it.
+type markingSession struct {
+	fakeConsumerGroupSession
+
+	marked  []int64
+	commits int
+}
+
+func (s *markingSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *markingSession) Commit() { s.commits++ }
+
+func TestInstrumentWithMarkOnSuccess(t *testing.T) {
+	sr := newSpanRecorder()
+	session := &markingSession{}
+	ctx := ContextWithSession(context.Background(), session)
+	process := Instrument(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			return errors.New("failed")
+		}
+		return nil
+	}, WithTracerProvider(sr), WithMarkOnSuccess(true))
+
+	require.NoError(t, process(ctx, &sarama.ConsumerMessage{Topic: topic, Offset: 1}))
+	require.Error(t, process(ctx, &sarama.ConsumerMessage{Topic: topic, Offset: 2}))
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Offset: 3}))
+
+	assert.Equal(t, []int64{1}, session.marked)
+	assert.Equal(t, 1, session.commits)
+	spans := sr.Ended()
+	require.Len(t, spans, 3)
+	events := spans[0].Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "message.marked", events[0].name)
+	assert.Equal(t, "offsets.committed", events[1].name)
+	require.Len(t, spans[1].Events(), 1)
+	assert.Equal(t, "exception", spans[1].Events()[0].name)
+	assert.Empty(t, spans[2].Events())
+}
+
+func TestInstrumentWithMarkOnSuccessDisabled(t *testing.T) {
+	sr := newSpanRecorder()
+	session := &markingSession{}
+	ctx := ContextWithSession(context.Background(), session)
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil },
+		WithTracerProvider(sr), WithMarkOnSuccess(true), WithEnabledFunc(func() bool { return false }))
+
+	require.NoError(t, process(ctx, &sarama.ConsumerMessage{Topic: topic, Offset: 4}))
+
+	assert.Equal(t, []int64{4}, session.marked)
+	assert.Equal(t, 1, session.commits)
+	assert.Empty(t, sr.Spans())
+}
+
+func TestInstrumentSpanStartHookAndKind(t *testing.T) {
+	sr := newSpanRecorder()
+	tenant := attribute.Key("app.tenant")
+	process := Instrument(func(context.Context, *sarama.ConsumerMessage) error { return nil },
+		WithTracerProvider(sr),
+		WithReceiveSpanKind(trace.SpanKindInternal),
+		WithSpanStartHook(func(msg *sarama.ConsumerMessage) []trace.SpanStartOption {
+			return []trace.SpanStartOption{trace.WithAttributes(tenant.String(string(msg.Key)))}
+		}),
+	)
+
+	require.NoError(t, process(context.Background(), &sarama.ConsumerMessage{Topic: topic, Key: []byte("acme")}))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, trace.SpanKindInternal, spans[0].kind)
+	assert.Equal(t, "acme", spans[0].Attributes()[tenant].AsString())
+}
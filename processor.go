@@ -0,0 +1,144 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// ConcurrentProcessor processes messages with a pool of workers, each
+// message in its own process span.
+type ConcurrentProcessor struct {
+	handler Handler
+	workers int
+	cfg     config
+
+	busyWorkers metric.Int64UpDownCounter
+	queueDepth  metric.Int64UpDownCounter
+}
+
+// NewConcurrentProcessor returns a ConcurrentProcessor processing messages
+// with handler, instrumented with Instrument, in the given number of
+// workers. By default, messages are processed in any order, use
+// WithPartitionOrder to process the messages of a partition in order.
+func NewConcurrentProcessor(handler Handler, workers int, opts ...Option) *ConcurrentProcessor {
+	cfg := newConfig(opts...)
+	if workers < 1 {
+		workers = 1
+	}
+	return &ConcurrentProcessor{
+		handler: Instrument(handler, opts...),
+		workers: workers,
+		cfg:     cfg,
+		busyWorkers: cfg.int64UpDownCounter(
+			"messaging.kafka.processor.busy_workers",
+			metric.WithUnit("{worker}"),
+			metric.WithDescription("Number of workers currently processing a message."),
+		),
+		queueDepth: cfg.int64UpDownCounter(
+			"messaging.kafka.processor.queue_depth",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of messages waiting for a worker."),
+		),
+	}
+}
+
+// Process processes messages until the channel is closed or ctx is done,
+// e.g. the messages of a claim within ConsumeClaim. It waits for messages
+// already handed to workers to be processed and returns the first error
+// returned by the handler.
+func (p *ConcurrentProcessor) Process(ctx context.Context, messages <-chan *sarama.ConsumerMessage) error {
+	queues := make([]chan *sarama.ConsumerMessage, p.workers)
+	for i := range queues {
+		if i == 0 || p.cfg.PartitionOrder {
+			queues[i] = make(chan *sarama.ConsumerMessage, p.workers)
+		} else {
+			queues[i] = queues[0]
+		}
+	}
+	attrs := p.cfg.withMetricAttributes(p.metricAttributes()...)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for _, queue := range queues {
+		wg.Add(1)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for msg := range queue {
+				p.queueDepth.Add(ctx, -1, attrs)
+				p.busyWorkers.Add(ctx, 1, attrs)
+				err := p.handler(ctx, msg)
+				p.busyWorkers.Add(ctx, -1, attrs)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+				}
+			}
+		}(queue)
+	}
+
+	p.dispatch(ctx, messages, queues, attrs)
+
+	if p.cfg.PartitionOrder {
+		for _, queue := range queues {
+			close(queue)
+		}
+	} else {
+		close(queues[0])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// dispatch hands messages over to the queues of the workers until the
+// channel is closed or ctx is done.
+func (p *ConcurrentProcessor) dispatch(ctx context.Context, messages <-chan *sarama.ConsumerMessage, queues []chan *sarama.ConsumerMessage, attrs metric.MeasurementOption) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			queue := queues[int(msg.Partition)%len(queues)]
+			p.queueDepth.Add(ctx, 1, attrs)
+			select {
+			case queue <- msg:
+			case <-ctx.Done():
+				p.queueDepth.Add(ctx, -1, attrs)
+				return
+			}
+		}
+	}
+}
+
+// metricAttributes returns the attributes of the metrics of the processor.
+func (p *ConcurrentProcessor) metricAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.MessagingSystem("kafka")}
+	if p.cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(p.cfg.ConsumerGroupID))
+	}
+	return append(attrs, p.cfg.Attributes...)
+}
@@ -0,0 +1,106 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func produceMessages(partitions, perPartition int) <-chan *sarama.ConsumerMessage {
+	messages := make(chan *sarama.ConsumerMessage, partitions*perPartition)
+	for offset := 0; offset < perPartition; offset++ {
+		for partition := 0; partition < partitions; partition++ {
+			messages <- &sarama.ConsumerMessage{Topic: topic, Partition: int32(partition), Offset: int64(offset)}
+		}
+	}
+	close(messages)
+	return messages
+}
+
+func TestConcurrentProcessor(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	var (
+		mtx       sync.Mutex
+		processed int
+	)
+	processor := NewConcurrentProcessor(func(context.Context, *sarama.ConsumerMessage) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		processed++
+		return nil
+	}, 3, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	require.NoError(t, processor.Process(context.Background(), produceMessages(2, 5)))
+
+	assert.Equal(t, 10, processed)
+	assert.Len(t, sr.Ended(), 10)
+	assert.Len(t, mr.Measurements("messaging.kafka.processor.queue_depth"), 20)
+	assert.Equal(t, float64(0), mr.Sum("messaging.kafka.processor.queue_depth"))
+	assert.Len(t, mr.Measurements("messaging.kafka.processor.busy_workers"), 20)
+	assert.Equal(t, float64(0), mr.Sum("messaging.kafka.processor.busy_workers"))
+}
+
+func TestConcurrentProcessorWithPartitionOrder(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		offsets = make(map[int32][]int64)
+	)
+	processor := NewConcurrentProcessor(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		offsets[msg.Partition] = append(offsets[msg.Partition], msg.Offset)
+		return nil
+	}, 4, WithPartitionOrder())
+
+	require.NoError(t, processor.Process(context.Background(), produceMessages(3, 20)))
+
+	require.Len(t, offsets, 3)
+	for partition, got := range offsets {
+		require.Len(t, got, 20, "partition %d", partition)
+		for i, offset := range got {
+			assert.Equal(t, int64(i), offset, "partition %d", partition)
+		}
+	}
+}
+
+func TestConcurrentProcessorError(t *testing.T) {
+	errProcess := errors.New("process failed")
+	processor := NewConcurrentProcessor(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			return errProcess
+		}
+		return nil
+	}, 2)
+
+	assert.ErrorIs(t, processor.Process(context.Background(), produceMessages(1, 5)), errProcess)
+}
+
+func TestConcurrentProcessorContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	processor := NewConcurrentProcessor(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}, 2)
+
+	assert.NoError(t, processor.Process(ctx, make(chan *sarama.ConsumerMessage)))
+}
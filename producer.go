@@ -19,13 +19,18 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 
 	"go.opentelemetry.io/otel/codes"
 
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -34,43 +39,158 @@ type syncProducer struct {
 	sarama.SyncProducer
 	cfg          config
 	saramaConfig *sarama.Config
+
+	producedBytes   metric.Int64Counter
+	publishDuration metric.Float64Histogram
+	headerBytes     headerBytes
+	batchSize       metric.Int64Histogram
 }
 
 // SendMessage calls sarama.SyncProducer.SendMessage and traces the request.
 func (p *syncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
-	span := startProducerSpan(p.cfg, p.saramaConfig.Version, msg)
+	if !p.cfg.enabled() {
+		return p.SyncProducer.SendMessage(msg)
+	}
+
+	start := p.cfg.now()
+	span := startProducerSpan(p.cfg, p.saramaConfig, msg)
+	p.headerBytes.record(p.cfg, msg)
 	partition, offset, err = p.SyncProducer.SendMessage(msg)
-	finishProducerSpan(span, partition, offset, err)
+	finishProducerSpan(p.cfg, span, msg, partition, offset, err)
+	recordPublishDuration(p.cfg, p.publishDuration, msg.Topic, start, err)
+	if err == nil {
+		recordProducedBytes(p.cfg, p.producedBytes, msg)
+	}
 	return partition, offset, err
 }
 
-// SendMessages calls sarama.SyncProducer.SendMessages and traces the requests.
+// SendMessages calls sarama.SyncProducer.SendMessages and traces the requests
+// in a batch publish span, linked to the publish spans of the messages.
 func (p *syncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	if !p.cfg.enabled() {
+		return p.SyncProducer.SendMessages(msgs)
+	}
+
+	start := p.cfg.now()
+	batchSpan := p.startBatchSpan(msgs)
+	p.recordBatchSize(msgs)
 	// Although there's only one call made to the SyncProducer, the messages are
 	// treated individually, so we create a span for each one
 	spans := make([]trace.Span, len(msgs))
 	for i, msg := range msgs {
-		spans[i] = startProducerSpan(p.cfg, p.saramaConfig.Version, msg)
+		spans[i] = startProducerSpan(p.cfg, p.saramaConfig, msg)
+		if sc := spans[i].SpanContext(); sc.IsValid() {
+			batchSpan.AddLink(trace.Link{SpanContext: sc})
+		}
+		p.headerBytes.record(p.cfg, msg)
 	}
 	err := p.SyncProducer.SendMessages(msgs)
+	if err != nil {
+		batchSpan.SetAttributes(errorTypeKey.String(p.cfg.errorType(err)))
+		batchSpan.SetStatus(codes.Error, err.Error())
+	}
+	batchSpan.End()
 	for i, span := range spans {
-		finishProducerSpan(span, msgs[i].Partition, msgs[i].Offset, err)
+		finishProducerSpan(p.cfg, span, msgs[i], msgs[i].Partition, msgs[i].Offset, err)
+		recordPublishDuration(p.cfg, p.publishDuration, msgs[i].Topic, start, err)
+		if err == nil {
+			recordProducedBytes(p.cfg, p.producedBytes, msgs[i])
+		}
 	}
 	return err
 }
 
+// startBatchSpan starts the publish span of a batch of msgs. The span is
+// named after the topic of msgs if all msgs are published to the same topic.
+func (p *syncProducer) startBatchSpan(msgs []*sarama.ProducerMessage) trace.Span {
+	if p.cfg.TracesDisabled {
+		return trace.SpanFromContext(context.Background())
+	}
+
+	topic := batchTopic(msgs)
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingOperationPublish,
+		semconv.MessagingBatchMessageCount(len(msgs)),
+	}
+	name := p.cfg.operationName("publish")
+	if topic != "" {
+		name = topic + " " + name
+		attrs = append(attrs, semconv.MessagingDestinationName(topic))
+		attrs = append(attrs, p.cfg.destinationTemplateAttributes(topic)...)
+		attrs = append(attrs, p.cfg.topicAttributes(topic)...)
+	}
+	attrs = append(attrs, p.cfg.operationAttributes("send", "publish")...)
+	attrs = append(attrs, p.cfg.Attributes...)
+	if p.cfg.PeerService != "" {
+		attrs = append(attrs, semconv.PeerService(p.cfg.PeerService))
+	}
+
+	// The batch is published in the context propagated in its first message.
+	ctx := context.Background()
+	if len(msgs) > 0 {
+		ctx = p.cfg.Propagators.Extract(ctx, NewProducerMessageCarrier(msgs[0], p.cfg.CarrierOptions...))
+	}
+	_, span := p.cfg.Tracer.Start(ctx, name,
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindProducer),
+	)
+	return span
+}
+
+// recordBatchSize records the number of msgs published in a batch.
+func (p *syncProducer) recordBatchSize(msgs []*sarama.ProducerMessage) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+	}
+	if topic := batchTopic(msgs); topic != "" {
+		attrs = append(attrs, p.cfg.destinationMetricAttribute(topic))
+		attrs = append(attrs, p.cfg.topicAttributes(topic)...)
+	}
+	attrs = append(attrs, p.cfg.Attributes...)
+	p.batchSize.Record(context.Background(), int64(len(msgs)), p.cfg.withMetricAttributes(attrs...))
+}
+
+// batchTopic returns the topic msgs are published to, or an empty string if
+// they are published to different topics.
+func batchTopic(msgs []*sarama.ProducerMessage) string {
+	if len(msgs) == 0 {
+		return ""
+	}
+	topic := msgs[0].Topic
+	for _, msg := range msgs[1:] {
+		if msg.Topic != topic {
+			return ""
+		}
+	}
+	return topic
+}
+
 // WrapSyncProducer wraps a sarama.SyncProducer so that all produced messages
-// are traced.
+// are traced. Publish spans record the compression codec and required acks of
+// saramaConfig.
 func WrapSyncProducer(saramaConfig *sarama.Config, producer sarama.SyncProducer, opts ...Option) sarama.SyncProducer {
+	if wrapped, ok := producer.(*syncProducer); ok {
+		return wrapped
+	}
 	cfg := newConfig(opts...)
 	if saramaConfig == nil {
 		saramaConfig = sarama.NewConfig()
 	}
 
 	return &syncProducer{
-		SyncProducer: producer,
-		cfg:          cfg,
-		saramaConfig: saramaConfig,
+		SyncProducer:    producer,
+		cfg:             cfg,
+		saramaConfig:    saramaConfig,
+		producedBytes:   newProducedBytesCounter(cfg),
+		publishDuration: newPublishDurationHistogram(cfg),
+		headerBytes:     newHeaderBytes(cfg),
+		batchSize: cfg.int64Histogram(
+			"messaging.kafka.producer.batch.size",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of messages published per SendMessages call of sync producers."),
+		),
 	}
 }
 
@@ -82,6 +202,11 @@ type asyncProducer struct {
 	closeErr      chan error
 	closeSig      chan struct{}
 	closeAsyncSig chan struct{}
+
+	// flushed is closed once the spans of all messages in flight ended,
+	// abandon ends these spans right away with an error.
+	flushed chan struct{}
+	abandon func(err error)
 }
 
 // Input returns the input channel.
@@ -116,8 +241,13 @@ func (p *asyncProducer) Close() error {
 	return <-p.closeErr
 }
 
+// producerMessageKey identifies a message in flight of an async producer.
+type producerMessageKey uint64
+
 type producerMessageContext struct {
+	msg            *sarama.ProducerMessage
 	span           trace.Span
+	start          time.Time
 	metadataBackup interface{}
 }
 
@@ -127,7 +257,13 @@ type producerMessageContext struct {
 //
 // If `Return.Successes` is false, there is no way to know partition and offset of
 // the message.
+//
+// Messages are handed over to p one at a time, in the order they are sent to
+// Input, so the ordering of messages per partition is preserved.
 func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts ...Option) sarama.AsyncProducer {
+	if wrapped, ok := p.(*asyncProducer); ok {
+		return wrapped
+	}
 	cfg := newConfig(opts...)
 	if saramaConfig == nil {
 		saramaConfig = sarama.NewConfig()
@@ -141,11 +277,17 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 		closeErr:      make(chan error),
 		closeSig:      make(chan struct{}),
 		closeAsyncSig: make(chan struct{}),
+		flushed:       make(chan struct{}),
 	}
 
 	var (
 		mtx                     sync.Mutex
 		producerMessageContexts = make(map[interface{}]producerMessageContext)
+		producedBytes           = newProducedBytesCounter(cfg)
+		publishDuration         = newPublishDurationHistogram(cfg)
+		producerErrors          = newProducerErrorsCounter(cfg)
+		headerBytes             = newHeaderBytes(cfg)
+		nextKey                 producerMessageKey
 	)
 
 	// Spawn Input producer goroutine.
@@ -162,16 +304,25 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 				if !ok {
 					continue // wait for closeAsyncSig
 				}
-				span := startProducerSpan(cfg, saramaConfig.Version, msg)
+				if !cfg.enabled() {
+					p.Input() <- msg
+					continue
+				}
+				span := startProducerSpan(cfg, saramaConfig, msg)
+				headerBytes.record(cfg, msg)
 
 				// Create message context, backend message metadata
 				mc := producerMessageContext{
+					msg:            msg,
 					metadataBackup: msg.Metadata,
 					span:           span,
+					start:          cfg.now(),
 				}
 
-				// Remember metadata using span ID as a cache key
-				msg.Metadata = span.SpanContext().SpanID()
+				// Remember metadata using a sequence number as cache key, which
+				// is unique even if traces are disabled.
+				nextKey++
+				msg.Metadata = nextKey
 				if saramaConfig.Producer.Return.Successes {
 					mtx.Lock()
 					producerMessageContexts[msg.Metadata] = mc
@@ -180,6 +331,7 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 					// If returning successes isn't enabled, we just finish the
 					// span right away because there's no way to know when it will
 					// be done.
+					publishSpans.Delete(msg)
 					mc.span.End()
 				}
 
@@ -202,12 +354,17 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 		for msg := range p.Successes() {
 			key := msg.Metadata
 			mtx.Lock()
-			if mc, ok := producerMessageContexts[key]; ok {
-				delete(producerMessageContexts, key)
-				finishProducerSpan(mc.span, msg.Partition, msg.Offset, nil)
+			mc, ok := producerMessageContexts[key]
+			delete(producerMessageContexts, key)
+			mtx.Unlock()
+			if ok {
+				finishProducerSpan(cfg, mc.span, msg, msg.Partition, msg.Offset, nil)
+				recordPublishDuration(cfg, publishDuration, msg.Topic, mc.start, nil)
 				msg.Metadata = mc.metadataBackup // Restore message metadata
 			}
-			mtx.Unlock()
+			if cfg.enabled() {
+				recordProducedBytes(cfg, producedBytes, msg)
+			}
 			wrapped.successes <- msg
 		}
 	}()
@@ -222,12 +379,17 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 		for errMsg := range p.Errors() {
 			key := errMsg.Msg.Metadata
 			mtx.Lock()
-			if mc, ok := producerMessageContexts[key]; ok {
-				delete(producerMessageContexts, key)
-				finishProducerSpan(mc.span, errMsg.Msg.Partition, errMsg.Msg.Offset, errMsg.Err)
+			mc, ok := producerMessageContexts[key]
+			delete(producerMessageContexts, key)
+			mtx.Unlock()
+			if ok {
+				finishProducerSpan(cfg, mc.span, errMsg.Msg, errMsg.Msg.Partition, errMsg.Msg.Offset, errMsg.Err)
+				recordPublishDuration(cfg, publishDuration, errMsg.Msg.Topic, mc.start, errMsg.Err)
 				errMsg.Msg.Metadata = mc.metadataBackup // Restore message metadata
 			}
-			mtx.Unlock()
+			if cfg.enabled() {
+				recordProducerError(cfg, producerErrors, errMsg)
+			}
 			wrapped.errors <- errMsg
 		}
 	}()
@@ -239,10 +401,21 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 		// end all remaining spans
 		mtx.Lock()
 		for _, mc := range producerMessageContexts {
+			publishSpans.Delete(mc.msg)
 			mc.span.End()
 		}
 		mtx.Unlock()
+		close(wrapped.flushed)
 	}()
+	wrapped.abandon = func(err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for key, mc := range producerMessageContexts {
+			delete(producerMessageContexts, key)
+			publishSpans.Delete(mc.msg)
+			endAbandonedSpan(cfg, mc.span, err)
+		}
+	}
 
 	return wrapped
 }
@@ -278,9 +451,157 @@ func msgPayloadSize(msg *sarama.ProducerMessage, kafkaVersion sarama.KafkaVersio
 	return size
 }
 
-func startProducerSpan(cfg config, version sarama.KafkaVersion, msg *sarama.ProducerMessage) trace.Span {
+func newProducedBytesCounter(cfg config) metric.Int64Counter {
+	return cfg.int64Counter(
+		"messaging.kafka.produced.bytes",
+		metric.WithUnit("By"),
+		metric.WithDescription("Number of key and value bytes of successfully produced messages."),
+	)
+}
+
+// recordProducedBytes counts the bytes of the key and value of a
+// successfully produced message.
+func recordProducedBytes(cfg config, counter metric.Int64Counter, msg *sarama.ProducerMessage) {
+	var size int
+	if msg.Key != nil {
+		size += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		size += msg.Value.Length()
+	}
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		cfg.destinationMetricAttribute(msg.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
+	}
+	attrs = append(attrs, cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, cfg.Attributes...)
+	counter.Add(context.Background(), int64(size), cfg.withMetricAttributes(attrs...))
+}
+
+// headerBytesBoundaries are the bucket boundaries of the header size
+// histograms, covering a traceparent header of 66 bytes up to headers of
+// several kilobytes.
+var headerBytesBoundaries = []float64{0, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384}
+
+// headerBytes records the size of the headers of published messages and the
+// share of it taken by headers carrying propagation fields.
+type headerBytes struct {
+	fields      map[string]struct{}
+	keys        *headerKeys
+	total       metric.Int64Histogram
+	propagation metric.Int64Histogram
+}
+
+func newHeaderBytes(cfg config) headerBytes {
+	fields := make(map[string]struct{})
+	for _, field := range cfg.Propagators.Fields() {
+		fields[strings.ToLower(field)] = struct{}{}
+	}
+	return headerBytes{
+		fields: fields,
+		keys:   newHeaderKeys(cfg.CarrierOptions),
+		total: cfg.int64Histogram(
+			"messaging.kafka.message.header_bytes",
+			metric.WithUnit("By"),
+			metric.WithDescription("Size of the header keys and values of published messages."),
+			metric.WithExplicitBucketBoundaries(headerBytesBoundaries...),
+		),
+		propagation: cfg.int64Histogram(
+			"messaging.kafka.message.propagation_header_bytes",
+			metric.WithUnit("By"),
+			metric.WithDescription("Size of the header keys and values carrying propagation fields of published messages."),
+			metric.WithExplicitBucketBoundaries(headerBytesBoundaries...),
+		),
+	}
+}
+
+// record records the header sizes of msg once the span context was injected
+// into it.
+func (h headerBytes) record(cfg config, msg *sarama.ProducerMessage) {
+	var total, propagation int
+	for _, hdr := range msg.Headers {
+		size := len(hdr.Key) + len(hdr.Value)
+		total += size
+		if field, ok := h.keys.field(string(hdr.Key)); ok {
+			if _, ok := h.fields[strings.ToLower(field)]; ok {
+				propagation += size
+			}
+		}
+	}
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		cfg.destinationMetricAttribute(msg.Topic),
+	}
+	attrs = append(attrs, cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, cfg.Attributes...)
+	set := cfg.withMetricAttributes(attrs...)
+	h.total.Record(context.Background(), int64(total), set)
+	h.propagation.Record(context.Background(), int64(propagation), set)
+}
+
+func newProducerErrorsCounter(cfg config) metric.Int64Counter {
+	return cfg.int64Counter(
+		"messaging.client.producer.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Number of messages async producers failed to produce."),
+	)
+}
+
+// recordProducerError counts a message an async producer failed to produce.
+func recordProducerError(cfg config, counter metric.Int64Counter, errMsg *sarama.ProducerError) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		errorTypeKey.String(cfg.errorType(errMsg.Err)),
+		cfg.destinationMetricAttribute(errMsg.Msg.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(errMsg.Msg.Partition)),
+	}
+	attrs = append(attrs, cfg.topicAttributes(errMsg.Msg.Topic)...)
+	attrs = append(attrs, cfg.Attributes...)
+	counter.Add(context.Background(), 1, cfg.withMetricAttributes(attrs...))
+}
+
+func newPublishDurationHistogram(cfg config) metric.Float64Histogram {
+	return cfg.durationHistogram(
+		"messaging.publish.duration",
+		"Duration of publish operations, until messages are acknowledged.",
+	)
+}
+
+// recordPublishDuration records the duration of publishing a message to
+// topic since start.
+func recordPublishDuration(cfg config, histogram metric.Float64Histogram, topic string, start time.Time, err error) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		cfg.destinationMetricAttribute(topic),
+	}
+	if err != nil {
+		attrs = append(attrs, errorTypeKey.String(cfg.errorType(err)))
+	}
+	attrs = append(attrs, cfg.topicAttributes(topic)...)
+	attrs = append(attrs, cfg.Attributes...)
+	histogram.Record(context.Background(), cfg.since(start).Seconds(), cfg.withMetricAttributes(attrs...))
+}
+
+// publishSpans holds the publish spans of messages in flight, so
+// instrumented partitioners and interceptors can annotate them.
+var publishSpans sync.Map // map[*sarama.ProducerMessage]*publishSpan
+
+// publishSpan is the publish span of a message in flight.
+type publishSpan struct {
+	trace.Span
+
+	// attempts counts the attempts of sarama to send the message.
+	attempts atomic.Int64
+}
+
+func startProducerSpan(cfg config, saramaConfig *sarama.Config, msg *sarama.ProducerMessage) trace.Span {
+	if cfg.TracesDisabled {
+		return trace.SpanFromContext(context.Background())
+	}
+
 	// If there's a span context in the message, use that as the parent context.
-	carrier := NewProducerMessageCarrier(msg)
+	carrier := NewProducerMessageCarrier(msg, cfg.CarrierOptions...)
 	ctx := cfg.Propagators.Extract(context.Background(), carrier)
 
 	// Create a span.
@@ -288,30 +609,66 @@ func startProducerSpan(cfg config, version sarama.KafkaVersion, msg *sarama.Prod
 		semconv.MessagingSystem("kafka"),
 		semconv.MessagingDestinationKindTopic,
 		semconv.MessagingDestinationName(msg.Topic),
-		semconv.MessagingMessagePayloadSizeBytes(msgPayloadSize(msg, version)),
+		semconv.MessagingMessagePayloadSizeBytes(msgPayloadSize(msg, saramaConfig.Version)),
 		semconv.MessagingOperationPublish,
 	}
+	attrs = append(attrs, cfg.operationAttributes("send", "publish")...)
+	attrs = append(attrs, cfg.destinationTemplateAttributes(msg.Topic)...)
+	attrs = append(attrs, cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, cfg.Attributes...)
+	attrs = append(attrs,
+		producerCompressionKey.String(saramaConfig.Producer.Compression.String()),
+		producerRequiredAcksKey.Int(int(saramaConfig.Producer.RequiredAcks)),
+	)
+	if cfg.PeerService != "" {
+		attrs = append(attrs, semconv.PeerService(cfg.PeerService))
+	}
+	attrs = append(attrs, cfg.producerConversationIDAttributes(msg.Headers)...)
+	attrs = append(attrs, cfg.keyAttributes(encode(msg.Key))...)
+	if cfg.PayloadCapture {
+		attrs = append(attrs, payloadAttributes(encode(msg.Value), cfg.PayloadMaxBytes)...)
+	}
 	opts := []trace.SpanStartOption{
 		trace.WithAttributes(attrs...),
 		trace.WithSpanKind(trace.SpanKindProducer),
 	}
-	ctx, span := cfg.Tracer.Start(ctx, fmt.Sprintf("%s publish", msg.Topic), opts...)
-
-	if version.IsAtLeast(sarama.V0_11_0_0) {
+	ctx, span := cfg.Tracer.Start(ctx, fmt.Sprintf("%s %s", msg.Topic, cfg.operationName("publish")), opts...)
+	publishSpans.Store(msg, &publishSpan{Span: span})
+
+	if cfg.ContextCodec != nil {
+		// Embed current span context into the message itself.
+		fields := propagation.MapCarrier{}
+		cfg.Propagators.Inject(ctx, cfg.injectCarrier(fields))
+		cfg.encodeContext(msg, fields)
+	} else if saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
 		// Inject current span context, so consumers can use it to propagate span.
-		cfg.Propagators.Inject(ctx, carrier)
+		cfg.Propagators.Inject(ctx, cfg.injectCarrier(carrier))
 	}
 
 	return span
 }
 
-func finishProducerSpan(span trace.Span, partition int32, offset int64, err error) {
+func finishProducerSpan(cfg config, span trace.Span, msg *sarama.ProducerMessage, partition int32, offset int64, err error) {
+	publishSpans.Delete(msg)
 	span.SetAttributes(
 		semconv.MessagingMessageID(strconv.FormatInt(offset, 10)),
 		semconv.MessagingKafkaDestinationPartition(int(partition)),
 	)
+	span.SetAttributes(cfg.leaderAttributes(msg.Topic, partition)...)
 	if err != nil {
+		if cfg.leaders != nil && partition >= 0 {
+			// Leadership of the partition may have moved.
+			cfg.leaders.invalidate(msg.Topic, partition)
+		}
+		span.SetAttributes(errorTypeKey.String(cfg.errorType(err)))
 		span.SetStatus(codes.Error, err.Error())
+	} else {
+		// Identify the record written, so consumer-side traces can be
+		// joined to it.
+		span.SetAttributes(
+			destinationPartitionIDKey.String(strconv.FormatInt(int64(partition), 10)),
+			messageOffsetKey.Int64(offset),
+		)
 	}
 	span.End()
 }
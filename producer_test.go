@@ -15,13 +15,20 @@
 package otelsarama
 
 import (
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/IBM/sarama/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
@@ -136,6 +143,152 @@ func TestAsyncProducer_ConcurrencyEdgeCases(t *testing.T) {
 	}
 }
 
+func TestWrapSyncProducerError(t *testing.T) {
+	sr := newSpanRecorder()
+	cfg := newSaramaConfig()
+	mockSyncProducer := mocks.NewSyncProducer(t, cfg)
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrRequestTimedOut)
+
+	producer := WrapSyncProducer(cfg, mockSyncProducer, WithTracerProvider(sr),
+		WithErrorTypeMapper(func(error) string { return "downstream_timeout" }))
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: topic})
+	assert.ErrorIs(t, err, sarama.ErrRequestTimedOut)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	assert.Equal(t, "downstream_timeout", spans[0].Attributes()[errorTypeKey].AsString())
+}
+
+func TestWrapProducerProducedBytes(t *testing.T) {
+	cfg := newSaramaConfig()
+	cfg.Producer.Return.Successes = true
+	msg := func() *sarama.ProducerMessage {
+		return &sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder("foo"), Value: sarama.StringEncoder("barbaz")}
+	}
+
+	t.Run("sync", func(t *testing.T) {
+		mr := newMetricRecorder()
+		mockSyncProducer := mocks.NewSyncProducer(t, cfg)
+		mockSyncProducer.ExpectSendMessageAndSucceed()
+		mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrRequestTimedOut)
+		producer := WrapSyncProducer(cfg, mockSyncProducer, WithMeterProvider(mr))
+
+		_, _, err := producer.SendMessage(msg())
+		require.NoError(t, err)
+		_, _, err = producer.SendMessage(msg())
+		require.Error(t, err)
+
+		assert.Equal(t, float64(9), mr.Sum("messaging.kafka.produced.bytes"))
+	})
+
+	t.Run("async", func(t *testing.T) {
+		mr := newMetricRecorder()
+		mockAsyncProducer := mocks.NewAsyncProducer(t, cfg)
+		mockAsyncProducer.ExpectInputAndSucceed()
+		producer := WrapAsyncProducer(cfg, mockAsyncProducer, WithMeterProvider(mr))
+
+		producer.Input() <- msg()
+		<-producer.Successes()
+		require.NoError(t, producer.Close())
+
+		assert.Equal(t, float64(9), mr.Sum("messaging.kafka.produced.bytes"))
+	})
+}
+
+func TestWrapSyncProducerPublishDuration(t *testing.T) {
+	mr := newMetricRecorder()
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrRequestTimedOut)
+	producer := WrapSyncProducer(newSaramaConfig(), mockSyncProducer, WithMeterProvider(mr))
+
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.Error(t, err)
+
+	measurements := mr.Measurements("messaging.publish.duration")
+	require.Len(t, measurements, 2)
+	_, ok := measurements[0].attrs.Value(errorTypeKey)
+	assert.False(t, ok)
+	errType, ok := measurements[1].attrs.Value(errorTypeKey)
+	assert.True(t, ok)
+	assert.Equal(t, sarama.ErrRequestTimedOut.Error(), errType.AsString())
+}
+
+func TestWrapSyncProducerHeaderBytes(t *testing.T) {
+	mr := newMetricRecorder()
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	producer := WrapSyncProducer(newSaramaConfig(), mockSyncProducer,
+		WithTracerProvider(newSpanRecorder()),
+		WithMeterProvider(mr),
+		WithPropagators(propagation.TraceContext{}),
+	)
+
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Headers: []sarama.RecordHeader{{Key: []byte("tenant"), Value: []byte("acme")}},
+	})
+	require.NoError(t, err)
+
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+	)
+	// traceparent: 11 bytes of key and 55 bytes of value.
+	assert.Equal(t, []measurement{{value: 66 + 10, attrs: wantAttrs}}, mr.Measurements("messaging.kafka.message.header_bytes"))
+	assert.Equal(t, []measurement{{value: 66, attrs: wantAttrs}}, mr.Measurements("messaging.kafka.message.propagation_header_bytes"))
+}
+
+func TestWrapSyncProducerSendMessagesBatchSpan(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	producer := WrapSyncProducer(newSaramaConfig(), mockSyncProducer, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	require.NoError(t, producer.SendMessages([]*sarama.ProducerMessage{{Topic: topic}, {Topic: topic}}))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 3)
+	batch := spans[0]
+	assert.Equal(t, topic+" publish", batch.name)
+	assert.Equal(t, oteltrace.SpanKindProducer, batch.kind)
+	assert.Equal(t, int64(2), batch.Attributes()[semconv.MessagingBatchMessageCountKey].AsInt64())
+	require.Len(t, batch.links, 2)
+	assert.Equal(t, spans[1].sc, batch.links[0].SpanContext)
+	assert.Equal(t, spans[2].sc, batch.links[1].SpanContext)
+
+	assert.Equal(t, []measurement{{value: 2, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+	)}}, mr.Measurements("messaging.kafka.producer.batch.size"))
+}
+
+func TestWrapSyncProducerRecordWritten(t *testing.T) {
+	sr := newSpanRecorder()
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrRequestTimedOut)
+	producer := WrapSyncProducer(newSaramaConfig(), mockSyncProducer, WithTracerProvider(sr))
+
+	partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.Error(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	attrs := spans[0].Attributes()
+	assert.Equal(t, strconv.Itoa(int(partition)), attrs[destinationPartitionIDKey].AsString())
+	assert.Equal(t, offset, attrs[messageOffsetKey].AsInt64())
+	assert.NotContains(t, spans[1].Attributes(), destinationPartitionIDKey)
+	assert.NotContains(t, spans[1].Attributes(), messageOffsetKey)
+}
+
 func newSaramaConfig() *sarama.Config {
 	cfg := sarama.NewConfig()
 	cfg.Version = sarama.V0_11_0_0
@@ -223,3 +376,132 @@ func BenchmarkMockAsyncProducer(b *testing.B) {
 		<-asyncProducer.Successes()
 	}
 }
+
+func TestWrapSyncProducerTwice(t *testing.T) {
+	sr := newSpanRecorder()
+	cfg := newSaramaConfig()
+	mockSyncProducer := mocks.NewSyncProducer(t, cfg)
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+
+	producer := WrapSyncProducer(cfg, mockSyncProducer, WithTracerProvider(sr))
+	assert.Same(t, producer, WrapSyncProducer(cfg, producer, WithTracerProvider(sr)))
+	_, _, err := WrapSyncProducer(cfg, producer).SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+
+	assert.Len(t, sr.Ended(), 1)
+}
+
+func TestWrapSyncProducerCompressionAndAcks(t *testing.T) {
+	sr := newSpanRecorder()
+	cfg := newSaramaConfig()
+	cfg.Producer.Compression = sarama.CompressionLZ4
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	mockSyncProducer := mocks.NewSyncProducer(t, cfg)
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+
+	producer := WrapSyncProducer(cfg, mockSyncProducer, WithTracerProvider(sr))
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	attrs := spans[0].Attributes()
+	assert.Equal(t, "lz4", attrs[producerCompressionKey].AsString())
+	assert.Equal(t, int64(-1), attrs[producerRequiredAcksKey].AsInt64())
+}
+
+func TestWrapSyncProducerConversationID(t *testing.T) {
+	sr := newSpanRecorder()
+	cfg := newSaramaConfig()
+	mockSyncProducer := mocks.NewSyncProducer(t, cfg)
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+
+	producer := WrapSyncProducer(cfg, mockSyncProducer, WithTracerProvider(sr), WithConversationIDHeader("correlation-id"))
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Headers: []sarama.RecordHeader{{Key: []byte("correlation-id"), Value: []byte("42")}},
+	})
+	require.NoError(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "42", spans[0].Attributes()[semconv.MessagingMessageConversationIDKey].AsString())
+}
+
+func TestWrapAsyncProducerError(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	cfg := newSaramaConfig()
+	cfg.Producer.Return.Successes = true
+	mockAsyncProducer := mocks.NewAsyncProducer(t, cfg)
+	mockAsyncProducer.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+	producer := WrapAsyncProducer(cfg, mockAsyncProducer, WithTracerProvider(sr), WithMeterProvider(mr))
+
+	producer.Input() <- &sarama.ProducerMessage{Topic: topic, Metadata: "foo"}
+	errMsg := <-producer.Errors()
+	require.NoError(t, producer.Close())
+
+	assert.ErrorIs(t, errMsg.Err, sarama.ErrNotLeaderForPartition)
+	assert.Equal(t, "foo", errMsg.Msg.Metadata)
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	assert.Equal(t, sarama.ErrNotLeaderForPartition.Error(), spans[0].Attributes()[errorTypeKey].AsString())
+	assert.Equal(t, []measurement{{value: 1, attrs: attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		errorTypeKey.String(sarama.ErrNotLeaderForPartition.Error()),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaDestinationPartition(int(errMsg.Msg.Partition)),
+	)}}, mr.Measurements("messaging.client.producer.errors"))
+}
+
+func TestWrapAsyncProducerWithoutTracesPublishDuration(t *testing.T) {
+	mr := newMetricRecorder()
+	cfg := newSaramaConfig()
+	cfg.Producer.Return.Successes = true
+	mockAsyncProducer := mocks.NewAsyncProducer(t, cfg)
+	mockAsyncProducer.ExpectInputAndSucceed()
+	mockAsyncProducer.ExpectInputAndSucceed()
+	producer := WrapAsyncProducer(cfg, mockAsyncProducer, WithMeterProvider(mr), WithoutTraces())
+
+	producer.Input() <- &sarama.ProducerMessage{Topic: topic, Metadata: "foo"}
+	producer.Input() <- &sarama.ProducerMessage{Topic: topic, Metadata: "bar"}
+	first, second := <-producer.Successes(), <-producer.Successes()
+	require.NoError(t, producer.Close())
+
+	assert.Equal(t, "foo", first.Metadata)
+	assert.Equal(t, "bar", second.Metadata)
+	assert.Len(t, mr.Measurements("messaging.publish.duration"), 2)
+}
+
+func TestWrapAsyncProducerPreservesOrder(t *testing.T) {
+	const n = 1000
+	cfg := newSaramaConfig()
+	cfg.Producer.Return.Successes = true
+	mockAsyncProducer := mocks.NewAsyncProducer(t, cfg)
+	for i := 0; i < n; i++ {
+		mockAsyncProducer.ExpectInputAndSucceed()
+	}
+	// Alternate between instrumented and passed through messages, which must
+	// not overtake each other.
+	var calls atomic.Int64
+	producer := WrapAsyncProducer(cfg, mockAsyncProducer,
+		WithTracerProvider(newSpanRecorder()),
+		WithEnabledFunc(func() bool { return calls.Add(1)%2 == 0 }),
+	)
+
+	go func() {
+		for i := 0; i < n; i++ {
+			producer.Input() <- &sarama.ProducerMessage{
+				Topic: topic,
+				Key:   sarama.StringEncoder("key"),
+				Value: sarama.StringEncoder(strconv.Itoa(i)),
+			}
+		}
+	}()
+	for i := 0; i < n; i++ {
+		msg := <-producer.Successes()
+		require.Equal(t, sarama.StringEncoder(strconv.Itoa(i)), msg.Value)
+	}
+	require.NoError(t, producer.Close())
+}
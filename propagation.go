@@ -0,0 +1,219 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const baggageHeader = "baggage"
+
+// withoutBaggage is a propagation.TextMapPropagator stripping baggage from
+// extracted and injected contexts.
+type withoutBaggage struct {
+	propagation.TextMapPropagator
+}
+
+var _ propagation.TextMapPropagator = withoutBaggage{}
+
+// Inject injects ctx without its baggage into carrier.
+func (p withoutBaggage) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	p.TextMapPropagator.Inject(baggage.ContextWithoutBaggage(ctx), carrier)
+}
+
+// Extract extracts a context without baggage from carrier.
+func (p withoutBaggage) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return baggage.ContextWithoutBaggage(p.TextMapPropagator.Extract(ctx, carrier))
+}
+
+// Fields returns the fields set by the wrapped propagator, except baggage.
+func (p withoutBaggage) Fields() []string {
+	var fields []string
+	for _, f := range p.TextMapPropagator.Fields() {
+		if f != baggageHeader {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// extractFallbacks is a propagation.TextMapPropagator trying fallback
+// propagators when the wrapped propagator extracts no span context.
+type extractFallbacks struct {
+	propagation.TextMapPropagator
+	fallbacks []propagation.TextMapPropagator
+}
+
+var _ propagation.TextMapPropagator = extractFallbacks{}
+
+// Extract extracts a context from carrier with the wrapped propagator and,
+// if that yields no new span context, with the first fallback that does.
+func (p extractFallbacks) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	sc := trace.SpanContextFromContext(ctx)
+	extracted := p.TextMapPropagator.Extract(ctx, carrier)
+	for _, fallback := range p.fallbacks {
+		if extractedSC := trace.SpanContextFromContext(extracted); extractedSC.IsValid() && !extractedSC.Equal(sc) {
+			break
+		}
+		extracted = fallback.Extract(extracted, carrier)
+	}
+	return extracted
+}
+
+// ContextFromMessage returns a copy of parent carrying the span context the
+// receive span of msg injected into it, so spans processing msg can be
+// created as its children. The options must configure the propagators and
+// carrier options msg was consumed with. If msg was consumed with
+// WithDeferredReceiveSpanEnd and Done was not yet called for it, the
+// returned context carries its receive span instead.
+func ContextFromMessage(parent context.Context, msg *sarama.ConsumerMessage, opts ...Option) context.Context {
+	return newConfig(opts...).contextFromMessage(parent, msg)
+}
+
+func (cfg config) contextFromMessage(parent context.Context, msg *sarama.ConsumerMessage) context.Context {
+	if span, ok := openReceiveSpans.Load(msg); ok {
+		return trace.ContextWithSpan(parent, span.(*openReceiveSpan).Span)
+	}
+	return cfg.Propagators.Extract(parent, NewConsumerMessageCarrier(msg, cfg.CarrierOptions...))
+}
+
+// forceSampled returns a copy of ctx whose span context is marked as sampled.
+// ctx is returned unchanged if it carries no valid span context.
+func forceSampled(ctx context.Context) context.Context {
+	span := trace.SpanFromContext(ctx)
+	sc := span.SpanContext()
+	if !sc.IsValid() || sc.IsSampled() {
+		return ctx
+	}
+	sc = sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+	if sc.IsRemote() {
+		return trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	return trace.ContextWithSpanContext(ctx, sc)
+}
+
+// InjectContext injects the span context of ctx into msg, so the publish
+// span of msg is created as its child, e.g. to make messages produced while
+// processing a consumed message children of its process span. The options
+// must configure the propagators and carrier options msg is produced with.
+func InjectContext(ctx context.Context, msg *sarama.ProducerMessage, opts ...Option) {
+	cfg := newConfig(opts...)
+	cfg.Propagators.Inject(ctx, cfg.injectCarrier(NewProducerMessageCarrier(msg, cfg.CarrierOptions...)))
+}
+
+// filteredCarrier is a propagation.TextMapCarrier setting only the fields
+// accepted by filter.
+type filteredCarrier struct {
+	propagation.TextMapCarrier
+	filter func(key string) bool
+}
+
+// Set sets key to value if filter accepts key.
+func (c filteredCarrier) Set(key, value string) {
+	if c.filter(key) {
+		c.TextMapCarrier.Set(key, value)
+	}
+}
+
+// injectCarrier returns the carrier to inject span contexts into produced
+// messages with, applying the filter of WithInjectedHeadersFilter.
+func (cfg config) injectCarrier(carrier propagation.TextMapCarrier) propagation.TextMapCarrier {
+	if cfg.InjectedHeadersFilter == nil {
+		return carrier
+	}
+	return filteredCarrier{TextMapCarrier: carrier, filter: cfg.InjectedHeadersFilter}
+}
+
+// extractFailure returns why extracting the span context propagated in
+// carrier into parent, yielding extracted, failed: "malformed" if carrier
+// holds propagated fields but no span context was extracted from them,
+// "conflict" if a fallback propagator extracts a span context of another
+// trace. It returns an empty string if extracting succeeded.
+func (cfg config) extractFailure(parent, extracted context.Context, carrier propagation.TextMapCarrier) string {
+	sc := trace.SpanContextFromContext(extracted)
+	if !sc.IsValid() || sc.Equal(trace.SpanContextFromContext(parent)) {
+		if !hasPropagatedFields(carrier, cfg.Propagators) {
+			return ""
+		}
+		// parent may carry the propagated span context already.
+		if sc.IsValid() && trace.SpanContextFromContext(cfg.Propagators.Extract(context.Background(), carrier)).IsValid() {
+			return ""
+		}
+		return "malformed"
+	}
+	for _, fallback := range cfg.ExtractFallbacks {
+		other := trace.SpanContextFromContext(fallback.Extract(context.Background(), carrier))
+		if other.IsValid() && other.TraceID() != sc.TraceID() {
+			return "conflict"
+		}
+	}
+	return ""
+}
+
+// newExtractFailuresCounter returns the counter of failures to extract the
+// trace context propagated in consumed messages.
+func newExtractFailuresCounter(cfg config) metric.Int64Counter {
+	return cfg.int64Counter(
+		"messaging.propagation.extract_failures",
+		metric.WithUnit("{failure}"),
+		metric.WithDescription("Number of consumed messages the propagated trace context could not be extracted from."),
+	)
+}
+
+// recordExtractFailure logs and counts a failure to extract the trace context
+// propagated in msg for operation.
+func (cfg config) recordExtractFailure(ctx context.Context, counter metric.Int64Counter, operation attribute.KeyValue, msg *sarama.ConsumerMessage, reason string) {
+	cfg.log(ctx, slog.LevelWarn, "otelsarama: extracting trace context from consumed message failed",
+		slog.String("topic", msg.Topic),
+		slog.Int("partition", int(msg.Partition)),
+		slog.Int64("offset", msg.Offset),
+		slog.String("reason", reason),
+	)
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		operation,
+		errorTypeKey.String(reason),
+		cfg.destinationMetricAttribute(msg.Topic),
+		semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+	}
+	if cfg.ConsumerGroupID != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroup(cfg.ConsumerGroupID))
+	}
+	attrs = append(attrs, cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, cfg.Attributes...)
+	counter.Add(ctx, 1, cfg.withMetricAttributes(attrs...))
+}
+
+// hasPropagatedFields reports whether carrier holds any of the fields
+// propagators propagates trace context in.
+func hasPropagatedFields(carrier propagation.TextMapCarrier, propagators propagation.TextMapPropagator) bool {
+	for _, f := range propagators.Fields() {
+		if f != baggageHeader && carrier.Get(f) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceparent = "00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01"
+	tracestate  = "vendor1=value1,vendor2=value2"
+)
+
+func TestTraceStatePropagation(t *testing.T) {
+	cfg := newConfig(WithPropagators(propagation.TraceContext{}))
+	consumerMsg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte(traceparent)},
+		{Key: []byte("tracestate"), Value: []byte(tracestate)},
+	}}
+
+	ctx := cfg.Propagators.Extract(context.Background(), NewConsumerMessageCarrier(consumerMsg))
+	assert.Equal(t, tracestate, trace.SpanContextFromContext(ctx).TraceState().String())
+
+	producerMsg := &sarama.ProducerMessage{}
+	carrier := NewProducerMessageCarrier(producerMsg)
+	cfg.Propagators.Inject(ctx, carrier)
+	assert.Equal(t, tracestate, carrier.Get("tracestate"))
+}
+
+func TestWithBaggagePropagation(t *testing.T) {
+	member, err := baggage.NewMember("tenant", "a")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+	ctx := baggage.ContextWithBaggage(context.Background(), bag)
+
+	testCases := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{
+			name:     "default",
+			opts:     []Option{WithPropagators(propagation.TraceContext{})},
+			expected: "",
+		},
+		{
+			name:     "enabled",
+			opts:     []Option{WithPropagators(propagation.TraceContext{}), WithBaggagePropagation(true)},
+			expected: "tenant=a",
+		},
+		{
+			name:     "disabled",
+			opts:     []Option{WithPropagators(propagation.Baggage{}), WithBaggagePropagation(false)},
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newConfig(tc.opts...)
+
+			msg := &sarama.ProducerMessage{}
+			carrier := NewProducerMessageCarrier(msg)
+			cfg.Propagators.Inject(ctx, carrier)
+			assert.Equal(t, tc.expected, carrier.Get(baggageHeader))
+
+			carrier.Set(baggageHeader, "tenant=a")
+			extracted := cfg.Propagators.Extract(context.Background(), carrier)
+			assert.Equal(t, tc.expected, baggage.FromContext(extracted).String())
+		})
+	}
+}
+
+func TestWithoutBaggageFields(t *testing.T) {
+	p := withoutBaggage{propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})}
+	assert.ElementsMatch(t, []string{"traceparent", "tracestate"}, p.Fields())
+}
+
+func TestWithInjectedHeadersFilter(t *testing.T) {
+	member, err := baggage.NewMember("tenant", "a")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{0x01}, SpanID: trace.SpanID{0x01}})
+	ctx := trace.ContextWithSpanContext(baggage.ContextWithBaggage(context.Background(), bag), sc)
+
+	msg := &sarama.ProducerMessage{}
+	InjectContext(ctx, msg,
+		WithPropagators(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})),
+		WithBaggagePropagation(true),
+		WithInjectedHeadersFilter(func(key string) bool { return key != baggageHeader }),
+		WithCarrierOptions(WithHeaderPrefix("myco-")),
+	)
+
+	assert.Equal(t, []string{"myco-traceparent"}, NewProducerMessageCarrier(msg).Keys())
+}
+
+// legacyPropagator extracts a fixed span context if its header is set.
+type legacyPropagator struct {
+	header string
+	sc     trace.SpanContext
+}
+
+func (legacyPropagator) Inject(context.Context, propagation.TextMapCarrier) {}
+
+func (p legacyPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if carrier.Get(p.header) == "" {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, p.sc)
+}
+
+func (p legacyPropagator) Fields() []string { return []string{p.header} }
+
+func TestWithExtractFallbacks(t *testing.T) {
+	b3 := legacyPropagator{header: "b3", sc: trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x0b, 0x03},
+		SpanID:  trace.SpanID{0x0b, 0x03},
+	})}
+	jaeger := legacyPropagator{header: "uber-trace-id", sc: trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x0e, 0x0a},
+		SpanID:  trace.SpanID{0x0e, 0x0a},
+	})}
+	cfg := newConfig(WithPropagators(propagation.TraceContext{}), WithExtractFallbacks(b3, jaeger))
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		expected trace.TraceID
+	}{
+		{
+			name:     "traceparent",
+			headers:  map[string]string{"traceparent": traceparent, "b3": "1", "uber-trace-id": "1"},
+			expected: trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+		},
+		{
+			name:     "b3",
+			headers:  map[string]string{"b3": "1", "uber-trace-id": "1"},
+			expected: b3.sc.TraceID(),
+		},
+		{
+			name:     "jaeger",
+			headers:  map[string]string{"uber-trace-id": "1"},
+			expected: jaeger.sc.TraceID(),
+		},
+		{
+			name:     "none",
+			headers:  map[string]string{},
+			expected: trace.TraceID{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{}
+			for k, v := range tc.headers {
+				msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+			}
+
+			ctx := cfg.Propagators.Extract(context.Background(), NewConsumerMessageCarrier(msg))
+			assert.Equal(t, tc.expected, trace.SpanContextFromContext(ctx).TraceID())
+		})
+	}
+
+	producerMsg := &sarama.ProducerMessage{}
+	cfg.Propagators.Inject(trace.ContextWithRemoteSpanContext(context.Background(), b3.sc), NewProducerMessageCarrier(producerMsg))
+	assert.Equal(t, []string{"traceparent"}, NewProducerMessageCarrier(producerMsg).Keys())
+}
+
+func TestExtractFailure(t *testing.T) {
+	b3 := legacyPropagator{header: "b3", sc: trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x0b, 0x03},
+		SpanID:  trace.SpanID{0x0b, 0x03},
+	})}
+	cfg := newConfig(WithPropagators(propagation.TraceContext{}), WithExtractFallbacks(b3))
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		parent   context.Context
+		expected string
+	}{
+		{
+			name:    "traceparent",
+			headers: map[string]string{"traceparent": traceparent},
+			parent:  context.Background(),
+		},
+		{
+			name:    "none",
+			headers: map[string]string{},
+			parent:  context.Background(),
+		},
+		{
+			name:     "malformed",
+			headers:  map[string]string{"traceparent": "malformed"},
+			parent:   context.Background(),
+			expected: "malformed",
+		},
+		{
+			name:     "conflict",
+			headers:  map[string]string{"traceparent": traceparent, "b3": "1"},
+			parent:   context.Background(),
+			expected: "conflict",
+		},
+		{
+			name:    "parent carries propagated span context",
+			headers: map[string]string{"traceparent": traceparent},
+			parent: cfg.Propagators.Extract(context.Background(), propagation.MapCarrier{
+				"traceparent": traceparent,
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{}
+			for k, v := range tc.headers {
+				msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+			}
+			carrier := NewConsumerMessageCarrier(msg)
+
+			extracted := cfg.Propagators.Extract(tc.parent, carrier)
+			assert.Equal(t, tc.expected, cfg.extractFailure(tc.parent, extracted, carrier))
+		})
+	}
+}
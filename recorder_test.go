@@ -0,0 +1,511 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	traceembedded "go.opentelemetry.io/otel/trace/embedded"
+)
+
+// The recorders below keep the SDK out of this module's dependencies while
+// still allowing unit tests to assert on the produced telemetry.
+
+// embeddedTracer allows embedding embedded.Tracer next to a Tracer method.
+type embeddedTracer = traceembedded.Tracer
+
+// spanRecorder is a trace.TracerProvider and trace.Tracer recording all
+// started spans.
+type spanRecorder struct {
+	traceembedded.TracerProvider
+	embeddedTracer
+
+	mtx    sync.Mutex
+	spans  []*recordedSpan
+	nextID uint64
+}
+
+func newSpanRecorder() *spanRecorder {
+	return &spanRecorder{}
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return r
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+
+	r.mtx.Lock()
+	r.nextID++
+	id := r.nextID
+	r.mtx.Unlock()
+
+	parent := trace.SpanContextFromContext(ctx)
+	if cfg.NewRoot() {
+		parent = trace.SpanContext{}
+	}
+	scc := trace.SpanContextConfig{TraceID: parent.TraceID(), TraceFlags: trace.FlagsSampled}
+	if !parent.IsValid() {
+		binary.BigEndian.PutUint64(scc.TraceID[8:], id)
+	}
+	binary.BigEndian.PutUint64(scc.SpanID[:], id)
+
+	s := &recordedSpan{
+		Span:   trace.SpanFromContext(context.Background()),
+		sc:     trace.NewSpanContext(scc),
+		name:   name,
+		kind:   cfg.SpanKind(),
+		parent: parent,
+		links:  cfg.Links(),
+		attrs:  cfg.Attributes(),
+	}
+	r.mtx.Lock()
+	r.spans = append(r.spans, s)
+	r.mtx.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// Spans returns all spans started so far.
+func (r *spanRecorder) Spans() []*recordedSpan {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]*recordedSpan(nil), r.spans...)
+}
+
+// Ended returns all spans ended so far.
+func (r *spanRecorder) Ended() []*recordedSpan {
+	var out []*recordedSpan
+	for _, s := range r.Spans() {
+		if s.Ended() {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type recordedEvent struct {
+	name  string
+	attrs []attribute.KeyValue
+}
+
+type recordedSpan struct {
+	trace.Span
+
+	sc     trace.SpanContext
+	name   string
+	kind   trace.SpanKind
+	parent trace.SpanContext
+	links  []trace.Link
+
+	mtx         sync.Mutex
+	attrs       []attribute.KeyValue
+	events      []recordedEvent
+	statusCode  codes.Code
+	statusDesc  string
+	ended       bool
+	endOptCount int
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func (s *recordedSpan) IsRecording() bool { return true }
+
+func (s *recordedSpan) SetName(name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.name = name
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.attrs = append(s.attrs, kv...)
+}
+
+func (s *recordedSpan) AddEvent(name string, opts ...trace.EventOption) {
+	cfg := trace.NewEventConfig(opts...)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// Like the SDK, ended spans drop further events.
+	if s.ended {
+		return
+	}
+	s.events = append(s.events, recordedEvent{name: name, attrs: cfg.Attributes()})
+}
+
+func (s *recordedSpan) AddLink(link trace.Link) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.links = append(s.links, link)
+}
+
+func (s *recordedSpan) RecordError(err error, opts ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	s.AddEvent("exception", opts...)
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, desc string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.statusCode, s.statusDesc = code, desc
+}
+
+func (s *recordedSpan) End(opts ...trace.SpanEndOption) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.ended = true
+	s.endOptCount = len(opts)
+}
+
+// Ended reports whether End has been called.
+func (s *recordedSpan) Ended() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.ended
+}
+
+// Attributes returns the last value recorded for each attribute key.
+func (s *recordedSpan) Attributes() map[attribute.Key]attribute.Value {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	out := make(map[attribute.Key]attribute.Value, len(s.attrs))
+	for _, kv := range s.attrs {
+		out[kv.Key] = kv.Value
+	}
+	return out
+}
+
+// Events returns the events added to the span.
+func (s *recordedSpan) Events() []recordedEvent {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]recordedEvent(nil), s.events...)
+}
+
+// Status returns the status code of the span.
+func (s *recordedSpan) Status() codes.Code {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.statusCode
+}
+
+// noopMeter allows embedding noop.Meter next to a Meter method.
+type noopMeter = noop.Meter
+
+type measurement struct {
+	value float64
+	attrs attribute.Set
+}
+
+// metricRecorder is a metric.MeterProvider and metric.Meter recording all
+// synchronous measurements and collecting observable instruments on demand.
+type metricRecorder struct {
+	embedded.MeterProvider
+	noopMeter
+
+	mtx          sync.Mutex
+	created      map[string]int
+	measurements map[string][]measurement
+	int64Cbs     map[string][]metric.Int64Callback
+	callbacks    []metric.Callback
+	boundaries   map[string][]float64
+	descriptions map[string][]string
+}
+
+func newMetricRecorder() *metricRecorder {
+	return &metricRecorder{
+		created:      make(map[string]int),
+		measurements: make(map[string][]measurement),
+		int64Cbs:     make(map[string][]metric.Int64Callback),
+		boundaries:   make(map[string][]float64),
+		descriptions: make(map[string][]string),
+	}
+}
+
+func (r *metricRecorder) Meter(string, ...metric.MeterOption) metric.Meter {
+	return r
+}
+
+func (r *metricRecorder) record(name string, value float64, attrs attribute.Set) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.measurements[name] = append(r.measurements[name], measurement{value: value, attrs: attrs})
+}
+
+func (r *metricRecorder) register(name string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.created[name]++
+}
+
+// Boundaries returns the bucket boundaries advised for the histogram with
+// name.
+func (r *metricRecorder) Boundaries(name string) []float64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.boundaries[name]
+}
+
+// Descriptions returns the units and descriptions the observable gauges
+// with name were created with.
+func (r *metricRecorder) Descriptions(name string) []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.descriptions[name]
+}
+
+// Registered returns the number of callbacks registered and not
+// unregistered yet.
+func (r *metricRecorder) Registered() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var n int
+	for _, cb := range r.callbacks {
+		if cb != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Created returns how often the instrument with name was created.
+func (r *metricRecorder) Created(name string) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.created[name]
+}
+
+func (r *metricRecorder) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	r.register(name)
+	return recordedInt64{name: name, r: r}, nil
+}
+
+func (r *metricRecorder) Int64UpDownCounter(name string, _ ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	r.register(name)
+	return recordedInt64{name: name, r: r}, nil
+}
+
+func (r *metricRecorder) Int64Histogram(name string, _ ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	r.register(name)
+	return recordedInt64{name: name, r: r}, nil
+}
+
+func (r *metricRecorder) Float64Histogram(name string, opts ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	r.register(name)
+	cfg := metric.NewFloat64HistogramConfig(opts...)
+	r.mtx.Lock()
+	r.boundaries[name] = cfg.ExplicitBucketBoundaries()
+	r.mtx.Unlock()
+	return recordedFloat64{name: name, r: r}, nil
+}
+
+func (r *metricRecorder) Int64ObservableGauge(name string, opts ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	r.register(name)
+	cfg := metric.NewInt64ObservableGaugeConfig(opts...)
+	r.mtx.Lock()
+	r.int64Cbs[name] = append(r.int64Cbs[name], cfg.Callbacks()...)
+	r.descriptions[name] = append(r.descriptions[name], cfg.Unit()+" "+cfg.Description())
+	r.mtx.Unlock()
+	return recordedObservable{name: name}, nil
+}
+
+func (r *metricRecorder) Int64ObservableUpDownCounter(name string, opts ...metric.Int64ObservableUpDownCounterOption) (metric.Int64ObservableUpDownCounter, error) {
+	r.register(name)
+	cfg := metric.NewInt64ObservableUpDownCounterConfig(opts...)
+	r.mtx.Lock()
+	r.int64Cbs[name] = append(r.int64Cbs[name], cfg.Callbacks()...)
+	r.mtx.Unlock()
+	return recordedObservableUpDownCounter{recordedObservable: recordedObservable{name: name}}, nil
+}
+
+func (r *metricRecorder) Int64ObservableCounter(name string, opts ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	r.register(name)
+	cfg := metric.NewInt64ObservableCounterConfig(opts...)
+	r.mtx.Lock()
+	r.int64Cbs[name] = append(r.int64Cbs[name], cfg.Callbacks()...)
+	r.mtx.Unlock()
+	return recordedObservableCounter{recordedObservable: recordedObservable{name: name}}, nil
+}
+
+func (r *metricRecorder) Float64ObservableGauge(name string, _ ...metric.Float64ObservableGaugeOption) (metric.Float64ObservableGauge, error) {
+	r.register(name)
+	return recordedFloat64Observable{name: name}, nil
+}
+
+func (r *metricRecorder) RegisterCallback(f metric.Callback, _ ...metric.Observable) (metric.Registration, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.callbacks = append(r.callbacks, f)
+	i := len(r.callbacks) - 1
+	return recordedRegistration{unregister: func() {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		r.callbacks[i] = nil
+	}}, nil
+}
+
+type recordedRegistration struct {
+	embedded.Registration
+
+	unregister func()
+}
+
+func (r recordedRegistration) Unregister() error {
+	r.unregister()
+	return nil
+}
+
+// Measurements returns all synchronous measurements recorded for name.
+func (r *metricRecorder) Measurements(name string) []measurement {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]measurement(nil), r.measurements[name]...)
+}
+
+// Sum returns the sum of all synchronous measurements recorded for name.
+func (r *metricRecorder) Sum(name string) float64 {
+	var sum float64
+	for _, m := range r.Measurements(name) {
+		sum += m.value
+	}
+	return sum
+}
+
+// Collect runs the callbacks of all observable instruments named name and
+// returns the observed values.
+func (r *metricRecorder) Collect(name string) []measurement {
+	r.mtx.Lock()
+	cbs := append([]metric.Int64Callback(nil), r.int64Cbs[name]...)
+	callbacks := append([]metric.Callback(nil), r.callbacks...)
+	r.mtx.Unlock()
+
+	o := &recordedObserver{name: name}
+	for _, cb := range cbs {
+		_ = cb(context.Background(), o)
+	}
+	for _, cb := range callbacks {
+		if cb != nil {
+			_ = cb(context.Background(), o)
+		}
+	}
+	return o.measurements
+}
+
+type recordedInt64 struct {
+	noop.Int64Counter
+	noop.Int64UpDownCounter
+	noop.Int64Histogram
+
+	name string
+	r    *metricRecorder
+}
+
+func (c recordedInt64) Add(_ context.Context, incr int64, opts ...metric.AddOption) {
+	c.r.record(c.name, float64(incr), metric.NewAddConfig(opts).Attributes())
+}
+
+func (c recordedInt64) Record(_ context.Context, incr int64, opts ...metric.RecordOption) {
+	c.r.record(c.name, float64(incr), metric.NewRecordConfig(opts).Attributes())
+}
+
+type recordedFloat64 struct {
+	noop.Float64Histogram
+
+	name string
+	r    *metricRecorder
+}
+
+func (c recordedFloat64) Record(_ context.Context, incr float64, opts ...metric.RecordOption) {
+	c.r.record(c.name, incr, metric.NewRecordConfig(opts).Attributes())
+}
+
+// observableName is implemented by all recorded observable instruments.
+type observableName interface {
+	observableName() string
+}
+
+type recordedObservable struct {
+	noop.Int64ObservableGauge
+
+	name string
+}
+
+func (o recordedObservable) observableName() string { return o.name }
+
+type recordedObservableUpDownCounter struct {
+	recordedObservable
+	embedded.Int64ObservableUpDownCounter
+}
+
+type recordedObservableCounter struct {
+	recordedObservable
+	embedded.Int64ObservableCounter
+}
+
+type recordedFloat64Observable struct {
+	noop.Float64ObservableGauge
+
+	name string
+}
+
+func (o recordedFloat64Observable) observableName() string { return o.name }
+
+type recordedObserver struct {
+	noop.Observer
+	noop.Int64Observer
+
+	name         string
+	measurements []measurement
+}
+
+func (o *recordedObserver) Observe(value int64, opts ...metric.ObserveOption) {
+	o.measurements = append(o.measurements, measurement{value: float64(value), attrs: metric.NewObserveConfig(opts).Attributes()})
+}
+
+func (o *recordedObserver) ObserveInt64(inst metric.Int64Observable, value int64, opts ...metric.ObserveOption) {
+	if n, ok := inst.(observableName); ok && n.observableName() == o.name {
+		o.Observe(value, opts...)
+	}
+}
+
+func (o *recordedObserver) ObserveFloat64(inst metric.Float64Observable, value float64, opts ...metric.ObserveOption) {
+	if n, ok := inst.(observableName); ok && n.observableName() == o.name {
+		o.measurements = append(o.measurements, measurement{value: value, attrs: metric.NewObserveConfig(opts).Attributes()})
+	}
+}
+
+// steppingClock returns a time source advancing by step on each call.
+func steppingClock(step time.Duration) func() time.Time {
+	var mtx sync.Mutex
+	now := time.Unix(0, 0)
+	return func() time.Time {
+		mtx.Lock()
+		defer mtx.Unlock()
+		now = now.Add(step)
+		return now
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// deliveryAttemptKey is the attribute key for the delivery attempt of a
+// processed message.
+const deliveryAttemptKey = attribute.Key("messaging.kafka.message.delivery_attempt")
+
+// redeliveries detects processed messages that were delivered before. A nil
+// redeliveries detects nothing.
+type redeliveries struct {
+	cfg     config
+	counter metric.Int64Counter
+
+	mtx sync.Mutex
+	// processed holds the highest offset processed per partition.
+	processed map[topicPartition]int64
+}
+
+// newRedeliveries returns the redeliveries of cfg, which is nil unless
+// WithDeliveryAttemptHeader is set.
+func newRedeliveries(cfg config) *redeliveries {
+	if cfg.DeliveryAttemptHeader == "" {
+		return nil
+	}
+	return &redeliveries{
+		cfg: cfg,
+		counter: cfg.int64Counter(
+			"messaging.kafka.consumer.redelivered_messages",
+			metric.WithUnit("{message}"),
+			metric.WithDescription("Number of processed messages that were delivered before."),
+		),
+		processed: make(map[topicPartition]int64),
+	}
+}
+
+// record counts msg if it was delivered before and returns the attributes
+// describing its delivery attempt. The attempt is read from the header
+// specified by WithDeliveryAttemptHeader. Without the header, msg is
+// considered redelivered if an offset at or after its offset was processed
+// before, e.g. after an offset reset.
+func (r *redeliveries) record(ctx context.Context, msg *sarama.ConsumerMessage) []attribute.KeyValue {
+	if r == nil {
+		return nil
+	}
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	r.mtx.Lock()
+	highest, seen := r.processed[tp]
+	if !seen || msg.Offset > highest {
+		r.processed[tp] = msg.Offset
+	}
+	r.mtx.Unlock()
+
+	var attrs []attribute.KeyValue
+	redelivered := seen && msg.Offset <= highest
+	if attempt, ok := r.headerAttempt(msg); ok {
+		attrs = append(attrs, deliveryAttemptKey.Int64(attempt))
+		redelivered = attempt > 1
+	}
+	if redelivered {
+		metricAttrs := []attribute.KeyValue{
+			semconv.MessagingSystem("kafka"),
+			r.cfg.destinationMetricAttribute(msg.Topic),
+			semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+		}
+		if r.cfg.ConsumerGroupID != "" {
+			metricAttrs = append(metricAttrs, semconv.MessagingKafkaConsumerGroup(r.cfg.ConsumerGroupID))
+		}
+		metricAttrs = append(metricAttrs, r.cfg.topicAttributes(msg.Topic)...)
+		metricAttrs = append(metricAttrs, r.cfg.Attributes...)
+		r.counter.Add(ctx, 1, r.cfg.withMetricAttributes(metricAttrs...))
+	}
+	return attrs
+}
+
+// headerAttempt returns the delivery attempt carried in the header of msg,
+// if it carries a valid one.
+func (r *redeliveries) headerAttempt(msg *sarama.ConsumerMessage) (int64, bool) {
+	for _, h := range msg.Headers {
+		if h == nil || !equalHeaderKey(h.Key, r.cfg.DeliveryAttemptHeader) {
+			continue
+		}
+		attempt, err := strconv.ParseInt(string(h.Value), 10, 64)
+		if err != nil || attempt < 1 {
+			return 0, false
+		}
+		return attempt, true
+	}
+	return 0, false
+}
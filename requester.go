@@ -0,0 +1,182 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultConversationIDHeader is the header a Requester sets the
+	// conversation ID of requests in if WithConversationIDHeader is not
+	// used.
+	defaultConversationIDHeader = "correlation-id"
+	// ReplyTopicHeader is the header a Requester sets the topic replies are
+	// expected on in.
+	ReplyTopicHeader = "reply-topic"
+)
+
+// Requester sends requests and waits for their replies over Kafka, i.e.
+// request-reply over a request and a reply topic. Each exchange is traced
+// in a client span, linked to the span context propagated in the reply.
+//
+// Requests carry a conversation ID in the header specified by
+// WithConversationIDHeader, "correlation-id" by default, and the reply topic
+// in the ReplyTopicHeader header. Responders must copy the conversation ID
+// into the reply. Replies are handed to the Requester with HandleReply, e.g.
+// from the loop consuming the reply topic.
+type Requester struct {
+	producer   sarama.SyncProducer
+	replyTopic string
+	header     string
+	cfg        config
+
+	mtx     sync.Mutex
+	pending map[string]chan *sarama.ConsumerMessage
+}
+
+// NewRequester returns a Requester sending requests with producer and
+// expecting replies on replyTopic.
+func NewRequester(producer sarama.SyncProducer, replyTopic string, opts ...Option) *Requester {
+	cfg := newConfig(opts...)
+	header := cfg.ConversationIDHeader
+	if header == "" {
+		header = defaultConversationIDHeader
+	}
+	return &Requester{
+		producer:   producer,
+		replyTopic: replyTopic,
+		header:     header,
+		cfg:        cfg,
+		pending:    make(map[string]chan *sarama.ConsumerMessage),
+	}
+}
+
+// Request sends msg and waits for its reply until ctx is done. The span
+// context of the client span is injected into msg, so msg is published as
+// its child.
+func (r *Requester) Request(ctx context.Context, msg *sarama.ProducerMessage) (*sarama.ConsumerMessage, error) {
+	id, err := newConversationID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := r.startSpan(ctx, msg.Topic, id)
+	defer span.End()
+
+	reply := make(chan *sarama.ConsumerMessage, 1)
+	r.mtx.Lock()
+	r.pending[id] = reply
+	r.mtx.Unlock()
+	defer func() {
+		r.mtx.Lock()
+		delete(r.pending, id)
+		r.mtx.Unlock()
+	}()
+
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(r.header), Value: []byte(id)},
+		sarama.RecordHeader{Key: []byte(ReplyTopicHeader), Value: []byte(r.replyTopic)},
+	)
+	r.cfg.Propagators.Inject(ctx, r.cfg.injectCarrier(NewProducerMessageCarrier(msg, r.cfg.CarrierOptions...)))
+	if _, _, err := r.producer.SendMessage(msg); err != nil {
+		r.recordError(span, err)
+		return nil, err
+	}
+
+	select {
+	case m := <-reply:
+		replyCtx := r.cfg.contextFromMessage(context.Background(), m)
+		if sc := trace.SpanContextFromContext(replyCtx); sc.IsValid() {
+			span.AddLink(trace.Link{SpanContext: sc})
+		}
+		return m, nil
+	case <-ctx.Done():
+		r.recordError(span, ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// HandleReply hands msg over to the Request waiting for it. It reports
+// whether such a Request exists, replies without one, e.g. to requests that
+// timed out, are dropped.
+func (r *Requester) HandleReply(msg *sarama.ConsumerMessage) bool {
+	var id string
+	for _, h := range msg.Headers {
+		if h != nil && equalHeaderKey(h.Key, r.header) {
+			id = string(h.Value)
+			break
+		}
+	}
+
+	r.mtx.Lock()
+	reply, ok := r.pending[id]
+	delete(r.pending, id)
+	r.mtx.Unlock()
+	if ok {
+		reply <- msg
+	}
+	return ok
+}
+
+func (r *Requester) startSpan(ctx context.Context, topic, id string) (context.Context, trace.Span) {
+	if r.cfg.TracesDisabled || !r.cfg.enabled() {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingMessageConversationID(id),
+	}
+	attrs = append(attrs, r.cfg.destinationTemplateAttributes(topic)...)
+	attrs = append(attrs, r.cfg.topicAttributes(topic)...)
+	attrs = append(attrs, r.cfg.Attributes...)
+	if r.cfg.PeerService != "" {
+		attrs = append(attrs, semconv.PeerService(r.cfg.PeerService))
+	}
+	return r.cfg.Tracer.Start(ctx, fmt.Sprintf("%s request", topic),
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+}
+
+func (r *Requester) recordError(span trace.Span, err error) {
+	errType := errorTypeKey.String(r.cfg.errorType(err))
+	span.SetAttributes(errType)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// newConversationID returns a random conversation ID.
+func newConversationID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,86 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRequester(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithTracerProvider(sr), WithPropagators(propagation.TraceContext{})}
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	requester := NewRequester(mockSyncProducer, "replies", opts...)
+
+	_, replySpan := sr.Tracer("responder").Start(context.Background(), "replies receive")
+	reply := &sarama.ConsumerMessage{Topic: "replies"}
+	mockSyncProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		// Respond from within SendMessage, copying the conversation ID under
+		// a differently cased key.
+		for _, h := range msg.Headers {
+			switch string(h.Key) {
+			case defaultConversationIDHeader:
+				reply.Headers = append(reply.Headers, &sarama.RecordHeader{Key: bytes.ToUpper(h.Key), Value: h.Value})
+			case ReplyTopicHeader:
+				assert.Equal(t, "replies", string(h.Value))
+			}
+		}
+		propagation.TraceContext{}.Inject(trace.ContextWithSpan(context.Background(), replySpan), NewConsumerMessageCarrier(reply))
+		assert.True(t, requester.HandleReply(reply))
+		return nil
+	})
+
+	got, err := requester.Request(context.Background(), &sarama.ProducerMessage{Topic: topic})
+	require.NoError(t, err)
+	assert.Same(t, reply, got)
+	assert.False(t, requester.HandleReply(reply))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, topic+" request", spans[0].name)
+	assert.Equal(t, trace.SpanKindClient, spans[0].kind)
+	assert.Equal(t, string(reply.Headers[0].Value), spans[0].Attributes()[semconv.MessagingMessageConversationIDKey].AsString())
+	require.Len(t, spans[0].links, 1)
+	assert.Equal(t, replySpan.SpanContext().WithRemote(true), spans[0].links[0].SpanContext)
+}
+
+func TestRequesterTimeout(t *testing.T) {
+	sr := newSpanRecorder()
+	mockSyncProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	requester := NewRequester(mockSyncProducer, "replies", WithTracerProvider(sr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := requester.Request(ctx, &sarama.ProducerMessage{Topic: topic})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status())
+	assert.Empty(t, spans[0].links)
+}
@@ -0,0 +1,82 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const publishAttemptKey = attribute.Key("messaging.kafka.producer.attempt")
+
+// retryInterceptor records the retries of sarama to send messages.
+type retryInterceptor struct {
+	cfg config
+
+	retries metric.Int64Counter
+}
+
+// InstrumentProducerRetries adds an interceptor to saramaConfig recording
+// the retries of sarama to send messages published by instrumented producers.
+// Each retry is counted as messaging.client.publish.retries and added as a
+// retry event to the publish span of the message. Messages without publish
+// span, e.g. because of WithoutTraces, are not recorded.
+//
+// It must be called before the producer is created from saramaConfig.
+func InstrumentProducerRetries(saramaConfig *sarama.Config, opts ...Option) {
+	for _, interceptor := range saramaConfig.Producer.Interceptors {
+		if _, ok := interceptor.(*retryInterceptor); ok {
+			return
+		}
+	}
+	cfg := newConfig(opts...)
+	saramaConfig.Producer.Interceptors = append(saramaConfig.Producer.Interceptors, &retryInterceptor{
+		cfg: cfg,
+		retries: cfg.int64Counter(
+			"messaging.client.publish.retries",
+			metric.WithUnit("{retry}"),
+			metric.WithDescription("Number of retries to send published messages."),
+		),
+	})
+}
+
+// OnSend is called by sarama for each attempt to send msg.
+func (i *retryInterceptor) OnSend(msg *sarama.ProducerMessage) {
+	v, ok := publishSpans.Load(msg)
+	if !ok {
+		return
+	}
+	span := v.(*publishSpan)
+	attempt := span.attempts.Add(1)
+	if attempt == 1 {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		i.cfg.destinationMetricAttribute(msg.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
+	}
+	attrs = append(attrs, i.cfg.topicAttributes(msg.Topic)...)
+	attrs = append(attrs, i.cfg.Attributes...)
+	i.retries.Add(context.Background(), 1, i.cfg.withMetricAttributes(attrs...))
+	span.AddEvent("retry", trace.WithAttributes(publishAttemptKey.Int64(attempt)))
+}
@@ -0,0 +1,64 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func TestInstrumentProducerRetries(t *testing.T) {
+	sr := newSpanRecorder()
+	mr := newMetricRecorder()
+	saramaConfig := newSaramaConfig()
+	InstrumentProducerRetries(saramaConfig, WithMeterProvider(mr))
+	InstrumentProducerRetries(saramaConfig, WithMeterProvider(mr))
+	require.Len(t, saramaConfig.Producer.Interceptors, 1)
+	interceptor := saramaConfig.Producer.Interceptors[0]
+
+	msg := &sarama.ProducerMessage{Topic: topic, Partition: 2}
+	interceptor.OnSend(msg)
+	span := startProducerSpan(newConfig(WithTracerProvider(sr)), saramaConfig, msg)
+	for i := 0; i < 3; i++ {
+		interceptor.OnSend(msg)
+	}
+	finishProducerSpan(newConfig(), span, msg, 2, 0, nil)
+	interceptor.OnSend(msg)
+
+	wantAttrs := attribute.NewSet(
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingKafkaDestinationPartition(2),
+	)
+	assert.Equal(t, []measurement{
+		{value: 1, attrs: wantAttrs},
+		{value: 1, attrs: wantAttrs},
+	}, mr.Measurements("messaging.client.publish.retries"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	events := spans[0].Events()
+	require.Len(t, events, 2)
+	for i, event := range events {
+		assert.Equal(t, "retry", event.name)
+		assert.Contains(t, event.attrs, publishAttemptKey.Int(i+2))
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RetryAttemptHeader is the header a RetryPublisher sets the retry
+	// attempt of republished messages in, starting at 1.
+	RetryAttemptHeader = "retry-attempt"
+	// RetryNotBeforeHeader is the header a RetryPublisher sets the time in
+	// Unix milliseconds in, before which republished messages must not be
+	// processed.
+	RetryNotBeforeHeader = "retry-not-before"
+	// RetryOriginalTopicHeader is the header a RetryPublisher sets the topic
+	// republished messages were originally consumed from in.
+	RetryOriginalTopicHeader = "retry-original-topic"
+)
+
+// messageDelayKey is the attribute key for the delay a republished message
+// is scheduled with.
+const messageDelayKey = attribute.Key("messaging.kafka.message.delay_ms")
+
+// RetryPublisher republishes messages that failed processing to a retry
+// topic, scheduled with a backoff delay consumers of the retry topic wait for
+// before processing them. Each republish is traced in a span created as
+// child of the context the message failed in, e.g. its process span, so
+// the retries continue its trace.
+type RetryPublisher struct {
+	producer   sarama.SyncProducer
+	retryTopic string
+	backoff    func(attempt int) time.Duration
+	cfg        config
+}
+
+// NewRetryPublisher returns a RetryPublisher republishing messages to
+// retryTopic with producer. backoff returns the delay of a retry attempt,
+// starting at 1; messages are republished without delay if it is nil.
+func NewRetryPublisher(producer sarama.SyncProducer, retryTopic string, backoff func(attempt int) time.Duration, opts ...Option) *RetryPublisher {
+	return &RetryPublisher{
+		producer:   producer,
+		retryTopic: retryTopic,
+		backoff:    backoff,
+		cfg:        newConfig(opts...),
+	}
+}
+
+// Retry republishes msg, which failed processing in ctx with cause, to the
+// retry topic. The retry attempt is the attempt in the RetryAttemptHeader
+// header of msg incremented by one. The headers of msg are copied, the retry
+// headers and the span context of the retry span are set.
+func (p *RetryPublisher) Retry(ctx context.Context, msg *sarama.ConsumerMessage, cause error) error {
+	attempt := 1
+	originalTopic := msg.Topic
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		switch strings.ToLower(string(h.Key)) {
+		case RetryAttemptHeader:
+			if n, err := strconv.Atoi(string(h.Value)); err == nil && n > 0 {
+				attempt = n + 1
+			}
+		case RetryOriginalTopicHeader:
+			originalTopic = string(h.Value)
+		}
+	}
+	var delay time.Duration
+	if p.backoff != nil {
+		delay = p.backoff(attempt)
+	}
+
+	retry := &sarama.ProducerMessage{Topic: p.retryTopic}
+	if msg.Key != nil {
+		retry.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		retry.Value = sarama.ByteEncoder(msg.Value)
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			retry.Headers = append(retry.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+	carrier := NewProducerMessageCarrier(retry)
+	carrier.Set(RetryAttemptHeader, strconv.Itoa(attempt))
+	carrier.Set(RetryNotBeforeHeader, strconv.FormatInt(p.cfg.now().Add(delay).UnixMilli(), 10))
+	carrier.Set(RetryOriginalTopicHeader, originalTopic)
+
+	ctx, span := p.startSpan(ctx, attempt, delay, cause)
+	defer span.End()
+	p.cfg.Propagators.Inject(ctx, p.cfg.injectCarrier(NewProducerMessageCarrier(retry, p.cfg.CarrierOptions...)))
+	if _, _, err := p.producer.SendMessage(retry); err != nil {
+		errType := errorTypeKey.String(p.cfg.errorType(err))
+		span.SetAttributes(errType)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// startSpan starts the span of republishing a message as its attempt with
+// delay, after processing it failed with cause.
+func (p *RetryPublisher) startSpan(ctx context.Context, attempt int, delay time.Duration, cause error) (context.Context, trace.Span) {
+	if p.cfg.TracesDisabled || !p.cfg.enabled() {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(p.retryTopic),
+		deliveryAttemptKey.Int(attempt),
+		messageDelayKey.Int64(delay.Milliseconds()),
+	}
+	attrs = append(attrs, p.cfg.destinationTemplateAttributes(p.retryTopic)...)
+	attrs = append(attrs, p.cfg.topicAttributes(p.retryTopic)...)
+	attrs = append(attrs, p.cfg.Attributes...)
+	ctx, span := p.cfg.Tracer.Start(ctx, fmt.Sprintf("%s retry", p.retryTopic),
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindProducer),
+	)
+	// The cause is recorded as event only, the retry itself succeeds.
+	span.RecordError(cause)
+	return ctx, span
+}
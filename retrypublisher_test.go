@@ -0,0 +1,77 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRetryPublisher(t *testing.T) {
+	sr := newSpanRecorder()
+	producer := mocks.NewSyncProducer(t, newSaramaConfig())
+	var retry *sarama.ProducerMessage
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		retry = msg
+		return nil
+	})
+	publisher := NewRetryPublisher(producer, "retry", func(attempt int) time.Duration {
+		return time.Duration(attempt) * time.Second
+	}, WithTracerProvider(sr), WithPropagators(propagation.TraceContext{}), WithTimeSource(func() time.Time {
+		return time.UnixMilli(1000)
+	}))
+
+	ctx, processSpan := sr.Start(context.Background(), "process")
+	msg := &sarama.ConsumerMessage{
+		Topic: topic,
+		Value: []byte("foo"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(RetryAttemptHeader), Value: []byte("1")},
+			{Key: []byte("tenant"), Value: []byte("acme")},
+		},
+	}
+	require.NoError(t, publisher.Retry(ctx, msg, errors.New("failed")))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "retry retry", span.name)
+	assert.Equal(t, trace.SpanKindProducer, span.kind)
+	assert.Equal(t, processSpan.SpanContext(), span.parent)
+	assert.Equal(t, int64(2), span.Attributes()[deliveryAttemptKey].AsInt64())
+	assert.Equal(t, int64(2000), span.Attributes()[messageDelayKey].AsInt64())
+	require.Len(t, span.Events(), 1)
+
+	require.NotNil(t, retry)
+	assert.Equal(t, "retry", retry.Topic)
+	carrier := NewProducerMessageCarrier(retry)
+	assert.Equal(t, "2", carrier.Get(RetryAttemptHeader))
+	assert.Equal(t, strconv.Itoa(3000), carrier.Get(RetryNotBeforeHeader))
+	assert.Equal(t, topic, carrier.Get(RetryOriginalTopicHeader))
+	assert.Equal(t, "acme", carrier.Get("tenant"))
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+	assert.Equal(t, span.sc.SpanID(), sc.SpanID())
+}
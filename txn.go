@@ -0,0 +1,91 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The functions below tie the transactional produce of an exactly-once
+// consume-transform-produce pipeline to the span processing the consumed
+// message, e.g. the span in the context passed to a Handler:
+//
+//	func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+//		out := transform(msg)
+//		otelsarama.InjectContext(ctx, out)
+//		if _, _, err := producer.SendMessage(out); err != nil {
+//			return err
+//		}
+//		if err := otelsarama.AddMessageToTxn(ctx, producer, msg, group, nil); err != nil {
+//			return err
+//		}
+//		return otelsarama.CommitTxn(ctx, producer)
+//	}
+
+// txnProducer is implemented by sarama.SyncProducer and sarama.AsyncProducer.
+type txnProducer interface {
+	AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error
+	CommitTxn() error
+	AbortTxn() error
+}
+
+// AddMessageToTxn calls producer.AddMessageToTxn and records the offset of
+// msg added to the transaction as event of the span in ctx. The options
+// configure how errors are classified, see WithErrorTypeMapper.
+func AddMessageToTxn(ctx context.Context, producer txnProducer, msg *sarama.ConsumerMessage, groupID string, metadata *string, opts ...Option) error {
+	err := producer.AddMessageToTxn(msg, groupID, metadata)
+	recordTxnEvent(ctx, newConfig(opts...), "transaction.add_offsets", err,
+		semconv.MessagingDestinationName(msg.Topic),
+		semconv.MessagingKafkaSourcePartition(int(msg.Partition)),
+		semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		semconv.MessagingKafkaConsumerGroup(groupID),
+	)
+	return err
+}
+
+// CommitTxn calls producer.CommitTxn and records the commit as event of the
+// span in ctx. The options are the ones of AddMessageToTxn.
+func CommitTxn(ctx context.Context, producer txnProducer, opts ...Option) error {
+	err := producer.CommitTxn()
+	recordTxnEvent(ctx, newConfig(opts...), "transaction.commit", err)
+	return err
+}
+
+// AbortTxn calls producer.AbortTxn and records the abort as event of the
+// span in ctx. The options are the ones of AddMessageToTxn.
+func AbortTxn(ctx context.Context, producer txnProducer, opts ...Option) error {
+	err := producer.AbortTxn()
+	recordTxnEvent(ctx, newConfig(opts...), "transaction.abort", err)
+	return err
+}
+
+// recordTxnEvent adds an event describing a transaction operation to the
+// span in ctx. A failed operation is recorded as error of the span.
+func recordTxnEvent(ctx context.Context, cfg config, name string, err error, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		attrs = append(attrs, errorTypeKey.String(cfg.errorType(err)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.AddEvent(name, trace.WithAttributes(attrs...))
+}
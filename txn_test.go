@@ -0,0 +1,97 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otelsarama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// failingTxnProducer fails to commit transactions.
+type failingTxnProducer struct {
+	sarama.SyncProducer
+}
+
+func (failingTxnProducer) CommitTxn() error { return sarama.ErrInvalidTxnState }
+
+func TestConsumeTransformProduce(t *testing.T) {
+	sr := newSpanRecorder()
+	opts := []Option{WithTracerProvider(sr), WithPropagators(propagation.TraceContext{})}
+	mockProducer := mocks.NewSyncProducer(t, newSaramaConfig())
+	mockProducer.ExpectSendMessageAndSucceed()
+	producer := WrapSyncProducer(newSaramaConfig(), mockProducer, opts...)
+
+	consumed := &sarama.ConsumerMessage{Topic: topic, Partition: 1, Offset: 7}
+	process := Instrument(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		out := &sarama.ProducerMessage{Topic: "output-topic"}
+		InjectContext(ctx, out, opts...)
+		if _, _, err := producer.SendMessage(out); err != nil {
+			return err
+		}
+		if err := AddMessageToTxn(ctx, producer, msg, "my-group", nil); err != nil {
+			return err
+		}
+		return CommitTxn(ctx, producer)
+	}, opts...)
+	require.NoError(t, process(context.Background(), consumed))
+
+	spans := sr.Spans()
+	require.Len(t, spans, 2)
+	processSpan, publishSpan := spans[0], spans[1]
+	assert.Equal(t, "output-topic publish", publishSpan.name)
+	assert.Equal(t, processSpan.sc.SpanID(), publishSpan.parent.SpanID())
+
+	events := processSpan.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "transaction.add_offsets", events[0].name)
+	assert.Equal(t, "transaction.commit", events[1].name)
+	assert.Equal(t, codes.Unset, processSpan.Status())
+}
+
+func TestCommitTxnError(t *testing.T) {
+	sr := newSpanRecorder()
+	ctx, span := sr.Start(context.Background(), "process")
+
+	err := CommitTxn(ctx, failingTxnProducer{})
+	assert.ErrorIs(t, err, sarama.ErrInvalidTxnState)
+
+	recorded := span.(*recordedSpan)
+	assert.Equal(t, codes.Error, recorded.Status())
+	events := recorded.Events()
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	assert.Equal(t, "transaction.commit", last.name)
+	assert.Contains(t, last.attrs, errorTypeKey.String(sarama.ErrInvalidTxnState.Error()))
+}
+
+func TestCommitTxnWithErrorTypeMapper(t *testing.T) {
+	sr := newSpanRecorder()
+	ctx, span := sr.Start(context.Background(), "process")
+
+	err := CommitTxn(ctx, failingTxnProducer{}, WithErrorTypeMapper(func(error) string { return "txn" }))
+	assert.ErrorIs(t, err, sarama.ErrInvalidTxnState)
+
+	events := span.(*recordedSpan).Events()
+	require.NotEmpty(t, events)
+	assert.Contains(t, events[len(events)-1].attrs, errorTypeKey.String("txn"))
+}